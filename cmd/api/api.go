@@ -1,29 +1,32 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
-	vault "github.com/hashicorp/vault/api"
-	"github.com/joho/godotenv"
+	"github.com/juaguz/yuno/cmd/internal/bootstrap"
 	_ "github.com/juaguz/yuno/docs"
 	"github.com/juaguz/yuno/internal/cards"
 	"github.com/juaguz/yuno/internal/cards/dtos"
 	"github.com/juaguz/yuno/internal/cards/repositories"
 	"github.com/juaguz/yuno/internal/keys"
+	"github.com/juaguz/yuno/internal/relay"
+	"github.com/juaguz/yuno/kit/audit"
 	"github.com/juaguz/yuno/kit/database"
-	"github.com/juaguz/yuno/kit/kms"
 	"github.com/juaguz/yuno/kit/users/auth"
 	"github.com/juaguz/yuno/kit/users/repository"
 	kitvault "github.com/juaguz/yuno/kit/vault"
+	apiKeysApi "github.com/juaguz/yuno/pkg/apikeys/api"
 	"github.com/juaguz/yuno/pkg/cards/api"
 	keysApi "github.com/juaguz/yuno/pkg/keys/api"
+	relayApi "github.com/juaguz/yuno/pkg/relay/api"
 	httpSwagger "github.com/swaggo/http-swagger"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
 )
 
 func jsonResponseMiddleware(next http.Handler) http.Handler {
@@ -34,58 +37,190 @@ func jsonResponseMiddleware(next http.Handler) http.Handler {
 }
 
 func main() {
-	err := godotenv.Load()
+	bootstrap.LoadEnv()
+
+	db, err := bootstrap.OpenDatabase()
 	if err != nil {
-		log.Println("Error loading .env file")
+		panic(err)
 	}
 
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(db, os.Args[2:])
+		return
+	}
 
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		dbHost, dbPort, dbUser, dbPassword, dbName)
+	if err := checkSchema(context.Background(), db); err != nil {
+		log.Fatalf("refusing to start: %s, run `%s migrate up`", err, binary)
+	}
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	v, err := bootstrap.NewVaultClient()
 	if err != nil {
 		panic(err)
 	}
 
-	vaultAddress := os.Getenv("VAULT_ADDRESS")
-	v, err := vault.NewClient(&vault.Config{
-		Address: vaultAddress,
-	})
+	cardRepo := repositories.NewCardRepository(db)
+
+	vaultService := kitvault.NewVaultService(v)
+
+	kmsService, err := bootstrap.NewKmsService(v, db)
 	if err != nil {
 		panic(err)
 	}
 
-	v.SetToken(os.Getenv("VAULT_TOKEN"))
+	minKeyVersion, err := bootstrap.Int("MIN_KEY_VERSION", 0)
+	if err != nil {
+		panic(err)
+	}
+	cardOpts := []cards.Option{cards.WithMinKeyVersion(minKeyVersion)}
+
+	if v := os.Getenv("CARD_DUPLICATE_POLICY"); v != "" {
+		policy := cards.DuplicatePolicy(v)
+		switch policy {
+		case cards.DuplicateAllow, cards.DuplicateReject, cards.DuplicateReturnExisting:
+		default:
+			panic(fmt.Errorf("unknown CARD_DUPLICATE_POLICY %q", v))
+		}
+		cardOpts = append(cardOpts, cards.WithDuplicatePolicy(policy))
+	}
 
-	cardRepo := repositories.NewCardRepository(db)
+	fingerprintKey := bootstrap.FingerprintKey()
+	if err := kmsService.CreateHMACKey(context.Background(), fingerprintKey); err != nil {
+		panic(fmt.Errorf("creating fingerprint key: %w", err))
+	}
+	cardOpts = append(cardOpts, cards.WithFingerprintKey(fingerprintKey))
 
-	vaultService := kitvault.NewVaultService(v)
+	secretCompensateAfter, err := bootstrap.Duration("SECRET_COMPENSATE_AFTER", cards.DefaultSecretCompensateAfter)
+	if err != nil {
+		panic(err)
+	}
 
-	kmsService := kms.NewVaultKmsService(v)
+	secretOutboxInterval, err := bootstrap.Duration("SECRET_OUTBOX_INTERVAL", 10*time.Second)
+	if err != nil {
+		panic(err)
+	}
 
-	cardService := cards.NewCardService(cardRepo, kmsService, vaultService)
+	secretOperationRepo := repositories.NewSecretOperationRepository(db)
+	auditRepo := audit.NewRepository(db)
+	secretOutbox := cards.NewSecretOutbox(vaultService, cardRepo, secretOperationRepo, auditRepo, secretCompensateAfter, cards.DefaultSecretBackoff)
+	go secretOutbox.Run(context.Background(), secretOutboxInterval)
+	cardOpts = append(cardOpts, cards.WithSecretStore(secretOutbox))
+
+	cardService := cards.NewCardService(cardRepo, kmsService, vaultService, cardOpts...)
 
 	transactionalService := database.NewTransactionalRepository[dtos.Card](db, cardService)
 
-	batchupdater := cards.NewBatchUpdater(cardService)
+	batchJobInterval, err := bootstrap.Duration("BATCH_JOB_INTERVAL", 5*time.Second)
+	if err != nil {
+		panic(err)
+	}
 
-	cardsHandler := api.NewCardHandler(transactionalService, batchupdater)
+	batchWorkers, err := bootstrap.Int("BATCH_WORKERS", cards.DefaultBatchWorkers)
+	if err != nil {
+		panic(err)
+	}
+	if batchWorkers < 1 {
+		// without workers the batches would never be applied
+		panic(fmt.Errorf("BATCH_WORKERS must be at least 1, got %d", batchWorkers))
+	}
+	batchItemTimeout, err := bootstrap.Duration("BATCH_ITEM_TIMEOUT", cards.DefaultBatchItemTimeout)
+	if err != nil {
+		panic(err)
+	}
+	batchMaxSize, err := bootstrap.Int("BATCH_MAX_SIZE", cards.DefaultMaxBatchSize)
+	if err != nil {
+		panic(err)
+	}
+	batchMaxUserJobs, err := bootstrap.Int("BATCH_MAX_USER_JOBS", cards.DefaultMaxUserBatchJobs)
+	if err != nil {
+		panic(err)
+	}
+	batchMaxQueuedItems, err := bootstrap.Int("BATCH_MAX_QUEUED_ITEMS", cards.DefaultMaxQueuedBatchItems)
+	if err != nil {
+		panic(err)
+	}
+
+	batchUpdater := cards.NewBatchUpdater(cardService, database.NewTransactor(db),
+		cards.WithWorkerPool(cards.NewWorkerPool(batchWorkers)),
+		cards.WithItemTimeout(batchItemTimeout),
+	)
+	batchJobRepo := repositories.NewBatchJobRepository(db)
+	batchJobs := cards.NewBatchJobs(batchUpdater, batchJobRepo, cards.DefaultBatchJobLease,
+		cards.WithMaxBatchSize(batchMaxSize),
+		cards.WithMaxUserJobs(batchMaxUserJobs),
+		cards.WithMaxQueuedItems(batchMaxQueuedItems),
+	)
+	go batchJobs.Run(context.Background(), batchJobInterval)
+
+	revealPermission := os.Getenv("REVEAL_PERMISSION")
+	if revealPermission == "" {
+		revealPermission = cards.DefaultRevealPermission
+	}
+	revealer := cards.NewRevealer(cardService, auditRepo, revealPermission)
+
+	cardsHandler := api.NewCardHandler(transactionalService, batchJobs, revealer, cardService)
 
 	userRepo := repository.NewUserRepository(db)
 
-	keycloakURL := os.Getenv("KEYCLOAK_URL")
-	realm := os.Getenv("KEYCLOAK_REALM")
+	issuers, err := bootstrap.OIDCIssuers()
+	if err != nil {
+		panic(err)
+	}
+	verifier, err := auth.NewVerifier(issuers)
+	if err != nil {
+		panic(err)
+	}
 
-	jwtMiddleware := auth.JWTMiddleware(userRepo, keycloakURL, realm)
+	apiKeys := auth.NewAPIKeys(repository.NewAPIKeyRepository(db), auditRepo)
+	apiKeysHandler := apiKeysApi.NewAPIKeysHandler(apiKeys)
+
+	authOptions := []auth.MiddlewareOption{auth.WithAPIKeys(apiKeys)}
+	if os.Getenv("USER_PROVISIONING") == "true" {
+		authOptions = append(authOptions, auth.WithProvisioning(userRepo))
+	}
+
+	jwtMiddleware := auth.JWTMiddleware(userRepo, verifier, authOptions...)
+
+	relayTimeout, err := bootstrap.Duration("RELAY_TIMEOUT", 30*time.Second)
+	if err != nil {
+		panic(err)
+	}
+
+	var relayHosts []string
+	if v := os.Getenv("RELAY_ALLOWED_HOSTS"); v != "" {
+		relayHosts = strings.Split(v, ",")
+	}
+
+	var relayOpts []relay.Option
+	if v := os.Getenv("RELAY_PLAIN_HTTP_HOSTS"); v != "" {
+		relayOpts = append(relayOpts, relay.WithPlainHTTPHosts(strings.Split(v, ",")))
+	}
+
+	relayService := relay.NewRelay(cardService, auditRepo, relayHosts, relayTimeout, relayOpts...)
+	relayHandler := relayApi.NewRelayHandler(relayService)
+
+	rewrapInterval, err := bootstrap.Duration("REWRAP_INTERVAL", time.Minute)
+	if err != nil {
+		panic(err)
+	}
+
+	rewrapJobRepo := repositories.NewRewrapJobRepository(db)
+	rewrapper := cards.NewRewrapper(cardRepo, kmsService, vaultService, rewrapJobRepo, database.NewTransactor(db),
+		cards.WithRewrapSecretStore(secretOutbox),
+	)
+	go rewrapper.Run(context.Background(), rewrapInterval)
+
+	// the in-process reconciliation only reports, repairs go through cmd/reconcile
+	reconcileInterval, err := bootstrap.Duration("RECONCILE_INTERVAL", 0)
+	if err != nil {
+		panic(err)
+	}
+	if reconcileInterval > 0 {
+		reconciler := cards.NewReconciler(cardService, cardRepo, vaultService, secretOperationRepo, cards.DefaultReconcileMinAge)
+		go reconciler.Run(context.Background(), reconcileInterval)
+	}
 
 	keysProvider := keys.NewKeysProvider(kmsService)
-	keysHandler := keysApi.NewKeysHandler(keysProvider)
+	keysHandler := keysApi.NewKeysHandler(keysProvider, rewrapper)
 
 	r := chi.NewRouter()
 	r.Use(jwtMiddleware)
@@ -95,8 +230,15 @@ func main() {
 	// @in header
 	// @name Authorization
 	// @description Type "Bearer" followed by a space and JWT token.
+
+	// @securityDefinitions.apikey ApiKey
+	// @in header
+	// @name X-API-Key
+	// @description API key issued through /api-keys, accepted in place of a JWT.
 	r.Mount("/cards", cardsHandler.Routes())
 	r.Mount("/keys", keysHandler.Routes())
+	r.Mount("/relay", relayHandler.Routes())
+	r.Mount("/api-keys", apiKeysHandler.Routes())
 	r.Get("/swagger/*", httpSwagger.WrapHandler)
 	if err := http.ListenAndServe(os.Getenv("APP_ADDRESS"), r); err != nil {
 		log.Fatalf("error starting server: %s", err)
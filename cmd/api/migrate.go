@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/juaguz/yuno/cmd/internal/bootstrap"
+	"github.com/juaguz/yuno/internal/cards/models"
+	"github.com/juaguz/yuno/internal/migrations"
+	"github.com/juaguz/yuno/kit/audit"
+	"github.com/juaguz/yuno/kit/database"
+	"github.com/juaguz/yuno/kit/kms"
+	"github.com/juaguz/yuno/kit/users/dto"
+	"gorm.io/gorm"
+)
+
+// binary is the name the API was started with, myapp in the Docker image.
+var binary = filepath.Base(os.Args[0])
+
+var migrateUsage = fmt.Sprintf("usage: %s migrate status | up | down [steps]", binary)
+
+// schemaModels are the gorm models checked against the database on startup.
+var schemaModels = []interface{}{
+	&dto.User{},
+	&dto.APIKey{},
+	&models.Card{},
+	&models.RewrapJob{},
+	&models.BatchJob{},
+	&models.BatchJobItem{},
+	&models.SecretOperation{},
+	&audit.Event{},
+	&kms.KmsKey{},
+}
+
+func newMigrator(db *gorm.DB, opts ...database.MigratorOption) (*database.Migrator, error) {
+	all, err := migrations.All()
+	if err != nil {
+		return nil, fmt.Errorf("loading migrations: %w", err)
+	}
+
+	return database.NewMigrator(db, all, opts...), nil
+}
+
+// migrateOptions passes the configuration the migrations need. The users created
+// before they were identified by issuer belong to the first trusted issuer.
+func migrateOptions() []database.MigratorOption {
+	issuers, err := bootstrap.OIDCIssuers()
+	if err != nil {
+		// migrations needing an issuer fail when there are users to assign
+		log.Printf("no trusted issuer for the existing users: %s", err)
+		return nil
+	}
+	if len(issuers) == 0 {
+		return nil
+	}
+
+	return []database.MigratorOption{
+		database.WithSetting(migrations.UsersIssuerSetting, issuers[0].Issuer),
+	}
+}
+
+// checkSchema fails when migrations are pending or the models don't match the tables.
+func checkSchema(ctx context.Context, db *gorm.DB) error {
+	migrator, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	if err := migrator.Check(ctx); err != nil {
+		return err
+	}
+
+	return migrator.CheckModels(ctx, schemaModels...)
+}
+
+func runMigrate(db *gorm.DB, args []string) {
+	ctx := context.Background()
+
+	if len(args) == 0 {
+		log.Fatal(migrateUsage)
+	}
+
+	var opts []database.MigratorOption
+	if args[0] == "up" {
+		opts = migrateOptions()
+	}
+
+	migrator, err := newMigrator(db, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch args[0] {
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			log.Printf("applied %04d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatal(migrateUsage)
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, m := range reverted {
+			log.Printf("reverted %04d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
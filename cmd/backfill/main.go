@@ -0,0 +1,49 @@
+// Command backfill fixes the last digits of the cards stored before they were
+// taken from the end of the PAN and fingerprints the cards that have none.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/juaguz/yuno/cmd/internal/bootstrap"
+	"github.com/juaguz/yuno/internal/cards"
+	"github.com/juaguz/yuno/internal/cards/repositories"
+	kitvault "github.com/juaguz/yuno/kit/vault"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "check the cards without updating them")
+	flag.Parse()
+
+	bootstrap.LoadEnv()
+
+	db, err := bootstrap.OpenDatabase()
+	if err != nil {
+		log.Fatalf("opening database: %s", err)
+	}
+
+	v, err := bootstrap.NewVaultClient()
+	if err != nil {
+		log.Fatalf("creating vault client: %s", err)
+	}
+
+	kmsService, err := bootstrap.NewKmsService(v, db)
+	if err != nil {
+		log.Fatalf("creating kms service: %s", err)
+	}
+
+	cardRepo := repositories.NewCardRepository(db)
+	cardService := cards.NewCardService(cardRepo, kmsService, kitvault.NewVaultService(v),
+		cards.WithFingerprintKey(bootstrap.FingerprintKey()))
+
+	backfill := cards.NewLastDigitsBackfill(cardService, cardRepo)
+	result, err := backfill.Run(context.Background(), *dryRun)
+	if err != nil {
+		log.Fatalf("backfill stopped after %d cards: %s", result.Migrated+result.Skipped+result.Failed, err)
+	}
+
+	log.Printf("backfill finished: %d migrated, %d skipped with an invalid PAN, %d failed, dry run: %t",
+		result.Migrated, result.Skipped, result.Failed, *dryRun)
+}
@@ -0,0 +1,171 @@
+// Package bootstrap builds the dependencies shared by the commands from the environment.
+package bootstrap
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/joho/godotenv"
+	"github.com/juaguz/yuno/internal/cards"
+	"github.com/juaguz/yuno/internal/keys"
+	"github.com/juaguz/yuno/kit/kms"
+	"github.com/juaguz/yuno/kit/users/auth"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func LoadEnv() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Println("Error loading .env file")
+	}
+}
+
+func OpenDatabase() (*gorm.DB, error) {
+	dbHost := os.Getenv("DB_HOST")
+	dbPort := os.Getenv("DB_PORT")
+	dbUser := os.Getenv("DB_USER")
+	dbPassword := os.Getenv("DB_PASSWORD")
+	dbName := os.Getenv("DB_NAME")
+
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		dbHost, dbPort, dbUser, dbPassword, dbName)
+
+	return gorm.Open(postgres.Open(dsn), &gorm.Config{})
+}
+
+func NewVaultClient() (*vault.Client, error) {
+	vaultAddress := os.Getenv("VAULT_ADDRESS")
+	v, err := vault.NewClient(&vault.Config{
+		Address: vaultAddress,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	v.SetToken(os.Getenv("VAULT_TOKEN"))
+
+	return v, nil
+}
+
+type KmsService interface {
+	cards.KmsRepository
+	cards.RewrapKmsRepository
+	keys.KmsRepo
+	CreateHMACKey(ctx context.Context, keyID string) error
+}
+
+// NewKmsService picks the KMS backend from KMS_BACKEND: "vault" (default) uses the
+// transit engine, "local" keeps wrapped RSA keys on disk or in Postgres.
+func NewKmsService(v *vault.Client, db *gorm.DB) (KmsService, error) {
+	switch backend := os.Getenv("KMS_BACKEND"); backend {
+	case "", "vault":
+		return kms.NewVaultKmsService(v), nil
+	case "local":
+		masterKey, err := base64.StdEncoding.DecodeString(os.Getenv("KMS_MASTER_KEY"))
+		if err != nil {
+			return nil, fmt.Errorf("decoding KMS_MASTER_KEY: %w", err)
+		}
+
+		var store kms.KeyStore
+		switch os.Getenv("KMS_LOCAL_STORE") {
+		case "", "file":
+			path := os.Getenv("KMS_LOCAL_PATH")
+			if path == "" {
+				path = ".kms"
+			}
+			store, err = kms.NewFileKeyStore(path)
+			if err != nil {
+				return nil, err
+			}
+		case "postgres":
+			store = kms.NewDBKeyStore(db)
+		default:
+			return nil, fmt.Errorf("unknown KMS_LOCAL_STORE %q", os.Getenv("KMS_LOCAL_STORE"))
+		}
+
+		return kms.NewLocalKmsService(store, masterKey)
+	default:
+		return nil, fmt.Errorf("unknown KMS_BACKEND %q", backend)
+	}
+}
+
+// OIDCIssuers returns the trusted token issuers, read as a JSON array from
+// OIDC_ISSUERS. When unset, the Keycloak realm KEYCLOAK_REALM at KEYCLOAK_URL is
+// the only one trusted, for tokens issued to KEYCLOAK_AUDIENCE and, when set, to
+// the client KEYCLOAK_CLIENT_ID, whose roles then grant permissions.
+func OIDCIssuers() ([]auth.IssuerConfig, error) {
+	if v := os.Getenv("OIDC_ISSUERS"); v != "" {
+		var issuers []auth.IssuerConfig
+		if err := json.Unmarshal([]byte(v), &issuers); err != nil {
+			return nil, fmt.Errorf("parsing OIDC_ISSUERS: %w", err)
+		}
+
+		return issuers, nil
+	}
+
+	keycloakURL := os.Getenv("KEYCLOAK_URL")
+	realm := os.Getenv("KEYCLOAK_REALM")
+	audience := os.Getenv("KEYCLOAK_AUDIENCE")
+	if keycloakURL == "" || realm == "" || audience == "" {
+		return nil, fmt.Errorf("either OIDC_ISSUERS or KEYCLOAK_URL, KEYCLOAK_REALM and KEYCLOAK_AUDIENCE are required")
+	}
+
+	issuer := auth.IssuerConfig{
+		Issuer:    fmt.Sprintf("%s/realms/%s", keycloakURL, realm),
+		Audiences: []string{audience},
+	}
+	if clientID := os.Getenv("KEYCLOAK_CLIENT_ID"); clientID != "" {
+		issuer.AuthorizedParties = []string{clientID}
+		issuer.ClientID = clientID
+	}
+
+	return []auth.IssuerConfig{issuer}, nil
+}
+
+// FingerprintKey returns the KMS key PANs are fingerprinted with, FINGERPRINT_KEY
+// or cards.DefaultFingerprintKey when unset.
+func FingerprintKey() string {
+	if key := os.Getenv("FINGERPRINT_KEY"); key != "" {
+		return key
+	}
+
+	return cards.DefaultFingerprintKey
+}
+
+// Int reads an int from the environment variable name, def when unset.
+func Int(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %s: %w", name, err)
+	}
+
+	return n, nil
+}
+
+// Duration reads a time.Duration from the environment variable name, def when unset.
+func Duration(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %s: %w", name, err)
+	}
+
+	return d, nil
+}
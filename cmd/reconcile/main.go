@@ -0,0 +1,58 @@
+// Command reconcile reports the card rows without a Vault secret and the
+// secrets without a card row, and optionally repairs or purges them.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/juaguz/yuno/cmd/internal/bootstrap"
+	"github.com/juaguz/yuno/internal/cards"
+	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/internal/cards/repositories"
+	kitvault "github.com/juaguz/yuno/kit/vault"
+)
+
+func main() {
+	mode := flag.String("mode", string(dtos.ReconcileReportOnly), `"report", "repair" to rebuild the cards of orphaned secrets or "purge" to delete the drift`)
+	dryRun := flag.Bool("dry-run", false, "report what would be repaired or purged without changing anything")
+	minAge := flag.Duration("min-age", cards.DefaultReconcileMinAge, "skip the cards created more recently than this")
+	flag.Parse()
+
+	bootstrap.LoadEnv()
+
+	db, err := bootstrap.OpenDatabase()
+	if err != nil {
+		log.Fatalf("opening database: %s", err)
+	}
+
+	v, err := bootstrap.NewVaultClient()
+	if err != nil {
+		log.Fatalf("creating vault client: %s", err)
+	}
+
+	kmsService, err := bootstrap.NewKmsService(v, db)
+	if err != nil {
+		log.Fatalf("creating kms service: %s", err)
+	}
+
+	cardRepo := repositories.NewCardRepository(db)
+	vaultService := kitvault.NewVaultService(v)
+	cardService := cards.NewCardService(cardRepo, kmsService, vaultService,
+		cards.WithFingerprintKey(bootstrap.FingerprintKey()))
+
+	reconciler := cards.NewReconciler(cardService, cardRepo, vaultService, repositories.NewSecretOperationRepository(db), *minAge)
+	result, err := reconciler.Reconcile(context.Background(), dtos.ReconcileMode(*mode), *dryRun)
+	if err != nil {
+		log.Fatalf("reconcile stopped: %s", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		log.Fatalf("writing result: %s", err)
+	}
+}
@@ -56,3 +56,9 @@ $KCADM create users -r $REALM_NAME -s username=$USER_NAME -s enabled=true \
 $KCADM set-password -r $REALM_NAME --username $USER_NAME --new-password $USER_PASSWORD --server $KEYCLOAK_URL
 
 
+# Create the realm roles the API requires and grant them to the user
+for ROLE in cards:read cards:write cards:reveal cards:relay keys:read keys:create keys:rotate keys:admin apikeys:admin; do
+  echo "Creating realm role '$ROLE'..."
+  $KCADM create roles -r $REALM_NAME -s name=$ROLE --server $KEYCLOAK_URL
+  $KCADM add-roles -r $REALM_NAME --uusername $USER_NAME --rolename $ROLE --server $KEYCLOAK_URL
+done
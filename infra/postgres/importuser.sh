@@ -3,6 +3,8 @@
 # Keycloak configuration
 KEYCLOAK_URL="http://keycloak:8080"
 REALM_NAME="myrealm"
+# the iss of the tokens of the realm, users are identified by it and their ID
+ISSUER="http://localhost:8080/realms/$REALM_NAME"
 CLIENT_ID="admin-cli"
 ADMIN_USER="admin"
 ADMIN_PASSWORD="admin"
@@ -45,8 +47,8 @@ for row in $(echo "${USERS}" | jq -r '.[] | @base64'); do
 
   # Connect to PostgreSQL and execute the insert
   PGPASSWORD=$DB_PASSWORD psql -h $DB_HOST -p $DB_PORT -U $DB_USER -d $DB_NAME <<EOF
-  INSERT INTO users (user_id, username, email) VALUES ('$USER_ID', '$USERNAME', '$EMAIL')
-  ON CONFLICT (user_id) DO NOTHING;
+  INSERT INTO users (issuer, user_id, username, email) VALUES ('$ISSUER', '$USER_ID', '$USERNAME', '$EMAIL')
+  ON CONFLICT (issuer, user_id) DO NOTHING;
 EOF
 done
 
@@ -0,0 +1,61 @@
+package cards
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"github.com/juaguz/yuno/kit/users/auth"
+)
+
+// caller returns the ID of the authenticated user of the request.
+func caller(ctx context.Context) (uuid.UUID, error) {
+	user, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: %s", senital.ErrUnauthenticated, err)
+	}
+
+	return user.ID, nil
+}
+
+// ownedCard returns the stored card when it belongs to the caller. Cards of other
+// users are reported as not found, same as missing ones, so IDs can't be probed.
+func (c *CardService) ownedCard(ctx context.Context, id uuid.UUID) (*dtos.Card, error) {
+	userID, err := caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	card, err := c.CardRepository.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return ownedBy(card, userID)
+}
+
+// lockOwnedCard is ownedCard for the writes, the card stays locked until the
+// transaction of ctx ends, so it can't change between the check and the write.
+func (c *CardService) lockOwnedCard(ctx context.Context, id uuid.UUID) (*dtos.Card, error) {
+	userID, err := caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	card, err := c.CardRepository.GetForUpdate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return ownedBy(card, userID)
+}
+
+func ownedBy(card *dtos.Card, userID uuid.UUID) (*dtos.Card, error) {
+	if card == nil || card.UserId != userID {
+		return nil, senital.ErrNotFound
+	}
+
+	return card, nil
+}
@@ -0,0 +1,110 @@
+package cards
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/internal/cards/validation"
+)
+
+const backfillPageSize = 100
+
+type LegacyDigitsRepository interface {
+	ListLegacyDigits(ctx context.Context, after uuid.UUID, limit int) ([]*dtos.Card, error)
+	UpdatePanMetadata(ctx context.Context, card *dtos.Card) error
+	MarkInvalidPan(ctx context.Context, id uuid.UUID) error
+}
+
+// BackfillResult counts the cards of a run. Skipped cards hold a PAN that fails
+// the validation, Failed ones are tried again by the next run.
+type BackfillResult struct {
+	Migrated int
+	Skipped  int
+	Failed   int
+}
+
+// LastDigitsBackfill fixes the cards stored when the first four digits of the
+// PAN were saved as last digits. The PAN is read back from Vault to recompute
+// the last digits, BIN, brand and length, and to fingerprint the cards stored
+// before fingerprints existed. Migrated rows, and rows whose PAN fails the
+// validation, are excluded from the next runs, so it can be stopped and started
+// again at any time.
+type LastDigitsBackfill struct {
+	CardService *CardService
+	Repository  LegacyDigitsRepository
+}
+
+func NewLastDigitsBackfill(cardService *CardService, repository LegacyDigitsRepository) *LastDigitsBackfill {
+	return &LastDigitsBackfill{
+		CardService: cardService,
+		Repository:  repository,
+	}
+}
+
+// Run migrates every legacy card. With dryRun the cards are checked but not updated.
+func (b *LastDigitsBackfill) Run(ctx context.Context, dryRun bool) (*BackfillResult, error) {
+	result := &BackfillResult{}
+	after := uuid.Nil
+
+	for {
+		cards, err := b.Repository.ListLegacyDigits(ctx, after, backfillPageSize)
+		if err != nil {
+			return result, err
+		}
+
+		for _, card := range cards {
+			after = card.ID
+
+			err := b.migrate(ctx, card, dryRun)
+			if errors.Is(err, validation.ErrInvalidPan) {
+				log.Printf("backfill: card %s: skipped: %s", card.ID, err)
+				result.Skipped++
+				continue
+			}
+			if err != nil {
+				log.Printf("backfill: card %s: %s", card.ID, err)
+				result.Failed++
+				continue
+			}
+			result.Migrated++
+		}
+
+		if len(cards) < backfillPageSize {
+			return result, nil
+		}
+	}
+}
+
+func (b *LastDigitsBackfill) migrate(ctx context.Context, card *dtos.Card, dryRun bool) error {
+	pan, err := b.CardService.decryptPan(ctx, card)
+	if err != nil {
+		return err
+	}
+
+	network, err := validation.Validate(pan)
+	if err != nil {
+		if dryRun {
+			return err
+		}
+		if markErr := b.Repository.MarkInvalidPan(ctx, card.ID); markErr != nil {
+			return markErr
+		}
+		return err
+	}
+
+	setPanMetadata(card, pan, network)
+
+	card.Fingerprint, err = b.CardService.fingerprint(ctx, pan)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	return b.Repository.UpdatePanMetadata(ctx, card)
+}
@@ -0,0 +1,360 @@
+package cards
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"github.com/juaguz/yuno/kit/users/auth"
+	"github.com/juaguz/yuno/kit/users/dto"
+)
+
+const (
+	batchJobPageSize = 100
+	// DefaultBatchJobLease is how long a claimed job is held by an instance
+	// without saving progress before another one can take it over.
+	DefaultBatchJobLease = 5 * time.Minute
+
+	DefaultMaxBatchSize = 1000
+	// DefaultMaxUserBatchJobs is how many unfinished jobs a user may have.
+	DefaultMaxUserBatchJobs = 10
+	// DefaultMaxQueuedBatchItems is how many items may wait in the queue, across users.
+	DefaultMaxQueuedBatchItems = 100000
+)
+
+var (
+	ErrEmptyBatch    = errors.New("the batch has no cards")
+	ErrBatchTooLarge = errors.New("the batch has too many cards")
+	// ErrTooManyBatchJobs rejects a batch of a user who has too many jobs waiting
+	ErrTooManyBatchJobs = errors.New("too many batches in progress")
+	// ErrBatchQueueFull rejects every batch until the queue drains
+	ErrBatchQueueFull = errors.New("the batch queue is full")
+)
+
+type BatchJobRepository interface {
+	Create(ctx context.Context, job *dtos.BatchJob, items []*dtos.BatchJobItem) error
+	Get(ctx context.Context, id uuid.UUID) (*dtos.BatchJob, error)
+	ListItems(ctx context.Context, jobID uuid.UUID) ([]*dtos.BatchJobItem, error)
+	ListPendingItems(ctx context.Context, jobID uuid.UUID, limit int) ([]*dtos.BatchJobItem, error)
+	Claim(ctx context.Context, now time.Time, lockedUntil time.Time) (*dtos.BatchJob, error)
+	CountActiveByUser(ctx context.Context, userID uuid.UUID) (int, error)
+	CountQueuedItems(ctx context.Context) (int, error)
+	SaveProgress(ctx context.Context, job *dtos.BatchJob, items []*dtos.BatchJobItem, lockedUntil time.Time) error
+}
+
+// BatchJobs queues batches of card creates, updates and deletes in Postgres and
+// applies them in the background, so a batch outlives the request that submitted
+// it. Jobs are claimed with a lease, a job whose instance died is picked up again
+// once the lease expires and only its unsaved page is repeated. Updating or
+// deleting a card twice is harmless. The card of a create item is picked when
+// the item is queued, so a repeated item finds the card of its earlier attempt
+// instead of creating another one, whatever the duplicate policy.
+//
+// Submissions are limited in size, and rejected while the user or the whole
+// queue has too much work waiting, so the workers are never handed more than
+// they can drain.
+type BatchJobs struct {
+	Updater        *BatchUpdater
+	Repository     BatchJobRepository
+	Lease          time.Duration
+	MaxBatchSize   int
+	MaxUserJobs    int
+	MaxQueuedItems int
+	now            func() time.Time
+}
+
+type BatchJobsOption func(*BatchJobs)
+
+// WithMaxBatchSize rejects the batches of more than size cards.
+func WithMaxBatchSize(size int) BatchJobsOption {
+	return func(b *BatchJobs) {
+		b.MaxBatchSize = size
+	}
+}
+
+// WithMaxUserJobs rejects the batches of a user with jobs unfinished jobs.
+func WithMaxUserJobs(jobs int) BatchJobsOption {
+	return func(b *BatchJobs) {
+		b.MaxUserJobs = jobs
+	}
+}
+
+// WithMaxQueuedItems rejects every batch while items are waiting in the queue.
+func WithMaxQueuedItems(items int) BatchJobsOption {
+	return func(b *BatchJobs) {
+		b.MaxQueuedItems = items
+	}
+}
+
+func NewBatchJobs(updater *BatchUpdater, repository BatchJobRepository, lease time.Duration, options ...BatchJobsOption) *BatchJobs {
+	b := &BatchJobs{
+		Updater:        updater,
+		Repository:     repository,
+		Lease:          lease,
+		MaxBatchSize:   DefaultMaxBatchSize,
+		MaxUserJobs:    DefaultMaxUserBatchJobs,
+		MaxQueuedItems: DefaultMaxQueuedBatchItems,
+		now:            time.Now,
+	}
+	for _, option := range options {
+		option(b)
+	}
+
+	return b
+}
+
+// Submit queues the updates of the user, they are applied by Run. The updates of
+// an atomic job are applied all together or not at all, so an item that can't
+// be valid rejects the whole batch right away.
+func (b *BatchJobs) Submit(ctx context.Context, userID uuid.UUID, cards []*dtos.BatchUpdate, atomic bool) (*dtos.BatchJob, error) {
+	if atomic {
+		for i, card := range cards {
+			if card.ID == uuid.Nil {
+				return nil, fmt.Errorf("%w: item %d has no card ID", ErrInvalidBatchItem, i)
+			}
+		}
+	}
+
+	items := make([]*dtos.BatchJobItem, 0, len(cards))
+	for i, card := range cards {
+		items = append(items, &dtos.BatchJobItem{
+			Position:   i,
+			CardID:     card.ID,
+			CardHolder: card.CardHolder,
+			Status:     dtos.Pending,
+		})
+	}
+
+	return b.submit(ctx, &dtos.BatchJob{UserID: userID, Operation: dtos.OperationUpdate, Atomic: atomic}, items)
+}
+
+// SubmitCreate queues the cards of the user to be stored by Run. The PAN
+// ciphertexts are kept in the queue until their card is processed.
+func (b *BatchJobs) SubmitCreate(ctx context.Context, userID uuid.UUID, cards []*dtos.BatchCreate) (*dtos.BatchJob, error) {
+	items := make([]*dtos.BatchJobItem, 0, len(cards))
+	for i, card := range cards {
+		items = append(items, &dtos.BatchJobItem{
+			Position: i,
+			// the idempotency key of the item, see BatchUpdater.Create
+			CardID:      uuid.New(),
+			CardHolder:  card.CardHolder,
+			Pan:         card.Pan,
+			KeyVersion:  card.KeyVersion,
+			ExpiryMonth: card.ExpiryMonth,
+			ExpiryYear:  card.ExpiryYear,
+			Status:      dtos.Pending,
+		})
+	}
+
+	return b.submit(ctx, &dtos.BatchJob{UserID: userID, Operation: dtos.OperationCreate}, items)
+}
+
+// SubmitDelete queues the cards of the user to be removed by Run.
+func (b *BatchJobs) SubmitDelete(ctx context.Context, userID uuid.UUID, cards []*dtos.BatchDelete) (*dtos.BatchJob, error) {
+	items := make([]*dtos.BatchJobItem, 0, len(cards))
+	for i, card := range cards {
+		items = append(items, &dtos.BatchJobItem{
+			Position: i,
+			CardID:   card.ID,
+			Status:   dtos.Pending,
+		})
+	}
+
+	return b.submit(ctx, &dtos.BatchJob{UserID: userID, Operation: dtos.OperationDelete}, items)
+}
+
+func (b *BatchJobs) submit(ctx context.Context, job *dtos.BatchJob, items []*dtos.BatchJobItem) (*dtos.BatchJob, error) {
+	if len(items) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	if len(items) > b.MaxBatchSize {
+		return nil, fmt.Errorf("%w: %d cards, at most %d", ErrBatchTooLarge, len(items), b.MaxBatchSize)
+	}
+
+	active, err := b.Repository.CountActiveByUser(ctx, job.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if active >= b.MaxUserJobs {
+		return nil, fmt.Errorf("%w: %d unfinished", ErrTooManyBatchJobs, active)
+	}
+
+	queued, err := b.Repository.CountQueuedItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if queued+len(items) > b.MaxQueuedItems {
+		return nil, fmt.Errorf("%w: %d items queued", ErrBatchQueueFull, queued)
+	}
+
+	job.Status = dtos.BatchJobPending
+	if err := b.Repository.Create(ctx, job, items); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// Get returns a job of the user with the outcome of each item, jobs of other
+// users are reported as not found.
+func (b *BatchJobs) Get(ctx context.Context, userID uuid.UUID, jobID uuid.UUID) (*dtos.BatchJob, error) {
+	job, err := b.Repository.Get(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.UserID != userID {
+		return nil, senital.ErrNotFound
+	}
+
+	job.Items, err = b.Repository.ListItems(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.Operation == dtos.OperationCreate {
+		for _, item := range job.Items {
+			// the card picked for the item doesn't exist until it is processed
+			if item.Status == dtos.Pending {
+				item.CardID = uuid.Nil
+			}
+		}
+	}
+
+	return job, nil
+}
+
+// Run processes the queued jobs every interval until ctx is cancelled.
+func (b *BatchJobs) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		b.processQueued(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *BatchJobs) processQueued(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := b.now()
+		job, err := b.Repository.Claim(ctx, now, now.Add(b.Lease))
+		if errors.Is(err, senital.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			log.Printf("batch: claiming job: %s", err)
+			return
+		}
+
+		if err := b.Process(ctx, job); err != nil {
+			log.Printf("batch: job %s: %s", job.ID, err)
+		}
+	}
+}
+
+// Process applies the pending items of a claimed job, saving the outcomes after
+// each page. Atomic jobs are applied in a single page. When ctx is cancelled, the
+// outcomes so far are saved and the job is released for another instance.
+func (b *BatchJobs) Process(ctx context.Context, job *dtos.BatchJob) error {
+	// the card service only updates the cards of the authenticated user
+	userCtx := context.WithValue(ctx, auth.UserKey, &dto.User{ID: job.UserID})
+
+	pageSize := batchJobPageSize
+	if job.Atomic {
+		// larger than the job, so its first page is also its last
+		pageSize = job.Total + 1
+	}
+
+	for {
+		items, err := b.Repository.ListPendingItems(ctx, job.ID, pageSize)
+		if err != nil {
+			return err
+		}
+
+		statuses, err := b.apply(userCtx, job, items)
+		if err != nil {
+			return err
+		}
+
+		// items left pending weren't applied, they are picked up again with the job
+		processed := make([]*dtos.BatchJobItem, 0, len(items))
+		for i, item := range items {
+			switch statuses[i].Status {
+			case dtos.Succeeded:
+				job.Succeeded++
+			case dtos.Failed:
+				job.Failed++
+			default:
+				continue
+			}
+
+			item.CardID = statuses[i].CardID
+			item.Status = statuses[i].Status
+			item.Error = statuses[i].Error
+			processed = append(processed, item)
+		}
+
+		lockedUntil := b.now().Add(b.Lease)
+		if ctx.Err() != nil {
+			lockedUntil = b.now()
+		} else if len(items) < pageSize {
+			job.Status = dtos.BatchJobCompleted
+		}
+
+		// saved even when ctx is cancelled, so the applied items aren't applied twice
+		if err := b.Repository.SaveProgress(context.WithoutCancel(ctx), job, processed, lockedUntil); err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if job.Status == dtos.BatchJobCompleted {
+			return nil
+		}
+	}
+}
+
+// apply runs the items with the operation of the job.
+func (b *BatchJobs) apply(ctx context.Context, job *dtos.BatchJob, items []*dtos.BatchJobItem) ([]*dtos.BatchUpdateStatus, error) {
+	switch job.Operation {
+	case dtos.OperationCreate:
+		cards := make([]*dtos.BatchCreate, 0, len(items))
+		for _, item := range items {
+			cards = append(cards, &dtos.BatchCreate{
+				ID:          item.CardID,
+				CardHolder:  item.CardHolder,
+				Pan:         item.Pan,
+				KeyVersion:  item.KeyVersion,
+				ExpiryMonth: item.ExpiryMonth,
+				ExpiryYear:  item.ExpiryYear,
+			})
+		}
+		return b.Updater.Create(ctx, job.UserID, cards)
+	case dtos.OperationDelete:
+		cards := make([]*dtos.BatchDelete, 0, len(items))
+		for _, item := range items {
+			cards = append(cards, &dtos.BatchDelete{ID: item.CardID})
+		}
+		return b.Updater.Delete(ctx, job.UserID, cards)
+	default:
+		cards := make([]*dtos.BatchUpdate, 0, len(items))
+		for _, item := range items {
+			cards = append(cards, &dtos.BatchUpdate{ID: item.CardID, CardHolder: item.CardHolder})
+		}
+		if job.Atomic {
+			return b.Updater.UpdateAtomic(ctx, job.UserID, cards)
+		}
+		return b.Updater.Update(ctx, job.UserID, cards)
+	}
+}
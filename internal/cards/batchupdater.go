@@ -1,61 +1,367 @@
 package cards
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/internal/cards/validation"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"github.com/juaguz/yuno/kit/kms"
 )
 
+var ErrInvalidBatchItem = errors.New("invalid batch item")
+
+// invalidItemErrors are the errors of items the request itself got wrong, their
+// message is written for clients.
+var invalidItemErrors = []error{
+	ErrInvalidBatchItem,
+	validation.ErrInvalidPan,
+	ErrInvalidExpiry,
+	ErrInvalidKeyVersion,
+	ErrKeyVersionTooOld,
+	ErrDuplicateCard,
+}
+
+// Transactor runs fn in a database transaction, rolled back when fn fails.
+type Transactor interface {
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// DefaultBatchItemTimeout bounds how long an item of a batch may take.
+const DefaultBatchItemTimeout = 10 * time.Second
+
+// BatchUpdater applies the items of batches on a WorkerPool. Items still waiting
+// for a worker when ctx is cancelled are left pending, along with the ones
+// interrupted by the cancellation, so they can be applied later.
 type BatchUpdater struct {
 	CardService *CardService
+	Transactor  Transactor
+	Pool        *WorkerPool
+	ItemTimeout time.Duration
+}
+
+type BatchOption func(*BatchUpdater)
+
+// WithWorkerPool runs the items on pool, shared with other updaters.
+func WithWorkerPool(pool *WorkerPool) BatchOption {
+	return func(b *BatchUpdater) {
+		b.Pool = pool
+	}
 }
 
-func NewBatchUpdater(cardService *CardService) *BatchUpdater {
-	return &BatchUpdater{
+// WithItemTimeout fails the items that take longer than timeout, 0 disables it.
+func WithItemTimeout(timeout time.Duration) BatchOption {
+	return func(b *BatchUpdater) {
+		b.ItemTimeout = timeout
+	}
+}
+
+func NewBatchUpdater(cardService *CardService, transactor Transactor, options ...BatchOption) *BatchUpdater {
+	b := &BatchUpdater{
 		CardService: cardService,
+		Transactor:  transactor,
+		ItemTimeout: DefaultBatchItemTimeout,
+	}
+	for _, option := range options {
+		option(b)
+	}
+
+	if b.Pool == nil {
+		b.Pool = NewWorkerPool(DefaultBatchWorkers)
 	}
+
+	return b
 }
 
+// batchItem applies an item of a batch and returns its card, index is its
+// position in the batch.
+type batchItem struct {
+	index int
+	apply func(ctx context.Context) (uuid.UUID, error)
+}
+
+// Update applies the updates with a pool of workers, the statuses are returned
+// in the order of cards.
 func (b *BatchUpdater) Update(ctx context.Context, userID uuid.UUID, cards []*dtos.BatchUpdate) ([]*dtos.BatchUpdateStatus, error) {
-	const numWorkers = 15
-	jobs := make(chan *dtos.BatchUpdate, len(cards))
-	results := make(chan *dtos.BatchUpdateStatus, len(cards))
-	for w := 0; w < numWorkers; w++ {
-		go b.worker(ctx, jobs, results, userID)
+	items := make([]batchItem, 0, len(cards))
+	for i, card := range cards {
+		items = append(items, batchItem{index: i, apply: func(ctx context.Context) (uuid.UUID, error) {
+			if card.ID == uuid.Nil {
+				return card.ID, fmt.Errorf("%w: the card ID is required", ErrInvalidBatchItem)
+			}
+
+			return card.ID, b.CardService.Update(ctx, &dtos.Card{
+				ID:         card.ID,
+				CardHolder: card.CardHolder,
+				UserId:     userID,
+			})
+		}})
 	}
 
-	for _, card := range cards {
-		jobs <- card
+	return b.run(ctx, items), nil
+}
+
+// Create stores the cards with a pool of workers, each one the same as a single
+// create. The statuses are returned in the order of cards, with the ID of the
+// created card. A card whose ID is set and already stored was created by an
+// earlier attempt of the item, it succeeds without being created again.
+func (b *BatchUpdater) Create(ctx context.Context, userID uuid.UUID, cards []*dtos.BatchCreate) ([]*dtos.BatchUpdateStatus, error) {
+	items := make([]batchItem, 0, len(cards))
+	for i, card := range cards {
+		items = append(items, batchItem{index: i, apply: func(ctx context.Context) (uuid.UUID, error) {
+			if card.Pan == "" {
+				return uuid.Nil, fmt.Errorf("%w: the PAN is required", ErrInvalidBatchItem)
+			}
+
+			id := card.ID
+			if id == uuid.Nil {
+				id = uuid.New()
+			} else {
+				_, err := b.CardService.ownedCard(ctx, id)
+				if err == nil {
+					return id, nil
+				}
+				if !errors.Is(err, senital.ErrNotFound) {
+					return uuid.Nil, err
+				}
+			}
+
+			created, err := b.CardService.create(ctx, id, &dtos.Card{
+				CardHolder:  card.CardHolder,
+				Pan:         card.Pan,
+				UserId:      userID,
+				KeyVersion:  card.KeyVersion,
+				ExpiryMonth: card.ExpiryMonth,
+				ExpiryYear:  card.ExpiryYear,
+			})
+			if err != nil {
+				return uuid.Nil, err
+			}
+
+			return created.ID, nil
+		}})
 	}
-	close(jobs)
 
-	var updateStatus []*dtos.BatchUpdateStatus
-	for i := 0; i < len(cards); i++ {
-		updateStatus = append(updateStatus, <-results)
+	return b.run(ctx, items), nil
+}
+
+// Delete removes the cards and their secrets with a pool of workers, each one the
+// same as a single delete. The statuses are returned in the order of cards.
+func (b *BatchUpdater) Delete(ctx context.Context, userID uuid.UUID, cards []*dtos.BatchDelete) ([]*dtos.BatchUpdateStatus, error) {
+	items := make([]batchItem, 0, len(cards))
+	for i, card := range cards {
+		items = append(items, batchItem{index: i, apply: func(ctx context.Context) (uuid.UUID, error) {
+			if card.ID == uuid.Nil {
+				return card.ID, fmt.Errorf("%w: the card ID is required", ErrInvalidBatchItem)
+			}
+
+			return card.ID, b.CardService.Delete(ctx, &dtos.Card{ID: card.ID, UserId: userID})
+		}})
 	}
-	close(results)
 
-	return updateStatus, nil
+	return b.run(ctx, items), nil
 }
 
-func (b *BatchUpdater) worker(ctx context.Context, jobs <-chan *dtos.BatchUpdate, results chan<- *dtos.BatchUpdateStatus, userID uuid.UUID) {
-	for card := range jobs {
-		c := &dtos.Card{
-			ID:         card.ID,
-			CardHolder: card.CardHolder,
-			UserId:     userID,
+// run applies the items on the pool, each one in its own transaction like the
+// single card operations. The statuses are in the order of the items.
+func (b *BatchUpdater) run(ctx context.Context, items []batchItem) []*dtos.BatchUpdateStatus {
+	statuses := make([]*dtos.BatchUpdateStatus, len(items))
+	var wg sync.WaitGroup
+	for _, item := range items {
+		wg.Add(1)
+		// each task writes the status at the index of its item, so no two share a slot
+		err := b.Pool.Submit(ctx, func() {
+			defer wg.Done()
+			statuses[item.index] = b.apply(ctx, item)
+		})
+		if err != nil {
+			wg.Done()
+			break
 		}
+	}
+	wg.Wait()
 
-		err := b.CardService.Update(ctx, c)
-		status := &dtos.BatchUpdateStatus{
-			CardID: c.ID,
+	for i, status := range statuses {
+		if status == nil {
+			statuses[i] = &dtos.BatchUpdateStatus{Index: i, Status: dtos.Pending}
 		}
-		if err != nil {
-			status.Status = dtos.Failed
-		} else {
-			status.Status = dtos.Succeeded
+	}
+
+	return statuses
+}
+
+// UpdateAtomic applies all the updates or none of them, in a single transaction.
+// Every card is checked and locked before anything is written, in the order of
+// their IDs so concurrent batches can't deadlock, then the updates run one after
+// the other. When an item fails, it carries the cause and the other ones are
+// reported as aborted. When ctx is cancelled, they are all left pending.
+func (b *BatchUpdater) UpdateAtomic(ctx context.Context, userID uuid.UUID, cards []*dtos.BatchUpdate) ([]*dtos.BatchUpdateStatus, error) {
+	order := make([]int, len(cards))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return bytes.Compare(cards[order[a]].ID[:], cards[order[b]].ID[:]) < 0
+	})
+
+	failed := -1
+	err := b.Transactor.InTx(ctx, func(ctx context.Context) error {
+		for _, i := range order {
+			if err := b.lock(ctx, cards[i]); err != nil {
+				failed = i
+				return err
+			}
+		}
+
+		for i, card := range cards {
+			itemCtx, cancel := b.itemContext(ctx)
+			err := b.CardService.Update(itemCtx, &dtos.Card{
+				ID:         card.ID,
+				CardHolder: card.CardHolder,
+				UserId:     userID,
+			})
+			cancel()
+			if err != nil {
+				failed = i
+				return err
+			}
 		}
-		results <- status
+		return nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return pendingStatuses(cards), nil
+	}
+	if err != nil {
+		// failed stays -1 when the commit itself failed
+		return abortedStatuses(cards, failed, err), nil
+	}
+
+	statuses := make([]*dtos.BatchUpdateStatus, 0, len(cards))
+	for i, card := range cards {
+		statuses = append(statuses, &dtos.BatchUpdateStatus{Index: i, CardID: card.ID, Status: dtos.Succeeded})
+	}
+
+	return statuses, nil
+}
+
+// lock fails with the error the update of card would fail with, without writing,
+// and locks the card until the transaction of ctx ends.
+func (b *BatchUpdater) lock(ctx context.Context, card *dtos.BatchUpdate) error {
+	if card.ID == uuid.Nil {
+		return fmt.Errorf("%w: the card ID is required", ErrInvalidBatchItem)
+	}
+
+	ctx, cancel := b.itemContext(ctx)
+	defer cancel()
+
+	_, err := b.CardService.lockOwnedCard(ctx, card.ID)
+	return err
+}
+
+func pendingStatuses(cards []*dtos.BatchUpdate) []*dtos.BatchUpdateStatus {
+	statuses := make([]*dtos.BatchUpdateStatus, 0, len(cards))
+	for i, card := range cards {
+		statuses = append(statuses, &dtos.BatchUpdateStatus{Index: i, CardID: card.ID, Status: dtos.Pending})
+	}
+
+	return statuses
+}
+
+// abortedStatuses fails every item of cards, the one at failed with err and the
+// others as aborted, or all of them with err when failed is -1. Aborted items are
+// retryable when the cause is.
+func abortedStatuses(cards []*dtos.BatchUpdate, failed int, err error) []*dtos.BatchUpdateStatus {
+	cause := batchError(err)
+	if cause.Code == dtos.ErrorInternal {
+		log.Printf("batch: atomic batch of %d cards: %s", len(cards), err)
+	}
+
+	statuses := make([]*dtos.BatchUpdateStatus, 0, len(cards))
+	for i, card := range cards {
+		status := &dtos.BatchUpdateStatus{Index: i, CardID: card.ID, Status: dtos.Failed, Error: cause}
+		if failed >= 0 && i != failed {
+			status.Error = &dtos.BatchError{
+				Code:      dtos.ErrorAborted,
+				Message:   fmt.Sprintf("not applied, item %d failed", failed),
+				Retryable: cause.Retryable,
+			}
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// apply runs item in a transaction, within the item timeout.
+func (b *BatchUpdater) apply(ctx context.Context, item batchItem) *dtos.BatchUpdateStatus {
+	itemCtx, cancel := b.itemContext(ctx)
+	defer cancel()
+
+	var cardID uuid.UUID
+	err := b.Transactor.InTx(itemCtx, func(ctx context.Context) error {
+		var err error
+		cardID, err = item.apply(ctx)
+		return err
+	})
+
+	status := &dtos.BatchUpdateStatus{
+		Index:  item.index,
+		CardID: cardID,
+		Status: dtos.Succeeded,
+	}
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		// interrupted rather than failed, the transaction was rolled back
+		status.Status = dtos.Pending
+	default:
+		status.Status = dtos.Failed
+		status.Error = batchError(err)
+		if status.Error.Code == dtos.ErrorInternal {
+			log.Printf("batch: item %d, card %s: %s", item.index, cardID, err)
+		}
+	}
+
+	return status
+}
+
+func (b *BatchUpdater) itemContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if b.ItemTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, b.ItemTimeout)
+}
+
+// batchError describes why an item failed. Like the problem details of the
+// handlers, only the messages of client errors are exposed.
+func batchError(err error) *dtos.BatchError {
+	for _, invalid := range invalidItemErrors {
+		if errors.Is(err, invalid) {
+			return &dtos.BatchError{Code: dtos.ErrorInvalid, Message: err.Error()}
+		}
+	}
+
+	switch {
+	case errors.Is(err, kms.ErrInvalidCiphertext):
+		return &dtos.BatchError{Code: dtos.ErrorInvalid, Message: "the PAN can't be decrypted with the key"}
+	case errors.Is(err, senital.ErrNotFound):
+		return &dtos.BatchError{Code: dtos.ErrorNotFound, Message: "card not found"}
+	case errors.Is(err, senital.ErrForbidden), errors.Is(err, senital.ErrUnauthenticated):
+		return &dtos.BatchError{Code: dtos.ErrorForbidden, Message: "not allowed to change the card"}
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return &dtos.BatchError{Code: dtos.ErrorTimeout, Message: "the item didn't complete in time", Retryable: true}
+	case errors.Is(err, senital.ErrUnavailable):
+		return &dtos.BatchError{Code: dtos.ErrorUnavailable, Message: "a service the item depends on is unavailable", Retryable: true}
+	default:
+		return &dtos.BatchError{Code: dtos.ErrorInternal, Message: "internal error"}
 	}
 }
@@ -3,16 +3,62 @@ package cards_test
 import (
 	"context"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/juaguz/yuno/internal/cards"
 	"github.com/juaguz/yuno/internal/cards/dtos"
 	"github.com/juaguz/yuno/internal/cards/mocks"
+	"github.com/juaguz/yuno/kit/audit"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"github.com/juaguz/yuno/kit/kms"
+	"github.com/juaguz/yuno/kit/users/auth"
+	"github.com/juaguz/yuno/kit/users/dto"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 )
 
+// transactor runs the functions as is, counting the ones rolled back.
+type transactor struct {
+	mu        sync.Mutex
+	rollbacks int
+}
+
+func (t *transactor) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	err := fn(ctx)
+	if err != nil {
+		t.mu.Lock()
+		t.rollbacks++
+		t.mu.Unlock()
+	}
+	return err
+}
+
+// withUser returns a context carrying the authenticated user, as set by the JWT middleware.
+func withUser(userID uuid.UUID) context.Context {
+	return context.WithValue(context.Background(), auth.UserKey, &dto.User{ID: userID})
+}
+
+// withScope returns a context of the user whose token grants scope.
+func withScope(userID uuid.UUID, scope string) context.Context {
+	return context.WithValue(withUser(userID), auth.ClaimsKey, &auth.UserClaims{UserID: userID.String(), Scope: scope})
+}
+
+// recordOutcome expects the audit event of a reveal of card with outcome.
+func recordOutcome(t *testing.T, card *dtos.Card, outcome audit.Outcome) func(ctx context.Context, event *audit.Event) {
+	return func(ctx context.Context, event *audit.Event) {
+		assert.Equal(t, outcome, event.Outcome)
+		assert.Equal(t, card.UserId, event.UserID)
+		assert.Equal(t, "cards.reveal", event.Action)
+		assert.Equal(t, card.ID.String(), event.ResourceID)
+	}
+}
+
 func TestCardService_Create(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -31,13 +77,130 @@ func TestCardService_Create(t *testing.T) {
 
 	decryptedPan := base64.StdEncoding.EncodeToString([]byte("4111111111111111"))
 	mockKmsRepo.EXPECT().Decrypt(gomock.Any(), "vault:v1:"+card.Pan, userId.String()).Return(decryptedPan, nil)
+	mockKmsRepo.EXPECT().HMAC(gomock.Any(), decryptedPan, cards.DefaultFingerprintKey, 1).Return("3q2+7w==", nil)
 	mockCardRepo.EXPECT().Create(gomock.Any(), card).Return(nil)
 	mockVaultRepo.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
 
 	createdCard, err := service.Create(context.Background(), card)
 
 	assert.NoError(t, err)
-	assert.Equal(t, "4111", createdCard.Pan)
+	assert.Empty(t, createdCard.Pan)
+	assert.Equal(t, "1111", createdCard.LastDigits)
+	assert.Equal(t, "411111******1111", createdCard.MaskedPan)
+	assert.Equal(t, "visa", createdCard.Brand)
+	assert.Equal(t, "41111111", createdCard.Bin)
+	assert.Equal(t, 16, createdCard.PanLength)
+	assert.Equal(t, "deadbeef", createdCard.Fingerprint)
+}
+
+func TestCardService_Create_DuplicateRejected(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo, cards.WithDuplicatePolicy(cards.DuplicateReject))
+
+	userId := uuid.New()
+	card := &dtos.Card{
+		UserId: userId,
+		Pan:    "encrypted_pan_data",
+	}
+
+	decryptedPan := base64.StdEncoding.EncodeToString([]byte("4111111111111111"))
+	mockKmsRepo.EXPECT().Decrypt(gomock.Any(), "vault:v1:"+card.Pan, userId.String()).Return(decryptedPan, nil)
+	mockKmsRepo.EXPECT().HMAC(gomock.Any(), decryptedPan, cards.DefaultFingerprintKey, 1).Return("3q2+7w==", nil)
+	mockCardRepo.EXPECT().GetByFingerprint(gomock.Any(), userId, "deadbeef").Return(&dtos.Card{ID: uuid.New(), UserId: userId}, nil)
+
+	createdCard, err := service.Create(context.Background(), card)
+
+	assert.ErrorIs(t, err, cards.ErrDuplicateCard)
+	assert.Nil(t, createdCard)
+}
+
+func TestCardService_Create_ConcurrentDuplicate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo, cards.WithDuplicatePolicy(cards.DuplicateReject))
+
+	userId := uuid.New()
+	card := &dtos.Card{
+		UserId: userId,
+		Pan:    "encrypted_pan_data",
+	}
+
+	// the same PAN stored meanwhile by another request trips the unique index
+	decryptedPan := base64.StdEncoding.EncodeToString([]byte("4111111111111111"))
+	mockKmsRepo.EXPECT().Decrypt(gomock.Any(), "vault:v1:"+card.Pan, userId.String()).Return(decryptedPan, nil)
+	mockKmsRepo.EXPECT().HMAC(gomock.Any(), decryptedPan, cards.DefaultFingerprintKey, 1).Return("3q2+7w==", nil)
+	mockCardRepo.EXPECT().GetByFingerprint(gomock.Any(), userId, "deadbeef").Return(nil, nil)
+	mockCardRepo.EXPECT().Create(gomock.Any(), card).DoAndReturn(func(ctx context.Context, card *dtos.Card) error {
+		assert.True(t, card.Deduplicated)
+		return fmt.Errorf("fingerprint of card %s: %w", card.ID, senital.ErrConflict)
+	})
+
+	createdCard, err := service.Create(context.Background(), card)
+
+	assert.ErrorIs(t, err, cards.ErrDuplicateCard)
+	assert.Nil(t, createdCard)
+}
+
+func TestCardService_Create_DuplicateReturnsExisting(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo, cards.WithDuplicatePolicy(cards.DuplicateReturnExisting))
+
+	userId := uuid.New()
+	card := &dtos.Card{
+		UserId: userId,
+		Pan:    "encrypted_pan_data",
+	}
+	existing := &dtos.Card{ID: uuid.New(), UserId: userId, Fingerprint: "deadbeef"}
+
+	decryptedPan := base64.StdEncoding.EncodeToString([]byte("4111111111111111"))
+	mockKmsRepo.EXPECT().Decrypt(gomock.Any(), "vault:v1:"+card.Pan, userId.String()).Return(decryptedPan, nil)
+	mockKmsRepo.EXPECT().HMAC(gomock.Any(), decryptedPan, cards.DefaultFingerprintKey, 1).Return("3q2+7w==", nil)
+	mockCardRepo.EXPECT().GetByFingerprint(gomock.Any(), userId, "deadbeef").Return(existing, nil)
+
+	createdCard, err := service.Create(context.Background(), card)
+
+	assert.NoError(t, err)
+	assert.Equal(t, existing, createdCard)
+}
+
+func TestCardService_Create_Expired(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+
+	card := &dtos.Card{
+		UserId:      uuid.New(),
+		Pan:         "encrypted_pan_data",
+		ExpiryMonth: 1,
+		ExpiryYear:  2001,
+	}
+
+	createdCard, err := service.Create(context.Background(), card)
+
+	assert.ErrorIs(t, err, cards.ErrInvalidExpiry)
+	assert.Nil(t, createdCard)
 }
 
 func TestCardService_Create_InvalidPAN(t *testing.T) {
@@ -65,6 +228,56 @@ func TestCardService_Create_InvalidPAN(t *testing.T) {
 	assert.Nil(t, createdCard)
 }
 
+func TestCardService_Create_KeyVersion(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo, cards.WithMinKeyVersion(2))
+
+	userId := uuid.New()
+	card := &dtos.Card{
+		UserId:     userId,
+		Pan:        "encrypted_pan_data",
+		KeyVersion: 3,
+	}
+
+	decryptedPan := base64.StdEncoding.EncodeToString([]byte("4111111111111111"))
+	mockKmsRepo.EXPECT().Decrypt(gomock.Any(), "vault:v3:"+card.Pan, userId.String()).Return(decryptedPan, nil)
+	mockKmsRepo.EXPECT().HMAC(gomock.Any(), decryptedPan, cards.DefaultFingerprintKey, 1).Return("3q2+7w==", nil)
+	mockCardRepo.EXPECT().Create(gomock.Any(), card).Return(nil)
+	mockVaultRepo.EXPECT().Create(gomock.Any(), map[string]interface{}{"pan": "vault:v3:encrypted_pan_data"}, gomock.Any()).Return(nil)
+
+	createdCard, err := service.Create(context.Background(), card)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, createdCard.KeyVersion)
+}
+
+func TestCardService_Create_KeyVersionTooOld(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo, cards.WithMinKeyVersion(2))
+
+	card := &dtos.Card{
+		UserId: uuid.New(),
+		Pan:    "encrypted_pan_data",
+	}
+
+	createdCard, err := service.Create(context.Background(), card)
+
+	assert.ErrorIs(t, err, cards.ErrKeyVersionTooOld)
+	assert.Nil(t, createdCard)
+}
+
 func TestCardService_Get(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -77,14 +290,14 @@ func TestCardService_Get(t *testing.T) {
 
 	cardId := uuid.New()
 	expectedCard := &dtos.Card{
-		ID:     cardId,
-		UserId: uuid.New(),
-		Pan:    "4111",
+		ID:         cardId,
+		UserId:     uuid.New(),
+		LastDigits: "1111",
 	}
 
 	mockCardRepo.EXPECT().Get(gomock.Any(), cardId).Return(expectedCard, nil)
 
-	card, err := service.Get(context.Background(), expectedCard)
+	card, err := service.Get(withUser(expectedCard.UserId), expectedCard)
 
 	assert.NoError(t, err)
 	assert.Equal(t, expectedCard, card)
@@ -101,15 +314,15 @@ func TestCardService_Update(t *testing.T) {
 	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
 
 	card := &dtos.Card{
-		ID:     uuid.New(),
-		UserId: uuid.New(),
-		Pan:    "4111",
+		ID:         uuid.New(),
+		UserId:     uuid.New(),
+		LastDigits: "1111",
 	}
 
-	mockCardRepo.EXPECT().Get(gomock.Any(), card.ID).Return(card, nil)
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), card.ID).Return(card, nil)
 	mockCardRepo.EXPECT().UpdateOne(gomock.Any(), card).Return(nil)
 
-	err := service.Update(context.Background(), card)
+	err := service.Update(withUser(card.UserId), card)
 
 	assert.NoError(t, err)
 }
@@ -125,16 +338,1454 @@ func TestCardService_Delete(t *testing.T) {
 	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
 
 	card := &dtos.Card{
-		ID:     uuid.New(),
-		UserId: uuid.New(),
-		Pan:    "4111",
+		ID:         uuid.New(),
+		UserId:     uuid.New(),
+		LastDigits: "1111",
 	}
 
-	mockCardRepo.EXPECT().Get(gomock.Any(), card.ID).Return(card, nil)
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), card.ID).Return(card, nil)
 	mockCardRepo.EXPECT().Delete(gomock.Any(), card.ID).Return(nil)
 	mockVaultRepo.EXPECT().Delete(gomock.Any(), gomock.Any()).Return(nil)
 
-	err := service.Delete(context.Background(), card)
+	err := service.Delete(withUser(card.UserId), card)
+
+	assert.NoError(t, err)
+}
+
+func TestCardService_Detokenize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+
+	card := &dtos.Card{
+		ID:         uuid.New(),
+		UserId:     uuid.New(),
+		CardHolder: "John Doe",
+		LastDigits: "1111",
+	}
+
+	decryptedPan := base64.StdEncoding.EncodeToString([]byte("4111111111111111"))
+	mockCardRepo.EXPECT().Get(gomock.Any(), card.ID).Return(card, nil)
+	mockVaultRepo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(map[string]interface{}{"pan": "encrypted_pan_data"}, nil)
+	mockKmsRepo.EXPECT().Decrypt(gomock.Any(), "vault:v1:encrypted_pan_data", card.UserId.String()).Return(decryptedPan, nil)
+
+	revealed, err := service.Detokenize(withUser(card.UserId), card)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "4111111111111111", revealed.Pan)
+	assert.Equal(t, "John Doe", revealed.CardHolder)
+}
+
+func TestCardService_List(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+
+	userId := uuid.New()
+	now := time.Now()
+	stored := []*dtos.Card{
+		{ID: uuid.New(), UserId: userId, CreatedAt: now},
+		{ID: uuid.New(), UserId: userId, CreatedAt: now.Add(-time.Minute)},
+		{ID: uuid.New(), UserId: userId, CreatedAt: now.Add(-2 * time.Minute)},
+	}
+
+	mockCardRepo.EXPECT().List(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, filter *dtos.CardFilter) ([]*dtos.Card, error) {
+		assert.Equal(t, 3, filter.Limit)
+		assert.Equal(t, dtos.Descending, filter.Order)
+		return stored, nil
+	})
+
+	page, err := service.List(context.Background(), &dtos.CardFilter{UserID: userId, Limit: 2}, "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, stored[:2], page.Items)
+	assert.NotEmpty(t, page.NextCursor)
+
+	mockCardRepo.EXPECT().List(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, filter *dtos.CardFilter) ([]*dtos.Card, error) {
+		assert.Equal(t, stored[1].ID, filter.AfterID)
+		assert.True(t, stored[1].CreatedAt.Equal(filter.AfterCreatedAt))
+		return stored[2:], nil
+	})
+
+	page, err = service.List(context.Background(), &dtos.CardFilter{UserID: userId, Limit: 2}, page.NextCursor)
+
+	assert.NoError(t, err)
+	assert.Equal(t, stored[2:], page.Items)
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestCardService_List_InvalidCursor(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+
+	page, err := service.List(context.Background(), &dtos.CardFilter{UserID: uuid.New()}, "not-a-cursor")
+
+	assert.ErrorIs(t, err, cards.ErrInvalidCursor)
+	assert.Nil(t, page)
+}
+
+func TestCardService_ForeignCard(t *testing.T) {
+	card := &dtos.Card{
+		ID:         uuid.New(),
+		UserId:     uuid.New(),
+		LastDigits: "1111",
+	}
+	// the caller sends its own ID, the stored card belongs to someone else
+	attacker := uuid.New()
+	request := &dtos.Card{ID: card.ID, UserId: attacker, CardHolder: "Mallory"}
+
+	tests := []struct {
+		name  string
+		locks bool
+		call  func(service *cards.CardService) error
+	}{
+		{name: "get", call: func(service *cards.CardService) error {
+			_, err := service.Get(withUser(attacker), request)
+			return err
+		}},
+		{name: "update", locks: true, call: func(service *cards.CardService) error {
+			return service.Update(withUser(attacker), request)
+		}},
+		{name: "delete", locks: true, call: func(service *cards.CardService) error {
+			return service.Delete(withUser(attacker), request)
+		}},
+		{name: "detokenize", call: func(service *cards.CardService) error {
+			_, err := service.Detokenize(withUser(attacker), request)
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// UpdateOne, Delete and the Vault calls must never happen
+			mockCardRepo := mocks.NewMockCardRepository(ctrl)
+			mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+			mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+			service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+
+			if tt.locks {
+				mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), card.ID).Return(card, nil)
+			} else {
+				mockCardRepo.EXPECT().Get(gomock.Any(), card.ID).Return(card, nil)
+			}
+
+			assert.ErrorIs(t, tt.call(service), senital.ErrNotFound)
+		})
+	}
+}
+
+func TestCardService_MissingCard(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+
+	userId := uuid.New()
+	card := &dtos.Card{ID: uuid.New(), UserId: userId}
+
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), card.ID).Return(nil, senital.ErrNotFound)
+
+	err := service.Delete(withUser(userId), card)
+
+	assert.ErrorIs(t, err, senital.ErrNotFound)
+}
+
+func TestCardService_Unauthenticated(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+
+	card := &dtos.Card{ID: uuid.New(), UserId: uuid.New()}
+
+	_, err := service.Get(context.Background(), card)
+
+	assert.ErrorIs(t, err, senital.ErrUnauthenticated)
+}
+
+func TestBatchUpdater_ForeignCards(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	updater := cards.NewBatchUpdater(service, &transactor{})
+
+	userId := uuid.New()
+	own := &dtos.Card{ID: uuid.New(), UserId: userId}
+	foreign := &dtos.Card{ID: uuid.New(), UserId: uuid.New()}
+
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), own.ID).Return(own, nil)
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), foreign.ID).Return(foreign, nil)
+	mockCardRepo.EXPECT().UpdateOne(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, card *dtos.Card) error {
+		assert.Equal(t, own.ID, card.ID)
+		return nil
+	})
+
+	statuses, err := updater.Update(withUser(userId), userId, []*dtos.BatchUpdate{
+		{ID: own.ID, CardHolder: "John Doe"},
+		{ID: foreign.ID, CardHolder: "John Doe"},
+	})
 
 	assert.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.Equal(t, own.ID, statuses[0].CardID)
+	assert.Equal(t, dtos.Succeeded, statuses[0].Status)
+	assert.Equal(t, foreign.ID, statuses[1].CardID)
+	assert.Equal(t, dtos.Failed, statuses[1].Status)
+	assert.Equal(t, 1, statuses[1].Index)
+	assert.Equal(t, &dtos.BatchError{Code: dtos.ErrorNotFound, Message: "card not found"}, statuses[1].Error)
+}
+
+func TestBatchUpdater_ErrorCodes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	updater := cards.NewBatchUpdater(service, &transactor{})
+
+	userId := uuid.New()
+	unavailable := uuid.New()
+	broken := uuid.New()
+
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), unavailable).Return(nil, fmt.Errorf("%w: connection refused", senital.ErrUnavailable))
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), broken).Return(nil, errors.New("column does not exist"))
+
+	statuses, err := updater.Update(withUser(userId), userId, []*dtos.BatchUpdate{
+		{CardHolder: "John Doe"},
+		{ID: unavailable, CardHolder: "John Doe"},
+		{ID: broken, CardHolder: "John Doe"},
+	})
+
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+
+	tests := []struct {
+		code      dtos.BatchErrorCode
+		retryable bool
+	}{
+		{dtos.ErrorInvalid, false},
+		{dtos.ErrorUnavailable, true},
+		{dtos.ErrorInternal, false},
+	}
+	for i, tt := range tests {
+		assert.Equal(t, i, statuses[i].Index)
+		assert.Equal(t, dtos.Failed, statuses[i].Status)
+		require.NotNil(t, statuses[i].Error)
+		assert.Equal(t, tt.code, statuses[i].Error.Code)
+		assert.Equal(t, tt.retryable, statuses[i].Error.Retryable)
+	}
+	// the cause of internal errors is only logged
+	assert.Equal(t, "internal error", statuses[2].Error.Message)
+}
+
+func TestBatchUpdater_Atomic(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	tx := &transactor{}
+	updater := cards.NewBatchUpdater(service, tx)
+
+	userId := uuid.New()
+	first := &dtos.Card{ID: uuid.New(), UserId: userId}
+	second := &dtos.Card{ID: uuid.New(), UserId: userId}
+	batch := []*dtos.BatchUpdate{
+		{ID: first.ID, CardHolder: "John Doe"},
+		{ID: second.ID, CardHolder: "Jane Doe"},
+	}
+
+	// locked before anything is written and read again by the update
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), first.ID).Return(first, nil).Times(2)
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), second.ID).Return(second, nil).Times(2)
+	mockCardRepo.EXPECT().UpdateOne(gomock.Any(), gomock.Any()).Return(nil)
+	mockCardRepo.EXPECT().UpdateOne(gomock.Any(), gomock.Any()).Return(errors.New("deadlock detected"))
+
+	statuses, err := updater.UpdateAtomic(withUser(userId), userId, batch)
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, tx.rollbacks)
+	require.Len(t, statuses, 2)
+	assert.Equal(t, dtos.Failed, statuses[0].Status)
+	assert.Equal(t, dtos.ErrorAborted, statuses[0].Error.Code)
+	assert.Equal(t, dtos.Failed, statuses[1].Status)
+	assert.Equal(t, dtos.ErrorInternal, statuses[1].Error.Code)
+}
+
+func TestBatchUpdater_AtomicChecksFirst(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	updater := cards.NewBatchUpdater(service, &transactor{})
+
+	userId := uuid.New()
+	own := &dtos.Card{ID: uuid.New(), UserId: userId}
+	foreign := &dtos.Card{ID: uuid.New(), UserId: uuid.New()}
+
+	// nothing is written when an item can't be applied. Cards are locked in the
+	// order of their IDs, the foreign one may come first
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), own.ID).Return(own, nil).MaxTimes(1)
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), foreign.ID).Return(foreign, nil)
+
+	statuses, err := updater.UpdateAtomic(withUser(userId), userId, []*dtos.BatchUpdate{
+		{ID: own.ID, CardHolder: "John Doe"},
+		{ID: foreign.ID, CardHolder: "John Doe"},
+	})
+
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.Equal(t, &dtos.BatchError{Code: dtos.ErrorAborted, Message: "not applied, item 1 failed"}, statuses[0].Error)
+	assert.Equal(t, dtos.ErrorNotFound, statuses[1].Error.Code)
+}
+
+func TestBatchUpdater_AtomicLocksInIDOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	tx := &transactor{}
+	updater := cards.NewBatchUpdater(service, tx)
+
+	userId := uuid.New()
+	low := &dtos.Card{ID: uuid.MustParse("00000000-0000-4000-8000-000000000001"), UserId: userId}
+	high := &dtos.Card{ID: uuid.MustParse("ffffffff-0000-4000-8000-000000000001"), UserId: userId}
+
+	var locked []uuid.UUID
+	lock := func(ctx context.Context, id uuid.UUID) (*dtos.Card, error) {
+		locked = append(locked, id)
+		if id == low.ID {
+			return low, nil
+		}
+		return high, nil
+	}
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), gomock.Any()).DoAndReturn(lock).Times(3)
+	// the card was deleted after it was read
+	mockCardRepo.EXPECT().UpdateOne(gomock.Any(), gomock.Any()).Return(fmt.Errorf("updating card %s: %w", high.ID, senital.ErrNotFound))
+
+	statuses, err := updater.UpdateAtomic(withUser(userId), userId, []*dtos.BatchUpdate{
+		{ID: high.ID, CardHolder: "John Doe"},
+		{ID: low.ID, CardHolder: "Jane Doe"},
+	})
+
+	require.NoError(t, err)
+	// every card is locked before the first update, whatever the request order
+	assert.Equal(t, []uuid.UUID{low.ID, high.ID}, locked[:2])
+	assert.Equal(t, 1, tx.rollbacks)
+	require.Len(t, statuses, 2)
+	assert.Equal(t, dtos.ErrorNotFound, statuses[0].Error.Code)
+	assert.Equal(t, dtos.ErrorAborted, statuses[1].Error.Code)
+}
+
+func TestBatchUpdater_Create(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	updater := cards.NewBatchUpdater(service, &transactor{})
+
+	userId := uuid.New()
+	decryptedPan := base64.StdEncoding.EncodeToString([]byte("4111111111111111"))
+	mockKmsRepo.EXPECT().Decrypt(gomock.Any(), "vault:v1:valid", userId.String()).Return(decryptedPan, nil)
+	mockKmsRepo.EXPECT().Decrypt(gomock.Any(), "vault:v1:foreign", userId.String()).Return("", fmt.Errorf("%w: cipher: message authentication failed", kms.ErrInvalidCiphertext))
+	mockKmsRepo.EXPECT().HMAC(gomock.Any(), decryptedPan, cards.DefaultFingerprintKey, 1).Return("3q2+7w==", nil)
+	mockCardRepo.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil)
+	mockVaultRepo.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+
+	statuses, err := updater.Create(withUser(userId), userId, []*dtos.BatchCreate{
+		{CardHolder: "John Doe", Pan: "valid"},
+		{CardHolder: "John Doe", Pan: "foreign"},
+		{CardHolder: "John Doe"},
+	})
+
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+	assert.Equal(t, dtos.Succeeded, statuses[0].Status)
+	assert.NotEqual(t, uuid.Nil, statuses[0].CardID)
+	assert.Equal(t, &dtos.BatchError{Code: dtos.ErrorInvalid, Message: "the PAN can't be decrypted with the key"}, statuses[1].Error)
+	assert.Equal(t, uuid.Nil, statuses[1].CardID)
+	assert.Equal(t, dtos.ErrorInvalid, statuses[2].Error.Code)
+}
+
+func TestBatchUpdater_Delete(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	updater := cards.NewBatchUpdater(service, &transactor{})
+
+	userId := uuid.New()
+	own := &dtos.Card{ID: uuid.New(), UserId: userId}
+	foreign := &dtos.Card{ID: uuid.New(), UserId: uuid.New()}
+
+	// the secret goes along with the card, the foreign card is left alone
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), own.ID).Return(own, nil)
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), foreign.ID).Return(foreign, nil)
+	mockCardRepo.EXPECT().Delete(gomock.Any(), own.ID).Return(nil)
+	mockVaultRepo.EXPECT().Delete(gomock.Any(), gomock.Any()).Return(nil)
+
+	statuses, err := updater.Delete(withUser(userId), userId, []*dtos.BatchDelete{{ID: own.ID}, {ID: foreign.ID}})
+
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.Equal(t, dtos.Succeeded, statuses[0].Status)
+	assert.Equal(t, foreign.ID, statuses[1].CardID)
+	assert.Equal(t, dtos.ErrorNotFound, statuses[1].Error.Code)
+}
+
+func TestBatchJobs_Submit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockJobRepo := mocks.NewMockBatchJobRepository(ctrl)
+	jobs := cards.NewBatchJobs(nil, mockJobRepo, time.Minute)
+
+	userId := uuid.New()
+	batch := []*dtos.BatchUpdate{
+		{ID: uuid.New(), CardHolder: "John Doe"},
+		{ID: uuid.New(), CardHolder: "Jane Doe"},
+	}
+
+	mockJobRepo.EXPECT().CountActiveByUser(gomock.Any(), userId).Return(0, nil)
+	mockJobRepo.EXPECT().CountQueuedItems(gomock.Any()).Return(0, nil)
+	mockJobRepo.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, job *dtos.BatchJob, items []*dtos.BatchJobItem) error {
+		assert.Equal(t, userId, job.UserID)
+		assert.Equal(t, dtos.BatchJobPending, job.Status)
+		require.Len(t, items, 2)
+		for i, item := range items {
+			assert.Equal(t, i, item.Position)
+			assert.Equal(t, batch[i].ID, item.CardID)
+			assert.Equal(t, batch[i].CardHolder, item.CardHolder)
+			assert.Equal(t, dtos.Pending, item.Status)
+		}
+		job.ID = uuid.New()
+		return nil
+	})
+
+	job, err := jobs.Submit(context.Background(), userId, batch, false)
+
+	assert.NoError(t, err)
+	assert.NotEqual(t, uuid.Nil, job.ID)
+
+	_, err = jobs.Submit(context.Background(), userId, nil, false)
+	assert.ErrorIs(t, err, cards.ErrEmptyBatch)
+
+	// an atomic batch with an item that can't be applied is rejected as a whole
+	_, err = jobs.Submit(context.Background(), userId, append(batch, &dtos.BatchUpdate{CardHolder: "John Doe"}), true)
+	assert.ErrorIs(t, err, cards.ErrInvalidBatchItem)
+}
+
+func TestBatchJobs_Process(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockJobRepo := mocks.NewMockBatchJobRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	jobs := cards.NewBatchJobs(cards.NewBatchUpdater(service, &transactor{}), mockJobRepo, time.Minute)
+
+	userId := uuid.New()
+	own := &dtos.Card{ID: uuid.New(), UserId: userId}
+	foreign := &dtos.Card{ID: uuid.New(), UserId: uuid.New()}
+	job := &dtos.BatchJob{ID: uuid.New(), UserID: userId, Status: dtos.BatchJobRunning, Total: 2}
+	items := []*dtos.BatchJobItem{
+		{ID: uuid.New(), Position: 0, CardID: own.ID, CardHolder: "John Doe", Status: dtos.Pending},
+		{ID: uuid.New(), Position: 1, CardID: foreign.ID, CardHolder: "John Doe", Status: dtos.Pending},
+	}
+
+	// the updates run as the user who submitted the job
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), own.ID).Return(own, nil)
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), foreign.ID).Return(foreign, nil)
+	mockCardRepo.EXPECT().UpdateOne(gomock.Any(), gomock.Any()).Return(nil)
+	mockJobRepo.EXPECT().ListPendingItems(gomock.Any(), job.ID, gomock.Any()).Return(items, nil)
+	mockJobRepo.EXPECT().SaveProgress(gomock.Any(), job, items, gomock.Any()).Return(nil)
+
+	err := jobs.Process(context.Background(), job)
+
+	assert.NoError(t, err)
+	assert.Equal(t, dtos.BatchJobCompleted, job.Status)
+	assert.Equal(t, 1, job.Succeeded)
+	assert.Equal(t, 1, job.Failed)
+	assert.Equal(t, dtos.Succeeded, items[0].Status)
+	assert.Equal(t, dtos.Failed, items[1].Status)
+	require.NotNil(t, items[1].Error)
+	assert.Equal(t, dtos.ErrorNotFound, items[1].Error.Code)
+}
+
+func TestBatchUpdater_Cancelled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	// a pool without workers never takes the items
+	updater := cards.NewBatchUpdater(service, &transactor{}, cards.WithWorkerPool(cards.NewWorkerPool(0)))
+
+	userId := uuid.New()
+	ctx, cancel := context.WithTimeout(withUser(userId), 10*time.Millisecond)
+	defer cancel()
+
+	statuses, err := updater.Update(ctx, userId, []*dtos.BatchUpdate{{ID: uuid.New(), CardHolder: "John Doe"}})
+
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	assert.Equal(t, dtos.Pending, statuses[0].Status)
+	assert.Nil(t, statuses[0].Error)
+}
+
+func TestBatchUpdater_ItemTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	updater := cards.NewBatchUpdater(service, &transactor{}, cards.WithItemTimeout(10*time.Millisecond))
+
+	userId := uuid.New()
+	card := &dtos.Card{ID: uuid.New(), UserId: userId}
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), card.ID).DoAndReturn(func(ctx context.Context, id uuid.UUID) (*dtos.Card, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	statuses, err := updater.Update(withUser(userId), userId, []*dtos.BatchUpdate{{ID: card.ID, CardHolder: "John Doe"}})
+
+	require.NoError(t, err)
+	assert.Equal(t, dtos.Failed, statuses[0].Status)
+	assert.Equal(t, dtos.ErrorTimeout, statuses[0].Error.Code)
+	assert.True(t, statuses[0].Error.Retryable)
+}
+
+func TestBatchJobs_SubmitLimits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockJobRepo := mocks.NewMockBatchJobRepository(ctrl)
+	jobs := cards.NewBatchJobs(nil, mockJobRepo, time.Minute,
+		cards.WithMaxBatchSize(2),
+		cards.WithMaxUserJobs(1),
+		cards.WithMaxQueuedItems(10),
+	)
+
+	userId := uuid.New()
+	batch := []*dtos.BatchDelete{{ID: uuid.New()}, {ID: uuid.New()}}
+
+	_, err := jobs.SubmitDelete(context.Background(), userId, append(batch, &dtos.BatchDelete{ID: uuid.New()}))
+	assert.ErrorIs(t, err, cards.ErrBatchTooLarge)
+
+	mockJobRepo.EXPECT().CountActiveByUser(gomock.Any(), userId).Return(1, nil)
+	_, err = jobs.SubmitDelete(context.Background(), userId, batch)
+	assert.ErrorIs(t, err, cards.ErrTooManyBatchJobs)
+
+	mockJobRepo.EXPECT().CountActiveByUser(gomock.Any(), userId).Return(0, nil)
+	mockJobRepo.EXPECT().CountQueuedItems(gomock.Any()).Return(9, nil)
+	_, err = jobs.SubmitDelete(context.Background(), userId, batch)
+	assert.ErrorIs(t, err, cards.ErrBatchQueueFull)
+}
+
+func TestBatchJobs_ProcessCancelled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockJobRepo := mocks.NewMockBatchJobRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	updater := cards.NewBatchUpdater(service, &transactor{}, cards.WithWorkerPool(cards.NewWorkerPool(0)))
+	jobs := cards.NewBatchJobs(updater, mockJobRepo, time.Minute)
+
+	job := &dtos.BatchJob{ID: uuid.New(), UserID: uuid.New(), Status: dtos.BatchJobRunning, Total: 1}
+	items := []*dtos.BatchJobItem{{ID: uuid.New(), CardID: uuid.New(), Status: dtos.Pending}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mockJobRepo.EXPECT().ListPendingItems(gomock.Any(), job.ID, gomock.Any()).DoAndReturn(func(context.Context, uuid.UUID, int) ([]*dtos.BatchJobItem, error) {
+		cancel()
+		return items, nil
+	})
+	// nothing was applied, the job is released right away with its item still pending
+	mockJobRepo.EXPECT().SaveProgress(gomock.Any(), job, gomock.Len(0), gomock.Any()).DoAndReturn(func(ctx context.Context, job *dtos.BatchJob, items []*dtos.BatchJobItem, lockedUntil time.Time) error {
+		assert.NoError(t, ctx.Err())
+		assert.False(t, lockedUntil.After(time.Now()))
+		return nil
+	})
+
+	err := jobs.Process(ctx, job)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, dtos.BatchJobRunning, job.Status)
+	assert.Equal(t, dtos.Pending, items[0].Status)
+}
+
+func TestBatchJobs_ProcessCreate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockJobRepo := mocks.NewMockBatchJobRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	jobs := cards.NewBatchJobs(cards.NewBatchUpdater(service, &transactor{}), mockJobRepo, time.Minute)
+
+	userId := uuid.New()
+	mockJobRepo.EXPECT().CountActiveByUser(gomock.Any(), userId).Return(0, nil)
+	mockJobRepo.EXPECT().CountQueuedItems(gomock.Any()).Return(0, nil)
+	mockJobRepo.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, job *dtos.BatchJob, items []*dtos.BatchJobItem) error {
+		assert.Equal(t, dtos.OperationCreate, job.Operation)
+		assert.Equal(t, "ciphertext", items[0].Pan)
+		// the card is picked when queued, a repeated item finds it
+		assert.NotEqual(t, uuid.Nil, items[0].CardID)
+		job.ID = uuid.New()
+		job.Total = len(items)
+		return nil
+	})
+
+	job, err := jobs.SubmitCreate(context.Background(), userId, []*dtos.BatchCreate{{CardHolder: "John Doe", Pan: "ciphertext"}})
+	require.NoError(t, err)
+
+	cardID := uuid.New()
+	items := []*dtos.BatchJobItem{{ID: uuid.New(), CardID: cardID, CardHolder: "John Doe", Pan: "ciphertext", Status: dtos.Pending}}
+	decryptedPan := base64.StdEncoding.EncodeToString([]byte("4111111111111111"))
+	mockCardRepo.EXPECT().Get(gomock.Any(), cardID).Return(nil, senital.ErrNotFound)
+	mockKmsRepo.EXPECT().Decrypt(gomock.Any(), "vault:v1:ciphertext", userId.String()).Return(decryptedPan, nil)
+	mockKmsRepo.EXPECT().HMAC(gomock.Any(), decryptedPan, cards.DefaultFingerprintKey, 1).Return("3q2+7w==", nil)
+	mockCardRepo.EXPECT().Create(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, card *dtos.Card) error {
+		assert.Equal(t, cardID, card.ID)
+		return nil
+	})
+	mockVaultRepo.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	mockJobRepo.EXPECT().ListPendingItems(gomock.Any(), job.ID, gomock.Any()).Return(items, nil)
+	mockJobRepo.EXPECT().SaveProgress(gomock.Any(), job, items, gomock.Any()).Return(nil)
+
+	require.NoError(t, jobs.Process(context.Background(), job))
+
+	assert.Equal(t, dtos.Succeeded, items[0].Status)
+	assert.Equal(t, cardID, items[0].CardID)
+}
+
+func TestBatchJobs_ProcessCreate_Repeated(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockJobRepo := mocks.NewMockBatchJobRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	jobs := cards.NewBatchJobs(cards.NewBatchUpdater(service, &transactor{}), mockJobRepo, time.Minute)
+
+	userId := uuid.New()
+	job := &dtos.BatchJob{ID: uuid.New(), UserID: userId, Operation: dtos.OperationCreate, Status: dtos.BatchJobRunning, Total: 1}
+	// the card was created, but the instance died before saving the outcome
+	created := &dtos.Card{ID: uuid.New(), UserId: userId}
+	items := []*dtos.BatchJobItem{{ID: uuid.New(), CardID: created.ID, CardHolder: "John Doe", Pan: "ciphertext", Status: dtos.Pending}}
+
+	mockCardRepo.EXPECT().Get(gomock.Any(), created.ID).Return(created, nil)
+	// neither the card nor its secret are written again
+	mockCardRepo.EXPECT().Create(gomock.Any(), gomock.Any()).Times(0)
+	mockVaultRepo.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	mockJobRepo.EXPECT().ListPendingItems(gomock.Any(), job.ID, gomock.Any()).Return(items, nil)
+	mockJobRepo.EXPECT().SaveProgress(gomock.Any(), job, items, gomock.Any()).Return(nil)
+
+	require.NoError(t, jobs.Process(context.Background(), job))
+
+	assert.Equal(t, dtos.Succeeded, items[0].Status)
+	assert.Equal(t, created.ID, items[0].CardID)
+	assert.Equal(t, 1, job.Succeeded)
+}
+
+func TestBatchJobs_GetForeignJob(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockJobRepo := mocks.NewMockBatchJobRepository(ctrl)
+	jobs := cards.NewBatchJobs(nil, mockJobRepo, time.Minute)
+
+	job := &dtos.BatchJob{ID: uuid.New(), UserID: uuid.New()}
+	mockJobRepo.EXPECT().Get(gomock.Any(), job.ID).Return(job, nil)
+
+	_, err := jobs.Get(context.Background(), uuid.New(), job.ID)
+
+	assert.ErrorIs(t, err, senital.ErrNotFound)
+}
+
+func TestSecretOutbox_Create(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockOperationRepo := mocks.NewMockSecretOperationRepository(ctrl)
+
+	outbox := cards.NewSecretOutbox(mockVaultRepo, mockCardRepo, mockOperationRepo, mocks.NewMockAuditRepository(ctrl), time.Hour, time.Second)
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo, cards.WithSecretStore(outbox))
+
+	userId := uuid.New()
+	card := &dtos.Card{
+		UserId: userId,
+		Pan:    "encrypted_pan_data",
+	}
+	secret := map[string]interface{}{"pan": "vault:v1:encrypted_pan_data"}
+
+	decryptedPan := base64.StdEncoding.EncodeToString([]byte("4111111111111111"))
+	mockKmsRepo.EXPECT().Decrypt(gomock.Any(), "vault:v1:"+card.Pan, userId.String()).Return(decryptedPan, nil)
+	mockKmsRepo.EXPECT().HMAC(gomock.Any(), decryptedPan, cards.DefaultFingerprintKey, 1).Return("3q2+7w==", nil)
+	gomock.InOrder(
+		mockCardRepo.EXPECT().Create(gomock.Any(), card).Return(nil),
+		mockOperationRepo.EXPECT().Create(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, op *dtos.SecretOperation) error {
+			assert.Equal(t, dtos.SecretPut, op.Type)
+			assert.Equal(t, dtos.SecretPending, op.Status)
+			assert.Equal(t, card.ID, op.CardID)
+			assert.Equal(t, secret, op.Data)
+			return nil
+		}),
+		// without a transaction the operation is claimed and applied right away
+		mockOperationRepo.EXPECT().ClaimByID(gomock.Any(), uuid.Nil, gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, id uuid.UUID, now time.Time, lockedUntil time.Time) (*dtos.SecretOperation, error) {
+				assert.Equal(t, cards.DefaultSecretLease, lockedUntil.Sub(now))
+				return &dtos.SecretOperation{CardID: card.ID, Type: dtos.SecretPut, Status: dtos.SecretPending, Data: secret, LockedUntil: &lockedUntil}, nil
+			}),
+		mockVaultRepo.EXPECT().Create(gomock.Any(), secret, gomock.Any()).Return(nil),
+		mockOperationRepo.EXPECT().Save(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, op *dtos.SecretOperation) error {
+			assert.Equal(t, dtos.SecretDone, op.Status)
+			assert.Nil(t, op.Data)
+			assert.Nil(t, op.LockedUntil)
+			return nil
+		}),
+	)
+
+	_, err := service.Create(context.Background(), card)
+
+	assert.NoError(t, err)
+}
+
+func TestSecretOutbox_Delete_WaitsForOlderOperation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockOperationRepo := mocks.NewMockSecretOperationRepository(ctrl)
+
+	outbox := cards.NewSecretOutbox(mockVaultRepo, mockCardRepo, mockOperationRepo, mocks.NewMockAuditRepository(ctrl), time.Hour, time.Second)
+
+	cardID := uuid.New()
+	mockOperationRepo.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil)
+	// a write of the card is still being applied, the removal is left to Run
+	mockOperationRepo.EXPECT().ClaimByID(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, senital.ErrNotFound)
+	mockVaultRepo.EXPECT().Delete(gomock.Any(), gomock.Any()).Times(0)
+
+	err := outbox.Delete(context.Background(), cardID, "/secrets/cards/user/card")
+
+	assert.NoError(t, err)
+}
+
+func TestSecretOutbox_Run(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockOperationRepo := mocks.NewMockSecretOperationRepository(ctrl)
+
+	outbox := cards.NewSecretOutbox(mockVaultRepo, mockCardRepo, mockOperationRepo, mocks.NewMockAuditRepository(ctrl), time.Hour, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	op := &dtos.SecretOperation{
+		ID:     uuid.New(),
+		CardID: uuid.New(),
+		Type:   dtos.SecretDelete,
+		Status: dtos.SecretPending,
+		Key:    "/secrets/cards/user/card",
+	}
+
+	// only the operations claimed with a lease are applied
+	mockOperationRepo.EXPECT().Claim(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, now time.Time, lockedUntil time.Time, limit int) ([]*dtos.SecretOperation, error) {
+			assert.Equal(t, cards.DefaultSecretLease, lockedUntil.Sub(now))
+			op.LockedUntil = &lockedUntil
+			return []*dtos.SecretOperation{op}, nil
+		})
+	mockVaultRepo.EXPECT().Delete(gomock.Any(), op.Key).Return(nil)
+	mockOperationRepo.EXPECT().Save(gomock.Any(), op).DoAndReturn(func(ctx context.Context, op *dtos.SecretOperation) error {
+		cancel()
+		return nil
+	})
+
+	outbox.Run(ctx, time.Hour)
+
+	assert.Equal(t, dtos.SecretDone, op.Status)
+	assert.Nil(t, op.LockedUntil)
+}
+
+func TestSecretOutbox_Retry(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockOperationRepo := mocks.NewMockSecretOperationRepository(ctrl)
+
+	outbox := cards.NewSecretOutbox(mockVaultRepo, mockCardRepo, mockOperationRepo, mocks.NewMockAuditRepository(ctrl), time.Hour, time.Second)
+
+	op := &dtos.SecretOperation{
+		ID:     uuid.New(),
+		CardID: uuid.New(),
+		Type:   dtos.SecretPut,
+		Status: dtos.SecretPending,
+		Key:    "/secrets/cards/user/card",
+		Data:   map[string]interface{}{"pan": "vault:v1:encrypted_pan_data"},
+		// failing for less than the compensation delay, the card is kept
+		CreatedAt: time.Now().Add(-50 * time.Minute),
+		Attempts:  20,
+	}
+
+	vaultErr := errors.New("vault unavailable")
+	mockVaultRepo.EXPECT().Create(gomock.Any(), op.Data, op.Key).Return(vaultErr)
+	mockOperationRepo.EXPECT().Save(gomock.Any(), op).Return(nil)
+
+	err := outbox.Apply(context.Background(), op)
+
+	assert.ErrorIs(t, err, vaultErr)
+	assert.Equal(t, dtos.SecretPending, op.Status)
+	assert.Equal(t, 21, op.Attempts)
+	assert.Equal(t, "vault unavailable", op.LastError)
+	assert.True(t, op.NextAttemptAt.After(time.Now()))
+	assert.NotNil(t, op.Data)
+}
+
+func TestSecretOutbox_Compensate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockOperationRepo := mocks.NewMockSecretOperationRepository(ctrl)
+
+	mockAuditRepo := mocks.NewMockAuditRepository(ctrl)
+
+	outbox := cards.NewSecretOutbox(mockVaultRepo, mockCardRepo, mockOperationRepo, mockAuditRepo, time.Hour, time.Second)
+
+	card := &dtos.Card{ID: uuid.New(), UserId: uuid.New()}
+	op := &dtos.SecretOperation{
+		ID:        uuid.New(),
+		CardID:    card.ID,
+		Type:      dtos.SecretPut,
+		Status:    dtos.SecretPending,
+		Key:       "/secrets/cards/user/card",
+		Data:      map[string]interface{}{"pan": "vault:v1:encrypted_pan_data"},
+		Attempts:  2,
+		CreatedAt: time.Now().Add(-2 * time.Hour),
+	}
+
+	mockVaultRepo.EXPECT().Create(gomock.Any(), op.Data, op.Key).Return(errors.New("vault unavailable"))
+	mockCardRepo.EXPECT().Get(gomock.Any(), card.ID).Return(card, nil)
+	// the card is only deleted once the deletion is on the audit trail
+	gomock.InOrder(
+		mockAuditRepo.EXPECT().Record(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, event *audit.Event) error {
+			assert.Equal(t, card.UserId, event.UserID)
+			assert.Equal(t, "cards.compensate", event.Action)
+			assert.Equal(t, card.ID.String(), event.ResourceID)
+			assert.Equal(t, audit.Failed, event.Outcome)
+			assert.Contains(t, event.Reason, "vault unavailable")
+			return nil
+		}),
+		mockCardRepo.EXPECT().Delete(gomock.Any(), op.CardID).Return(nil),
+	)
+	mockOperationRepo.EXPECT().Save(gomock.Any(), op).Return(nil)
+
+	err := outbox.Apply(context.Background(), op)
+
+	assert.Error(t, err)
+	// the secret is removed with retries in case it was written after all
+	assert.Equal(t, dtos.SecretDelete, op.Type)
+	assert.Equal(t, dtos.SecretPending, op.Status)
+	assert.Equal(t, 0, op.Attempts)
+	assert.Nil(t, op.Data)
+}
+
+func TestReconciler_Purge(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockListRepo := mocks.NewMockReconcileCardRepository(ctrl)
+	mockLister := mocks.NewMockSecretLister(ctrl)
+	mockOperationRepo := mocks.NewMockPendingOperationRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	reconciler := cards.NewReconciler(service, mockListRepo, mockLister, mockOperationRepo, time.Minute)
+
+	userId := uuid.New()
+	old := time.Now().Add(-time.Hour)
+	healthy := &dtos.Card{ID: uuid.New(), UserId: userId, CreatedAt: old}
+	secretless := &dtos.Card{ID: uuid.New(), UserId: userId, CreatedAt: old}
+	inFlight := &dtos.Card{ID: uuid.New(), UserId: userId, CreatedAt: old}
+	recent := &dtos.Card{ID: uuid.New(), UserId: userId, CreatedAt: time.Now()}
+	orphanID := uuid.New()
+	orphanKey := "/secrets/cards/" + userId.String() + "/" + orphanID.String()
+	secret := map[string]interface{}{"pan": "vault:v1:encrypted_pan_data"}
+
+	mockListRepo.EXPECT().ListAll(gomock.Any(), uuid.Nil, gomock.Any()).Return([]*dtos.Card{healthy, secretless, inFlight, recent}, nil)
+	mockVaultRepo.EXPECT().Get(gomock.Any(), "/secrets/cards/"+userId.String()+"/"+healthy.ID.String()).Return(secret, nil)
+	mockVaultRepo.EXPECT().Get(gomock.Any(), "/secrets/cards/"+userId.String()+"/"+secretless.ID.String()).Return(nil, senital.ErrNotFound)
+	mockVaultRepo.EXPECT().Get(gomock.Any(), "/secrets/cards/"+userId.String()+"/"+inFlight.ID.String()).Return(nil, senital.ErrNotFound)
+	mockOperationRepo.EXPECT().HasPending(gomock.Any(), secretless.ID).Return(false, nil)
+	mockOperationRepo.EXPECT().HasPending(gomock.Any(), inFlight.ID).Return(true, nil)
+	mockCardRepo.EXPECT().Delete(gomock.Any(), secretless.ID).Return(nil)
+
+	mockLister.EXPECT().List(gomock.Any(), "secrets/cards").Return([]string{userId.String() + "/"}, nil)
+	mockLister.EXPECT().List(gomock.Any(), "secrets/cards/"+userId.String()).Return([]string{healthy.ID.String(), orphanID.String()}, nil)
+	mockCardRepo.EXPECT().Get(gomock.Any(), healthy.ID).Return(healthy, nil)
+	mockCardRepo.EXPECT().Get(gomock.Any(), orphanID).Return(nil, senital.ErrNotFound)
+	mockVaultRepo.EXPECT().Get(gomock.Any(), orphanKey).Return(secret, nil)
+	mockOperationRepo.EXPECT().HasPending(gomock.Any(), orphanID).Return(false, nil)
+	mockVaultRepo.EXPECT().Delete(gomock.Any(), orphanKey).Return(nil)
+
+	result, err := reconciler.Reconcile(context.Background(), dtos.ReconcilePurge, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{secretless.ID}, result.SecretlessCards)
+	assert.Equal(t, []string{orphanKey}, result.OrphanSecrets)
+	assert.Equal(t, 3, result.CheckedCards)
+	assert.Equal(t, 2, result.CheckedSecrets)
+	assert.Equal(t, 2, result.Purged)
+}
+
+func TestReconciler_RepairDryRun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockListRepo := mocks.NewMockReconcileCardRepository(ctrl)
+	mockLister := mocks.NewMockSecretLister(ctrl)
+	mockOperationRepo := mocks.NewMockPendingOperationRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	reconciler := cards.NewReconciler(service, mockListRepo, mockLister, mockOperationRepo, time.Minute)
+
+	userId := uuid.New()
+	orphanID := uuid.New()
+	orphanKey := "/secrets/cards/" + userId.String() + "/" + orphanID.String()
+
+	// nothing is written in dry run mode, so no Create nor Delete is expected
+	mockListRepo.EXPECT().ListAll(gomock.Any(), uuid.Nil, gomock.Any()).Return(nil, nil)
+	mockLister.EXPECT().List(gomock.Any(), "secrets/cards").Return([]string{userId.String() + "/"}, nil)
+	mockLister.EXPECT().List(gomock.Any(), "secrets/cards/"+userId.String()).Return([]string{orphanID.String()}, nil)
+	mockCardRepo.EXPECT().Get(gomock.Any(), orphanID).Return(nil, senital.ErrNotFound)
+	mockVaultRepo.EXPECT().Get(gomock.Any(), orphanKey).Return(map[string]interface{}{"pan": "vault:v1:encrypted_pan_data"}, nil)
+	mockOperationRepo.EXPECT().HasPending(gomock.Any(), orphanID).Return(false, nil)
+
+	result, err := reconciler.Reconcile(context.Background(), dtos.ReconcileRepair, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{orphanKey}, result.OrphanSecrets)
+	assert.Equal(t, 0, result.Repaired)
+}
+
+func TestRewrapper_Process(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockRewrapKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockRewrapVaultRepository(ctrl)
+	mockJobRepo := mocks.NewMockRewrapJobRepository(ctrl)
+
+	rewrapper := cards.NewRewrapper(mockCardRepo, mockKmsRepo, mockVaultRepo, mockJobRepo, &transactor{})
+
+	userId := uuid.New()
+	stale := &dtos.Card{ID: uuid.New(), UserId: userId, KeyVersion: 1}
+	current := &dtos.Card{ID: uuid.New(), UserId: userId, KeyVersion: 2}
+	deleted := &dtos.Card{ID: uuid.New(), UserId: userId, KeyVersion: 1}
+	failing := &dtos.Card{ID: uuid.New(), UserId: userId, KeyVersion: 1}
+	job := &dtos.RewrapJob{ID: uuid.New(), UserID: userId, Status: dtos.RewrapPending}
+	staleKey := "/secrets/cards/" + userId.String() + "/" + stale.ID.String()
+	failingKey := "/secrets/cards/" + userId.String() + "/" + failing.ID.String()
+
+	mockKmsRepo.EXPECT().GetPublicKey(gomock.Any(), userId.String()).Return("public_key", 2, nil)
+	mockJobRepo.EXPECT().SaveProgress(gomock.Any(), job, gomock.Any()).Return(nil).Times(2)
+	mockCardRepo.EXPECT().ListByUser(gomock.Any(), userId, uuid.Nil, gomock.Any()).Return([]*dtos.Card{stale, current, deleted, failing}, nil)
+
+	// the rewrapped secret is written with the card locked, and the old versions
+	// are destroyed once it is the current one
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), stale.ID).Return(stale, nil)
+	mockKmsRepo.EXPECT().Rewrap(gomock.Any(), "vault:v1:b2xk", userId.String()).Return("vault:v2:bmV3", nil)
+	gomock.InOrder(
+		mockVaultRepo.EXPECT().Get(gomock.Any(), staleKey).Return(map[string]interface{}{"pan": "vault:v1:b2xk"}, nil),
+		mockVaultRepo.EXPECT().Create(gomock.Any(), map[string]interface{}{"pan": "vault:v2:bmV3"}, staleKey).Return(nil),
+		mockVaultRepo.EXPECT().Get(gomock.Any(), staleKey).Return(map[string]interface{}{"pan": "vault:v2:bmV3"}, nil),
+		mockVaultRepo.EXPECT().DestroyPreviousVersions(gomock.Any(), staleKey).Return(nil),
+		mockCardRepo.EXPECT().UpdateKeyVersion(gomock.Any(), stale.ID, 2).Return(nil),
+	)
+
+	// a card deleted since it was listed doesn't get its secret back
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), deleted.ID).Return(nil, senital.ErrNotFound)
+
+	// a card that can't be rewrapped keeps its secret and its version
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), failing.ID).Return(failing, nil)
+	mockVaultRepo.EXPECT().Get(gomock.Any(), failingKey).Return(map[string]interface{}{"pan": "vault:v1:other"}, nil)
+	mockKmsRepo.EXPECT().Rewrap(gomock.Any(), "vault:v1:other", userId.String()).Return("", senital.ErrUnavailable)
+
+	err := rewrapper.Process(context.Background(), job)
+
+	assert.NoError(t, err)
+	assert.Equal(t, dtos.RewrapCompleted, job.Status)
+	assert.Equal(t, 2, job.TargetVersion)
+	assert.Equal(t, 3, job.Processed)
+	assert.Equal(t, 1, job.Failed)
+	assert.Equal(t, failing.ID, job.LastCardID)
+}
+
+func TestRewrapper_ProcessUnappliedWrite(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockRewrapKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockRewrapVaultRepository(ctrl)
+	mockJobRepo := mocks.NewMockRewrapJobRepository(ctrl)
+	mockStore := mocks.NewMockSecretStore(ctrl)
+
+	rewrapper := cards.NewRewrapper(mockCardRepo, mockKmsRepo, mockVaultRepo, mockJobRepo, &transactor{},
+		cards.WithRewrapSecretStore(mockStore))
+
+	userId := uuid.New()
+	card := &dtos.Card{ID: uuid.New(), UserId: userId, KeyVersion: 1}
+	job := &dtos.RewrapJob{ID: uuid.New(), UserID: userId, Status: dtos.RewrapRunning, TargetVersion: 2}
+	key := "/secrets/cards/" + userId.String() + "/" + card.ID.String()
+
+	mockCardRepo.EXPECT().ListByUser(gomock.Any(), userId, uuid.Nil, gomock.Any()).Return([]*dtos.Card{card}, nil)
+	mockCardRepo.EXPECT().GetForUpdate(gomock.Any(), card.ID).Return(card, nil)
+	mockVaultRepo.EXPECT().Get(gomock.Any(), key).Return(map[string]interface{}{"pan": "vault:v1:b2xk"}, nil).Times(2)
+	mockKmsRepo.EXPECT().Rewrap(gomock.Any(), "vault:v1:b2xk", userId.String()).Return("vault:v2:bmV3", nil)
+	mockStore.EXPECT().Put(gomock.Any(), card.ID, key, map[string]interface{}{"pan": "vault:v2:bmV3"}).Return(nil)
+	mockJobRepo.EXPECT().SaveProgress(gomock.Any(), job, gomock.Any()).Return(nil)
+
+	// the store couldn't reach Vault yet, the old versions are kept and the card
+	// stays behind for the next rewrap
+	err := rewrapper.Process(context.Background(), job)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, job.Failed)
+}
+
+func TestRewrapper_Run(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockRewrapKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockRewrapVaultRepository(ctrl)
+	mockJobRepo := mocks.NewMockRewrapJobRepository(ctrl)
+
+	rewrapper := cards.NewRewrapper(mockCardRepo, mockKmsRepo, mockVaultRepo, mockJobRepo, &transactor{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	userId := uuid.New()
+	job := &dtos.RewrapJob{ID: uuid.New(), UserID: userId, Status: dtos.RewrapRunning, TargetVersion: 2}
+
+	// jobs are only processed once claimed with a lease, until none is left
+	gomock.InOrder(
+		mockJobRepo.EXPECT().Claim(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, now time.Time, lockedUntil time.Time) (*dtos.RewrapJob, error) {
+				assert.Equal(t, cards.DefaultRewrapLease, lockedUntil.Sub(now))
+				return job, nil
+			}),
+		mockJobRepo.EXPECT().Claim(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, now time.Time, lockedUntil time.Time) (*dtos.RewrapJob, error) {
+				cancel()
+				return nil, senital.ErrNotFound
+			}),
+	)
+	mockCardRepo.EXPECT().ListByUser(gomock.Any(), userId, uuid.Nil, gomock.Any()).Return(nil, nil)
+	mockJobRepo.EXPECT().SaveProgress(gomock.Any(), job, gomock.Any()).Return(nil)
+
+	rewrapper.Run(ctx, time.Hour)
+
+	assert.Equal(t, dtos.RewrapCompleted, job.Status)
+}
+
+func TestRewrapper_Schedule(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockRewrapKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockRewrapVaultRepository(ctrl)
+	mockJobRepo := mocks.NewMockRewrapJobRepository(ctrl)
+
+	rewrapper := cards.NewRewrapper(mockCardRepo, mockKmsRepo, mockVaultRepo, mockJobRepo, &transactor{})
+
+	userId := uuid.New()
+	active := &dtos.RewrapJob{ID: uuid.New(), UserID: userId, Status: dtos.RewrapRunning, TargetVersion: 2}
+
+	// a job in progress for the latest version is returned instead of starting another one
+	mockJobRepo.EXPECT().FindActiveByUser(gomock.Any(), userId).Return(active, nil)
+	mockKmsRepo.EXPECT().GetPublicKey(gomock.Any(), userId.String()).Return("public_key", 2, nil)
+
+	job, err := rewrapper.Schedule(context.Background(), userId)
+	require.NoError(t, err)
+	assert.Equal(t, active, job)
+
+	other := uuid.New()
+	mockJobRepo.EXPECT().FindActiveByUser(gomock.Any(), other).Return(nil, senital.ErrNotFound)
+	mockJobRepo.EXPECT().Create(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, job *dtos.RewrapJob) error {
+		assert.Equal(t, other, job.UserID)
+		assert.Equal(t, dtos.RewrapPending, job.Status)
+		return nil
+	})
+
+	_, err = rewrapper.Schedule(context.Background(), other)
+	require.NoError(t, err)
+
+	// users only see their own jobs, operators see any
+	mockJobRepo.EXPECT().Get(gomock.Any(), active.ID).Return(active, nil).Times(2)
+
+	_, err = rewrapper.Get(context.Background(), other, active.ID)
+	assert.ErrorIs(t, err, senital.ErrNotFound)
+
+	job, err = rewrapper.GetJob(context.Background(), active.ID)
+	require.NoError(t, err)
+	assert.Equal(t, active, job)
+}
+
+func TestRewrapper_ScheduleAfterAnotherRotation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockRewrapKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockRewrapVaultRepository(ctrl)
+	mockJobRepo := mocks.NewMockRewrapJobRepository(ctrl)
+
+	rewrapper := cards.NewRewrapper(mockCardRepo, mockKmsRepo, mockVaultRepo, mockJobRepo, &transactor{})
+
+	userId := uuid.New()
+	running := &dtos.RewrapJob{ID: uuid.New(), UserID: userId, Status: dtos.RewrapRunning, TargetVersion: 2}
+
+	// the cards the running job went past would stay on version 2
+	mockJobRepo.EXPECT().FindActiveByUser(gomock.Any(), userId).Return(running, nil)
+	mockKmsRepo.EXPECT().GetPublicKey(gomock.Any(), userId.String()).Return("public_key", 3, nil)
+	mockJobRepo.EXPECT().Create(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, job *dtos.RewrapJob) error {
+		job.ID = uuid.New()
+		return nil
+	})
+
+	job, err := rewrapper.Schedule(context.Background(), userId)
+	require.NoError(t, err)
+	assert.NotEqual(t, running.ID, job.ID)
+	assert.Equal(t, dtos.RewrapPending, job.Status)
+
+	// a pending job picks the latest version once it starts
+	mockJobRepo.EXPECT().FindActiveByUser(gomock.Any(), userId).Return(job, nil)
+
+	again, err := rewrapper.Schedule(context.Background(), userId)
+	require.NoError(t, err)
+	assert.Equal(t, job, again)
+}
+
+func TestRevealer_Reveal(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockAuditRepo := mocks.NewMockAuditRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	revealer := cards.NewRevealer(service, mockAuditRepo, cards.DefaultRevealPermission)
+
+	card := &dtos.Card{ID: uuid.New(), UserId: uuid.New(), CardHolder: "John Doe"}
+
+	decryptedPan := base64.StdEncoding.EncodeToString([]byte("4111111111111111"))
+	mockCardRepo.EXPECT().Get(gomock.Any(), card.ID).Return(card, nil)
+	mockVaultRepo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(map[string]interface{}{"pan": "encrypted_pan_data"}, nil)
+	mockKmsRepo.EXPECT().Decrypt(gomock.Any(), "vault:v1:encrypted_pan_data", card.UserId.String()).Return(decryptedPan, nil)
+	mockAuditRepo.EXPECT().Record(gomock.Any(), gomock.Any()).Do(recordOutcome(t, card, audit.Allowed)).Return(nil)
+
+	revealed, err := revealer.Reveal(withScope(card.UserId, "cards:read cards:reveal"), card)
+
+	require.NoError(t, err)
+	assert.Equal(t, "4111111111111111", revealed.Pan)
+}
+
+func TestRevealer_Denied(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockAuditRepo := mocks.NewMockAuditRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	revealer := cards.NewRevealer(service, mockAuditRepo, cards.DefaultRevealPermission)
+
+	card := &dtos.Card{ID: uuid.New(), UserId: uuid.New()}
+
+	// the secret is never read without the permission
+	mockAuditRepo.EXPECT().Record(gomock.Any(), gomock.Any()).Do(recordOutcome(t, card, audit.Denied)).Return(nil)
+
+	revealed, err := revealer.Reveal(withScope(card.UserId, "cards:read"), card)
+
+	assert.ErrorIs(t, err, senital.ErrForbidden)
+	assert.Nil(t, revealed)
+
+	// a denial that can't be audited is an error, not a 403
+	auditErr := errors.New("audit_events is unavailable")
+	mockAuditRepo.EXPECT().Record(gomock.Any(), gomock.Any()).Do(recordOutcome(t, card, audit.Denied)).Return(auditErr)
+
+	revealed, err = revealer.Reveal(withUser(card.UserId), card)
+
+	assert.ErrorIs(t, err, auditErr)
+	assert.NotErrorIs(t, err, senital.ErrForbidden)
+	assert.Nil(t, revealed)
+}
+
+func TestRevealer_Failed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockAuditRepo := mocks.NewMockAuditRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	revealer := cards.NewRevealer(service, mockAuditRepo, cards.DefaultRevealPermission)
+
+	card := &dtos.Card{ID: uuid.New(), UserId: uuid.New()}
+
+	// the failure is audited, and reported even when the audit write fails too
+	mockCardRepo.EXPECT().Get(gomock.Any(), card.ID).Return(card, nil)
+	mockVaultRepo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(nil, senital.ErrUnavailable)
+	mockAuditRepo.EXPECT().Record(gomock.Any(), gomock.Any()).Do(recordOutcome(t, card, audit.Failed)).Return(errors.New("audit_events is unavailable"))
+
+	revealed, err := revealer.Reveal(withScope(card.UserId, "cards:reveal"), card)
+
+	assert.ErrorIs(t, err, senital.ErrUnavailable)
+	assert.Nil(t, revealed)
+}
+
+func TestRevealer_AuditFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockAuditRepo := mocks.NewMockAuditRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	revealer := cards.NewRevealer(service, mockAuditRepo, cards.DefaultRevealPermission)
+
+	card := &dtos.Card{ID: uuid.New(), UserId: uuid.New()}
+
+	// the PAN is only returned once the reveal is on the audit trail
+	auditErr := errors.New("audit_events is unavailable")
+	decryptedPan := base64.StdEncoding.EncodeToString([]byte("4111111111111111"))
+	mockCardRepo.EXPECT().Get(gomock.Any(), card.ID).Return(card, nil)
+	mockVaultRepo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(map[string]interface{}{"pan": "encrypted_pan_data"}, nil)
+	mockKmsRepo.EXPECT().Decrypt(gomock.Any(), "vault:v1:encrypted_pan_data", card.UserId.String()).Return(decryptedPan, nil)
+	mockAuditRepo.EXPECT().Record(gomock.Any(), gomock.Any()).Do(recordOutcome(t, card, audit.Allowed)).Return(auditErr)
+
+	revealed, err := revealer.Reveal(withScope(card.UserId, "cards:reveal"), card)
+
+	assert.ErrorIs(t, err, auditErr)
+	assert.Nil(t, revealed)
+}
+
+func TestLastDigitsBackfill_Run(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockLegacyRepo := mocks.NewMockLegacyDigitsRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	backfill := cards.NewLastDigitsBackfill(service, mockLegacyRepo)
+
+	userId := uuid.New()
+	legacy := &dtos.Card{ID: uuid.New(), UserId: userId}
+	invalid := &dtos.Card{ID: uuid.New(), UserId: userId}
+	unreachable := &dtos.Card{ID: uuid.New(), UserId: userId}
+
+	mockLegacyRepo.EXPECT().ListLegacyDigits(gomock.Any(), uuid.Nil, gomock.Any()).Return([]*dtos.Card{legacy, invalid, unreachable}, nil)
+
+	validPan := base64.StdEncoding.EncodeToString([]byte("4111111111111111"))
+	mockVaultRepo.EXPECT().Get(gomock.Any(), "/secrets/cards/"+userId.String()+"/"+legacy.ID.String()).Return(map[string]interface{}{"pan": "vault:v1:valid"}, nil)
+	mockKmsRepo.EXPECT().Decrypt(gomock.Any(), "vault:v1:valid", userId.String()).Return(validPan, nil)
+	mockKmsRepo.EXPECT().HMAC(gomock.Any(), validPan, cards.DefaultFingerprintKey, 1).Return("3q2+7w==", nil)
+	mockLegacyRepo.EXPECT().UpdatePanMetadata(gomock.Any(), legacy).Return(nil)
+
+	// a PAN failing the validation is marked so the next runs skip it
+	invalidPan := base64.StdEncoding.EncodeToString([]byte("4111111111111112"))
+	mockVaultRepo.EXPECT().Get(gomock.Any(), "/secrets/cards/"+userId.String()+"/"+invalid.ID.String()).Return(map[string]interface{}{"pan": "vault:v1:invalid"}, nil)
+	mockKmsRepo.EXPECT().Decrypt(gomock.Any(), "vault:v1:invalid", userId.String()).Return(invalidPan, nil)
+	mockLegacyRepo.EXPECT().MarkInvalidPan(gomock.Any(), invalid.ID).Return(nil)
+
+	// a card that can't be read now is left for the next run
+	mockVaultRepo.EXPECT().Get(gomock.Any(), "/secrets/cards/"+userId.String()+"/"+unreachable.ID.String()).Return(nil, senital.ErrUnavailable)
+
+	result, err := backfill.Run(context.Background(), false)
+
+	require.NoError(t, err)
+	assert.Equal(t, &cards.BackfillResult{Migrated: 1, Skipped: 1, Failed: 1}, result)
+	assert.Equal(t, "1111", legacy.LastDigits)
+	assert.Equal(t, "411111******1111", legacy.MaskedPan)
+	assert.Equal(t, "deadbeef", legacy.Fingerprint)
+}
+
+func TestLastDigitsBackfill_DryRun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockLegacyRepo := mocks.NewMockLegacyDigitsRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	backfill := cards.NewLastDigitsBackfill(service, mockLegacyRepo)
+
+	userId := uuid.New()
+	invalid := &dtos.Card{ID: uuid.New(), UserId: userId}
+
+	// nothing is written in dry run mode, so no UpdatePanMetadata nor MarkInvalidPan is expected
+	invalidPan := base64.StdEncoding.EncodeToString([]byte("4111111111111112"))
+	mockLegacyRepo.EXPECT().ListLegacyDigits(gomock.Any(), uuid.Nil, gomock.Any()).Return([]*dtos.Card{invalid}, nil)
+	mockVaultRepo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(map[string]interface{}{"pan": "vault:v1:invalid"}, nil)
+	mockKmsRepo.EXPECT().Decrypt(gomock.Any(), "vault:v1:invalid", userId.String()).Return(invalidPan, nil)
+
+	result, err := backfill.Run(context.Background(), true)
+
+	require.NoError(t, err)
+	assert.Equal(t, &cards.BackfillResult{Skipped: 1}, result)
+}
+
+func TestLastDigitsBackfill_Resume(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCardRepo := mocks.NewMockCardRepository(ctrl)
+	mockKmsRepo := mocks.NewMockKmsRepository(ctrl)
+	mockVaultRepo := mocks.NewMockVaultRepository(ctrl)
+	mockLegacyRepo := mocks.NewMockLegacyDigitsRepository(ctrl)
+
+	service := cards.NewCardService(mockCardRepo, mockKmsRepo, mockVaultRepo)
+	backfill := cards.NewLastDigitsBackfill(service, mockLegacyRepo)
+
+	userId := uuid.New()
+	page := make([]*dtos.Card, 100)
+	for i := range page {
+		page[i] = &dtos.Card{ID: uuid.New(), UserId: userId}
+	}
+	last := page[len(page)-1]
+	remaining := &dtos.Card{ID: uuid.New(), UserId: userId}
+
+	validPan := base64.StdEncoding.EncodeToString([]byte("4111111111111111"))
+	mockVaultRepo.EXPECT().Get(gomock.Any(), gomock.Any()).Return(map[string]interface{}{"pan": "vault:v1:valid"}, nil).Times(101)
+	mockKmsRepo.EXPECT().Decrypt(gomock.Any(), "vault:v1:valid", userId.String()).Return(validPan, nil).Times(101)
+	mockKmsRepo.EXPECT().HMAC(gomock.Any(), validPan, cards.DefaultFingerprintKey, 1).Return("3q2+7w==", nil).Times(101)
+	mockLegacyRepo.EXPECT().UpdatePanMetadata(gomock.Any(), gomock.Any()).Return(nil).Times(101)
+
+	// the next page starts after the last card, a run stopped there loses nothing
+	gomock.InOrder(
+		mockLegacyRepo.EXPECT().ListLegacyDigits(gomock.Any(), uuid.Nil, gomock.Any()).Return(page, nil),
+		mockLegacyRepo.EXPECT().ListLegacyDigits(gomock.Any(), last.ID, gomock.Any()).Return(nil, senital.ErrUnavailable),
+	)
+
+	result, err := backfill.Run(context.Background(), false)
+
+	assert.ErrorIs(t, err, senital.ErrUnavailable)
+	assert.Equal(t, 100, result.Migrated)
+
+	// migrated cards aren't listed anymore, so a new run only gets the rest
+	mockLegacyRepo.EXPECT().ListLegacyDigits(gomock.Any(), uuid.Nil, gomock.Any()).Return([]*dtos.Card{remaining}, nil)
+
+	result, err = backfill.Run(context.Background(), false)
+
+	require.NoError(t, err)
+	assert.Equal(t, &cards.BackfillResult{Migrated: 1}, result)
 }
@@ -3,71 +3,204 @@ package cards
 import (
 	"context"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"regexp"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/internal/cards/validation"
 	"github.com/juaguz/yuno/kit/errors/senital"
+	"github.com/juaguz/yuno/kit/kms"
 )
 
 var (
-	ErrInvalidPan = errors.New("invalid pan")
+	ErrInvalidPan        = validation.ErrInvalidPan
+	ErrKeyVersionTooOld  = errors.New("key version is below the minimum allowed")
+	ErrInvalidKeyVersion = errors.New("invalid key version")
+	ErrInvalidFilter     = errors.New("invalid filter")
+	ErrDuplicateCard     = errors.New("card already stored")
 )
 
-func isValidCreditCard(cardNumber string) bool {
-	regex := `^(?:4[0-9]{12}(?:[0-9]{3})?` + // Visa
-		`|5[1-5][0-9]{14}` + // MasterCard
-		`|3[47][0-9]{13}` + // American Express
-		`|6(?:011|5[0-9]{2})[0-9]{12}` + // Discover
-		`|(?:2131|1800|35\d{3})\d{11})$` // JCB
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
 
-	re := regexp.MustCompile(regex)
-	return re.MatchString(cardNumber)
-}
+// DuplicatePolicy tells Create what to do when the user already stored the PAN.
+type DuplicatePolicy string
+
+const (
+	// DuplicateAllow stores the card again under a new ID.
+	DuplicateAllow DuplicatePolicy = "allow"
+	// DuplicateReject fails with ErrDuplicateCard.
+	DuplicateReject DuplicatePolicy = "reject"
+	// DuplicateReturnExisting returns the card already stored without creating a new one.
+	DuplicateReturnExisting DuplicatePolicy = "return_existing"
+)
+
+// DefaultFingerprintKey is the KMS key used to fingerprint PANs. It is shared by
+// every user so the same PAN gets the same fingerprint across merchants.
+const DefaultFingerprintKey = "card-fingerprint"
+
+// fingerprintKeyVersion is pinned, fingerprints computed with another version of
+// the key would never match the stored ones.
+const fingerprintKeyVersion = 1
+
+// defaultKeyVersion is assumed when the client doesn't send the version it encrypted with,
+// which keeps clients written before key rotation working.
+const defaultKeyVersion = 1
 
 func buildKey(userID uuid.UUID, cardID uuid.UUID) string {
 	key := fmt.Sprintf("/secrets/cards/%s/%s", userID, cardID)
 	return key
 }
 
+// secretCiphertext returns the transit ciphertext of the PAN stored in a card secret.
+func secretCiphertext(key string, secret map[string]interface{}) (string, error) {
+	ciphertext, ok := secret["pan"].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s has no pan", key)
+	}
+
+	// secrets written before key versions were tracked hold the bare client ciphertext
+	if !strings.HasPrefix(ciphertext, "vault:") {
+		ciphertext = kms.FormatCiphertext(defaultKeyVersion, ciphertext)
+	}
+
+	return ciphertext, nil
+}
+
 type CardRepository interface {
 	Create(ctx context.Context, card *dtos.Card) error
 	Get(ctx context.Context, id uuid.UUID) (*dtos.Card, error)
+	// GetForUpdate is Get, locking the card until the transaction of ctx ends.
+	GetForUpdate(ctx context.Context, id uuid.UUID) (*dtos.Card, error)
 	UpdateOne(ctx context.Context, card *dtos.Card) error
 	Delete(ctx context.Context, id uuid.UUID) error
+	ListByUser(ctx context.Context, userID uuid.UUID, after uuid.UUID, limit int) ([]*dtos.Card, error)
+	List(ctx context.Context, filter *dtos.CardFilter) ([]*dtos.Card, error)
+	UpdateKeyVersion(ctx context.Context, id uuid.UUID, version int) error
+	GetByFingerprint(ctx context.Context, userID uuid.UUID, fingerprint string) (*dtos.Card, error)
 }
 
 type KmsRepository interface {
 	Decrypt(ctx context.Context, data string, key string) (string, error)
+	HMAC(ctx context.Context, data string, key string, version int) (string, error)
 }
 
 type VaultRepository interface {
 	Create(ctx context.Context, data map[string]interface{}, key string) error
+	Get(ctx context.Context, key string) (map[string]interface{}, error)
 	Delete(ctx context.Context, key string) error
 }
 
+// SecretStore writes and removes the PAN secrets of the cards. Implementations
+// may defer the Vault call until the transaction in ctx is committed.
+type SecretStore interface {
+	Put(ctx context.Context, cardID uuid.UUID, key string, data map[string]interface{}) error
+	Delete(ctx context.Context, cardID uuid.UUID, key string) error
+}
+
+// vaultSecretStore calls Vault right away, a failure after the secret is written
+// can leave it orphaned. SecretOutbox is the consistent alternative.
+type vaultSecretStore struct {
+	VaultRepository VaultRepository
+}
+
+func (v vaultSecretStore) Put(ctx context.Context, cardID uuid.UUID, key string, data map[string]interface{}) error {
+	return v.VaultRepository.Create(ctx, data, key)
+}
+
+func (v vaultSecretStore) Delete(ctx context.Context, cardID uuid.UUID, key string) error {
+	return v.VaultRepository.Delete(ctx, key)
+}
+
 type CardService struct {
 	CardRepository  CardRepository
 	KmsRepository   KmsRepository
 	VaultRepository VaultRepository
+	SecretStore     SecretStore
+	MinKeyVersion   int
+	FingerprintKey  string
+	DuplicatePolicy DuplicatePolicy
+}
+
+type Option func(*CardService)
+
+// WithMinKeyVersion rejects cards encrypted with a key version lower than version.
+func WithMinKeyVersion(version int) Option {
+	return func(c *CardService) {
+		c.MinKeyVersion = version
+	}
 }
 
-func NewCardService(cardRepository CardRepository, kmsRepository KmsRepository, vaultRepository VaultRepository) *CardService {
-	return &CardService{
+// WithFingerprintKey changes the KMS key used to fingerprint PANs.
+func WithFingerprintKey(key string) Option {
+	return func(c *CardService) {
+		c.FingerprintKey = key
+	}
+}
+
+// WithDuplicatePolicy sets what Create does when the user already stored the PAN.
+func WithDuplicatePolicy(policy DuplicatePolicy) Option {
+	return func(c *CardService) {
+		c.DuplicatePolicy = policy
+	}
+}
+
+// WithSecretStore changes how card secrets are written and removed, Vault is
+// called directly by default.
+func WithSecretStore(store SecretStore) Option {
+	return func(c *CardService) {
+		c.SecretStore = store
+	}
+}
+
+func NewCardService(cardRepository CardRepository, kmsRepository KmsRepository, vaultRepository VaultRepository, opts ...Option) *CardService {
+	c := &CardService{
 		CardRepository:  cardRepository,
 		KmsRepository:   kmsRepository,
 		VaultRepository: vaultRepository,
+		SecretStore:     vaultSecretStore{VaultRepository: vaultRepository},
+		FingerprintKey:  DefaultFingerprintKey,
+		DuplicatePolicy: DuplicateAllow,
+	}
+
+	for _, opt := range opts {
+		opt(c)
 	}
+
+	return c
 }
 
 func (c *CardService) Create(ctx context.Context, card *dtos.Card) (*dtos.Card, error) {
-	card.ID = uuid.New()
+	return c.create(ctx, uuid.New(), card)
+}
 
-	encryptedPan := card.Pan
+// create stores card under id, the ID of card itself comes from the client.
+func (c *CardService) create(ctx context.Context, id uuid.UUID, card *dtos.Card) (*dtos.Card, error) {
+	card.ID = id
 
-	decryptedPan, err := c.KmsRepository.Decrypt(ctx, "vault:v1:"+encryptedPan, card.UserId.String())
+	if card.KeyVersion == 0 {
+		card.KeyVersion = defaultKeyVersion
+	}
+	if card.KeyVersion < 0 {
+		return nil, ErrInvalidKeyVersion
+	}
+	if card.KeyVersion < c.MinKeyVersion {
+		return nil, ErrKeyVersionTooOld
+	}
+
+	if err := validateExpiry(card.ExpiryMonth, card.ExpiryYear, time.Now()); err != nil {
+		return nil, err
+	}
+
+	encryptedPan := kms.FormatCiphertext(card.KeyVersion, card.Pan)
+
+	decryptedPan, err := c.KmsRepository.Decrypt(ctx, encryptedPan, card.UserId.String())
 	if err != nil {
 		return nil, err
 	}
@@ -78,21 +211,48 @@ func (c *CardService) Create(ctx context.Context, card *dtos.Card) (*dtos.Card,
 	}
 
 	pan := string(decodedPan)
-	if !isValidCreditCard(pan) {
-		return nil, ErrInvalidPan
+	network, err := validation.Validate(pan)
+	if err != nil {
+		return nil, err
 	}
 
-	card.Pan = pan[:4]
+	setPanMetadata(card, pan, network)
+	// the ciphertext only goes to Vault
+	card.Pan = ""
+
+	card.Fingerprint, err = c.fingerprint(ctx, pan)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.DuplicatePolicy != DuplicateAllow {
+		// the lookup spares a failed insert, a concurrent duplicate is only
+		// caught by the unique index of the deduplicated cards
+		card.Deduplicated = true
+		existing, err := c.CardRepository.GetByFingerprint(ctx, card.UserId, card.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			if c.DuplicatePolicy == DuplicateReject {
+				return nil, ErrDuplicateCard
+			}
+			return existing, nil
+		}
+	}
 
 	err = c.CardRepository.Create(ctx, card)
+	if errors.Is(err, senital.ErrConflict) {
+		return nil, ErrDuplicateCard
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	key := buildKey(card.UserId, card.ID)
-	err = c.VaultRepository.Create(ctx, map[string]interface{}{
+	err = c.SecretStore.Put(ctx, card.ID, key, map[string]interface{}{
 		"pan": encryptedPan,
-	}, key)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -100,41 +260,138 @@ func (c *CardService) Create(ctx context.Context, card *dtos.Card) (*dtos.Card,
 	return card, nil
 }
 
+// fingerprint returns the hex HMAC of the PAN, the HMAC key never leaves the KMS.
+func (c *CardService) fingerprint(ctx context.Context, pan string) (string, error) {
+	mac, err := c.KmsRepository.HMAC(ctx, base64.StdEncoding.EncodeToString([]byte(pan)), c.FingerprintKey, fingerprintKeyVersion)
+	if err != nil {
+		return "", fmt.Errorf("fingerprinting card: %w", err)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(mac)
+	if err != nil {
+		return "", fmt.Errorf("fingerprinting card: %w", err)
+	}
+
+	return hex.EncodeToString(raw), nil
+}
+
+// Get returns the card with card.ID when it belongs to the caller in ctx.
 func (c *CardService) Get(ctx context.Context, card *dtos.Card) (*dtos.Card, error) {
-	card, err := c.CardRepository.Get(ctx, card.ID)
+	return c.ownedCard(ctx, card.ID)
+}
+
+// List returns a page of the cards of filter.UserID. cursor is the NextCursor of
+// the previous page, empty for the first one.
+func (c *CardService) List(ctx context.Context, filter *dtos.CardFilter, cursor string) (*dtos.CardPage, error) {
+	if filter.Order == "" {
+		filter.Order = dtos.Descending
+	}
+	if filter.Order != dtos.Ascending && filter.Order != dtos.Descending {
+		return nil, fmt.Errorf("%w: unknown order %q", ErrInvalidFilter, filter.Order)
+	}
+
+	if filter.Limit == 0 {
+		filter.Limit = DefaultPageSize
+	}
+	if filter.Limit < 0 || filter.Limit > MaxPageSize {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidFilter, MaxPageSize)
+	}
+
+	if cursor != "" {
+		after, err := decodeCursor(cursor, filter.Order)
+		if err != nil {
+			return nil, err
+		}
+		filter.AfterCreatedAt = after.CreatedAt
+		filter.AfterID = after.ID
+	}
+
+	// one extra card tells whether there is a next page
+	pageFilter := *filter
+	pageFilter.Limit++
+
+	cards, err := c.CardRepository.List(ctx, &pageFilter)
 	if err != nil {
 		return nil, err
 	}
-	if card == nil {
-		return nil, senital.ErrNotFound
+
+	page := &dtos.CardPage{Items: cards}
+	if len(cards) > filter.Limit {
+		page.Items = cards[:filter.Limit]
+		page.NextCursor = encodeCursor(page.Items[filter.Limit-1], filter.Order)
 	}
 
-	if card.UserId != card.UserId {
-		return nil, senital.ErrNotFound
+	return page, nil
+}
+
+// Detokenize returns the card with its clear PAN. Cards owned by someone else
+// than the caller in ctx are reported as not found.
+func (c *CardService) Detokenize(ctx context.Context, card *dtos.Card) (*dtos.RevealedCard, error) {
+	stored, err := c.ownedCard(ctx, card.ID)
+	if err != nil {
+		return nil, err
 	}
 
-	return card, nil
+	pan, err := c.decryptPan(ctx, stored)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dtos.RevealedCard{
+		ID:         stored.ID,
+		CardHolder: stored.CardHolder,
+		Pan:        pan,
+	}, nil
 }
 
+// decryptPan reads the secret of the card and returns its clear PAN.
+func (c *CardService) decryptPan(ctx context.Context, card *dtos.Card) (string, error) {
+	key := buildKey(card.UserId, card.ID)
+	secret, err := c.VaultRepository.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := secretCiphertext(key, secret)
+	if err != nil {
+		return "", err
+	}
+
+	decryptedPan, err := c.KmsRepository.Decrypt(ctx, ciphertext, card.UserId.String())
+	if err != nil {
+		return "", err
+	}
+
+	decodedPan, err := base64.StdEncoding.DecodeString(decryptedPan)
+	if err != nil {
+		return "", err
+	}
+
+	return string(decodedPan), nil
+}
+
+// Update changes the card holder of card.ID when it belongs to the caller in ctx.
 func (c *CardService) Update(ctx context.Context, card *dtos.Card) error {
-	if _, err := c.Get(ctx, card); err != nil {
+	if _, err := c.lockOwnedCard(ctx, card.ID); err != nil {
 		return err
 	}
 
 	return c.CardRepository.UpdateOne(ctx, card)
 }
 
+// Delete removes card.ID and its secret when it belongs to the caller in ctx.
 func (c *CardService) Delete(ctx context.Context, card *dtos.Card) error {
-	if _, err := c.Get(ctx, card); err != nil {
+	stored, err := c.lockOwnedCard(ctx, card.ID)
+	if err != nil {
 		return err
 	}
 
-	if err := c.CardRepository.Delete(ctx, card.ID); err != nil {
+	if err := c.CardRepository.Delete(ctx, stored.ID); err != nil {
 		return err
 	}
 
-	key := buildKey(card.UserId, card.ID)
-	if err := c.VaultRepository.Delete(ctx, key); err != nil {
+	key := buildKey(stored.UserId, stored.ID)
+	if err := c.SecretStore.Delete(ctx, stored.ID, key); err != nil {
 		return err
 	}
 
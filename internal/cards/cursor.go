@@ -0,0 +1,45 @@
+package cards
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/internal/cards/dtos"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the position of the last card of a page. It is handed to clients
+// as an opaque base64 string.
+type cursor struct {
+	CreatedAt time.Time      `json:"c"`
+	ID        uuid.UUID      `json:"i"`
+	Order     dtos.SortOrder `json:"o"`
+}
+
+func encodeCursor(card *dtos.Card, order dtos.SortOrder) string {
+	b, _ := json.Marshal(cursor{CreatedAt: card.CreatedAt, ID: card.ID, Order: order})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(value string, order dtos.SortOrder) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	// a cursor is only meaningful for the order it was produced with
+	if c.Order != order || c.ID == uuid.Nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
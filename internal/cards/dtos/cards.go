@@ -1,12 +1,67 @@
 package dtos
 
-import "github.com/google/uuid"
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type Card struct {
 	ID         uuid.UUID `json:"id"`
 	CardHolder string    `json:"card_holder"`
-	Pan        string    `json:"pan"`
-	UserId     uuid.UUID `json:"user_id"`
+	// Pan is only set with the client ciphertext when the card is created
+	Pan         string    `json:"pan,omitempty"`
+	LastDigits  string    `json:"last_digits"`
+	MaskedPan   string    `json:"masked_pan"`
+	UserId      uuid.UUID `json:"user_id"`
+	KeyVersion  int       `json:"key_version"`
+	Brand       string    `json:"brand"`
+	Bin         string    `json:"bin"`
+	PanLength   int       `json:"pan_length"`
+	ExpiryMonth int       `json:"expiry_month,omitempty"`
+	ExpiryYear  int       `json:"expiry_year,omitempty"`
+	// Fingerprint is the same for every card holding the same PAN, whatever the user
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Deduplicated is set when the card was created under a policy refusing the
+	// same PAN twice, the database then rejects a concurrent duplicate
+	Deduplicated bool      `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// MaskPan builds the display PAN keeping the first 6 and last 4 digits, as
+// allowed by PCI DSS truncation rules, e.g. 411111******1111.
+func MaskPan(firstDigits string, length int, lastDigits string) string {
+	if len(firstDigits) < 6 || len(lastDigits) != 4 || length < 10 {
+		return ""
+	}
+
+	return firstDigits[:6] + strings.Repeat("*", length-10) + lastDigits
+}
+
+type SortOrder string
+
+const (
+	Ascending  SortOrder = "asc"
+	Descending SortOrder = "desc"
+)
+
+// CardFilter selects the cards of a user. Cards are sorted by creation date and
+// the page starts right after the (AfterCreatedAt, AfterID) position when set.
+type CardFilter struct {
+	UserID         uuid.UUID
+	LastDigits     string
+	CardHolder     string
+	Brand          string
+	Order          SortOrder
+	Limit          int
+	AfterCreatedAt time.Time
+	AfterID        uuid.UUID
+}
+
+type CardPage struct {
+	Items      []*Card `json:"items"`
+	NextCursor string  `json:"next_cursor,omitempty"`
 }
 
 //enum for status
@@ -14,16 +69,190 @@ type Card struct {
 type Status string
 
 const (
+	Pending   Status = "pending"
 	Succeeded Status = "succeeded"
 	Failed    Status = "failed"
 )
 
+type BatchErrorCode string
+
+const (
+	ErrorNotFound    BatchErrorCode = "not_found"
+	ErrorForbidden   BatchErrorCode = "forbidden"
+	ErrorInvalid     BatchErrorCode = "invalid"
+	ErrorUnavailable BatchErrorCode = "unavailable"
+	ErrorTimeout     BatchErrorCode = "timeout"
+	ErrorInternal    BatchErrorCode = "internal"
+	// ErrorAborted is reported for the items of an atomic batch rolled back
+	// because another one failed
+	ErrorAborted BatchErrorCode = "aborted"
+)
+
+// BatchError tells why an item of a batch failed. Retryable items may succeed
+// when submitted again, the others will fail the same way.
+type BatchError struct {
+	Code      BatchErrorCode `json:"code"`
+	Message   string         `json:"message"`
+	Retryable bool           `json:"retryable"`
+}
+
+// BatchUpdateStatus is the outcome of an item of a batch, Index is its position in
+// the request. CardID is the created card for a batch create.
 type BatchUpdateStatus struct {
-	Status Status    `json:"status"`
-	CardID uuid.UUID `json:"card"`
+	Index  int         `json:"index"`
+	Status Status      `json:"status"`
+	CardID uuid.UUID   `json:"card"`
+	Error  *BatchError `json:"error,omitempty"`
 }
 
 type BatchUpdate struct {
 	ID         uuid.UUID `json:"id"`
 	CardHolder string    `json:"card_holder"`
 }
+
+// BatchCreate is a card of a batch create, Pan is the client ciphertext as in a
+// single create. ID is the card to create, picked when the batch is queued, a
+// random one when unset.
+type BatchCreate struct {
+	ID          uuid.UUID `json:"-"`
+	CardHolder  string    `json:"card_holder"`
+	Pan         string    `json:"pan"`
+	KeyVersion  int       `json:"key_version"`
+	ExpiryMonth int       `json:"expiry_month"`
+	ExpiryYear  int       `json:"expiry_year"`
+}
+
+type BatchDelete struct {
+	ID uuid.UUID `json:"id"`
+}
+
+type BatchOperation string
+
+const (
+	OperationCreate BatchOperation = "create"
+	OperationUpdate BatchOperation = "update"
+	OperationDelete BatchOperation = "delete"
+)
+
+type BatchJobStatus string
+
+const (
+	BatchJobPending   BatchJobStatus = "pending"
+	BatchJobRunning   BatchJobStatus = "running"
+	BatchJobCompleted BatchJobStatus = "completed"
+)
+
+// BatchJob is a batch of card creates, updates or deletes processed in the
+// background. Items are only loaded when the job is fetched with its outcomes.
+type BatchJob struct {
+	ID        uuid.UUID       `json:"id"`
+	UserID    uuid.UUID       `json:"user_id"`
+	Operation BatchOperation  `json:"operation"`
+	Status    BatchJobStatus  `json:"status"`
+	Atomic    bool            `json:"atomic"`
+	Total     int             `json:"total"`
+	Succeeded int             `json:"succeeded"`
+	Failed    int             `json:"failed"`
+	Items     []*BatchJobItem `json:"items,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// BatchJobItem is a card of a batch job, Position is its index in the request.
+// The card of a create is picked when queued, but only exposed once processed. The PAN ciphertext, key
+// version and expiry are only set for creates, the PAN until it is processed.
+type BatchJobItem struct {
+	ID          uuid.UUID   `json:"-"`
+	Position    int         `json:"index"`
+	CardID      uuid.UUID   `json:"card"`
+	CardHolder  string      `json:"-"`
+	Pan         string      `json:"-"`
+	KeyVersion  int         `json:"-"`
+	ExpiryMonth int         `json:"-"`
+	ExpiryYear  int         `json:"-"`
+	Status      Status      `json:"status"`
+	Error       *BatchError `json:"error,omitempty"`
+}
+
+type RewrapStatus string
+
+const (
+	RewrapPending   RewrapStatus = "pending"
+	RewrapRunning   RewrapStatus = "running"
+	RewrapCompleted RewrapStatus = "completed"
+)
+
+type RewrapJob struct {
+	ID            uuid.UUID    `json:"id"`
+	UserID        uuid.UUID    `json:"user_id"`
+	Status        RewrapStatus `json:"status"`
+	TargetVersion int          `json:"target_version"`
+	LastCardID    uuid.UUID    `json:"-"`
+	Processed     int          `json:"processed"`
+	Failed        int          `json:"failed"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+	// LockedUntil is the lease of the instance processing the job
+	LockedUntil *time.Time `json:"-"`
+}
+
+type RevealedCard struct {
+	ID         uuid.UUID `json:"id"`
+	CardHolder string    `json:"card_holder"`
+	Pan        string    `json:"pan"`
+}
+
+type SecretOperationType string
+
+const (
+	SecretPut    SecretOperationType = "put"
+	SecretDelete SecretOperationType = "delete"
+)
+
+type SecretOperationStatus string
+
+const (
+	SecretPending SecretOperationStatus = "pending"
+	SecretDone    SecretOperationStatus = "done"
+	// SecretSuperseded is an operation replaced by a newer one of the same secret
+	// before it was applied.
+	SecretSuperseded SecretOperationStatus = "superseded"
+)
+
+// SecretOperation is a write or removal of a card secret in Vault, recorded in
+// the same transaction as the card row so it can be retried until it is applied.
+type SecretOperation struct {
+	ID            uuid.UUID
+	CardID        uuid.UUID
+	Type          SecretOperationType
+	Status        SecretOperationStatus
+	Key           string
+	Data          map[string]interface{}
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	LockedUntil   *time.Time
+	CreatedAt     time.Time
+}
+
+type ReconcileMode string
+
+const (
+	// ReconcileReportOnly only reports the drift.
+	ReconcileReportOnly ReconcileMode = "report"
+	// ReconcileRepair rebuilds the card rows of orphaned secrets.
+	ReconcileRepair ReconcileMode = "repair"
+	// ReconcilePurge deletes orphaned secrets and secretless card rows.
+	ReconcilePurge ReconcileMode = "purge"
+)
+
+// ReconcileResult lists the drift found between the card rows and their secrets.
+type ReconcileResult struct {
+	CheckedCards    int         `json:"checked_cards"`
+	CheckedSecrets  int         `json:"checked_secrets"`
+	OrphanSecrets   []string    `json:"orphan_secrets"`
+	SecretlessCards []uuid.UUID `json:"secretless_cards"`
+	Repaired        int         `json:"repaired"`
+	Purged          int         `json:"purged"`
+	Failed          int         `json:"failed"`
+}
@@ -0,0 +1,48 @@
+package cards
+
+import (
+	"errors"
+	"time"
+
+	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/internal/cards/validation"
+)
+
+var ErrInvalidExpiry = errors.New("invalid expiry date")
+
+// bin returns the issuer identification number of the PAN, 8 digits for PANs
+// of 16 or more digits as per ISO/IEC 7812 and 6 digits otherwise.
+func bin(pan string) string {
+	if len(pan) >= 16 {
+		return pan[:8]
+	}
+
+	return pan[:6]
+}
+
+// setPanMetadata fills the card fields that can be kept out of Vault.
+func setPanMetadata(card *dtos.Card, pan string, network validation.Network) {
+	card.Brand = string(network)
+	card.Bin = bin(pan)
+	card.PanLength = len(pan)
+	card.LastDigits = pan[len(pan)-4:]
+	card.MaskedPan = dtos.MaskPan(pan, len(pan), card.LastDigits)
+}
+
+// validateExpiry accepts an empty expiry, otherwise the card must not be expired.
+// A card is valid until the last day of its expiry month.
+func validateExpiry(month, year int, now time.Time) error {
+	if month == 0 && year == 0 {
+		return nil
+	}
+
+	if month < 1 || month > 12 || year < 1000 || year > 9999 {
+		return ErrInvalidExpiry
+	}
+
+	if year < now.Year() || (year == now.Year() && month < int(now.Month())) {
+		return ErrInvalidExpiry
+	}
+
+	return nil
+}
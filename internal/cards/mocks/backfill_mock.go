@@ -0,0 +1,85 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/cards/backfill.go
+//
+// Generated by this command:
+//
+//	mockgen -source=internal/cards/backfill.go -destination=internal/cards/mocks/backfill_mock.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	uuid "github.com/google/uuid"
+	dtos "github.com/juaguz/yuno/internal/cards/dtos"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockLegacyDigitsRepository is a mock of LegacyDigitsRepository interface.
+type MockLegacyDigitsRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockLegacyDigitsRepositoryMockRecorder
+}
+
+// MockLegacyDigitsRepositoryMockRecorder is the mock recorder for MockLegacyDigitsRepository.
+type MockLegacyDigitsRepositoryMockRecorder struct {
+	mock *MockLegacyDigitsRepository
+}
+
+// NewMockLegacyDigitsRepository creates a new mock instance.
+func NewMockLegacyDigitsRepository(ctrl *gomock.Controller) *MockLegacyDigitsRepository {
+	mock := &MockLegacyDigitsRepository{ctrl: ctrl}
+	mock.recorder = &MockLegacyDigitsRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLegacyDigitsRepository) EXPECT() *MockLegacyDigitsRepositoryMockRecorder {
+	return m.recorder
+}
+
+// ListLegacyDigits mocks base method.
+func (m *MockLegacyDigitsRepository) ListLegacyDigits(ctx context.Context, after uuid.UUID, limit int) ([]*dtos.Card, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListLegacyDigits", ctx, after, limit)
+	ret0, _ := ret[0].([]*dtos.Card)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListLegacyDigits indicates an expected call of ListLegacyDigits.
+func (mr *MockLegacyDigitsRepositoryMockRecorder) ListLegacyDigits(ctx, after, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListLegacyDigits", reflect.TypeOf((*MockLegacyDigitsRepository)(nil).ListLegacyDigits), ctx, after, limit)
+}
+
+// MarkInvalidPan mocks base method.
+func (m *MockLegacyDigitsRepository) MarkInvalidPan(ctx context.Context, id uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkInvalidPan", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkInvalidPan indicates an expected call of MarkInvalidPan.
+func (mr *MockLegacyDigitsRepositoryMockRecorder) MarkInvalidPan(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkInvalidPan", reflect.TypeOf((*MockLegacyDigitsRepository)(nil).MarkInvalidPan), ctx, id)
+}
+
+// UpdatePanMetadata mocks base method.
+func (m *MockLegacyDigitsRepository) UpdatePanMetadata(ctx context.Context, card *dtos.Card) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePanMetadata", ctx, card)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdatePanMetadata indicates an expected call of UpdatePanMetadata.
+func (mr *MockLegacyDigitsRepositoryMockRecorder) UpdatePanMetadata(ctx, card any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePanMetadata", reflect.TypeOf((*MockLegacyDigitsRepository)(nil).UpdatePanMetadata), ctx, card)
+}
@@ -0,0 +1,161 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/cards/batchjobs.go
+//
+// Generated by this command:
+//
+//	mockgen -source=internal/cards/batchjobs.go -destination=internal/cards/mocks/batch_job_repository_mock.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	uuid "github.com/google/uuid"
+	dtos "github.com/juaguz/yuno/internal/cards/dtos"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockBatchJobRepository is a mock of BatchJobRepository interface.
+type MockBatchJobRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockBatchJobRepositoryMockRecorder
+}
+
+// MockBatchJobRepositoryMockRecorder is the mock recorder for MockBatchJobRepository.
+type MockBatchJobRepositoryMockRecorder struct {
+	mock *MockBatchJobRepository
+}
+
+// NewMockBatchJobRepository creates a new mock instance.
+func NewMockBatchJobRepository(ctrl *gomock.Controller) *MockBatchJobRepository {
+	mock := &MockBatchJobRepository{ctrl: ctrl}
+	mock.recorder = &MockBatchJobRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBatchJobRepository) EXPECT() *MockBatchJobRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Claim mocks base method.
+func (m *MockBatchJobRepository) Claim(ctx context.Context, now, lockedUntil time.Time) (*dtos.BatchJob, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Claim", ctx, now, lockedUntil)
+	ret0, _ := ret[0].(*dtos.BatchJob)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Claim indicates an expected call of Claim.
+func (mr *MockBatchJobRepositoryMockRecorder) Claim(ctx, now, lockedUntil any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Claim", reflect.TypeOf((*MockBatchJobRepository)(nil).Claim), ctx, now, lockedUntil)
+}
+
+// CountActiveByUser mocks base method.
+func (m *MockBatchJobRepository) CountActiveByUser(ctx context.Context, userID uuid.UUID) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountActiveByUser", ctx, userID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountActiveByUser indicates an expected call of CountActiveByUser.
+func (mr *MockBatchJobRepositoryMockRecorder) CountActiveByUser(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountActiveByUser", reflect.TypeOf((*MockBatchJobRepository)(nil).CountActiveByUser), ctx, userID)
+}
+
+// CountQueuedItems mocks base method.
+func (m *MockBatchJobRepository) CountQueuedItems(ctx context.Context) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountQueuedItems", ctx)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountQueuedItems indicates an expected call of CountQueuedItems.
+func (mr *MockBatchJobRepositoryMockRecorder) CountQueuedItems(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountQueuedItems", reflect.TypeOf((*MockBatchJobRepository)(nil).CountQueuedItems), ctx)
+}
+
+// Create mocks base method.
+func (m *MockBatchJobRepository) Create(ctx context.Context, job *dtos.BatchJob, items []*dtos.BatchJobItem) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, job, items)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockBatchJobRepositoryMockRecorder) Create(ctx, job, items any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockBatchJobRepository)(nil).Create), ctx, job, items)
+}
+
+// Get mocks base method.
+func (m *MockBatchJobRepository) Get(ctx context.Context, id uuid.UUID) (*dtos.BatchJob, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, id)
+	ret0, _ := ret[0].(*dtos.BatchJob)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockBatchJobRepositoryMockRecorder) Get(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockBatchJobRepository)(nil).Get), ctx, id)
+}
+
+// ListItems mocks base method.
+func (m *MockBatchJobRepository) ListItems(ctx context.Context, jobID uuid.UUID) ([]*dtos.BatchJobItem, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListItems", ctx, jobID)
+	ret0, _ := ret[0].([]*dtos.BatchJobItem)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListItems indicates an expected call of ListItems.
+func (mr *MockBatchJobRepositoryMockRecorder) ListItems(ctx, jobID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListItems", reflect.TypeOf((*MockBatchJobRepository)(nil).ListItems), ctx, jobID)
+}
+
+// ListPendingItems mocks base method.
+func (m *MockBatchJobRepository) ListPendingItems(ctx context.Context, jobID uuid.UUID, limit int) ([]*dtos.BatchJobItem, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListPendingItems", ctx, jobID, limit)
+	ret0, _ := ret[0].([]*dtos.BatchJobItem)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPendingItems indicates an expected call of ListPendingItems.
+func (mr *MockBatchJobRepositoryMockRecorder) ListPendingItems(ctx, jobID, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPendingItems", reflect.TypeOf((*MockBatchJobRepository)(nil).ListPendingItems), ctx, jobID, limit)
+}
+
+// SaveProgress mocks base method.
+func (m *MockBatchJobRepository) SaveProgress(ctx context.Context, job *dtos.BatchJob, items []*dtos.BatchJobItem, lockedUntil time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveProgress", ctx, job, items, lockedUntil)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveProgress indicates an expected call of SaveProgress.
+func (mr *MockBatchJobRepositoryMockRecorder) SaveProgress(ctx, job, items, lockedUntil any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveProgress", reflect.TypeOf((*MockBatchJobRepository)(nil).SaveProgress), ctx, job, items, lockedUntil)
+}
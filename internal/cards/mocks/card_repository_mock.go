@@ -84,6 +84,80 @@ func (mr *MockCardRepositoryMockRecorder) Get(ctx, id any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockCardRepository)(nil).Get), ctx, id)
 }
 
+// GetByFingerprint mocks base method.
+func (m *MockCardRepository) GetByFingerprint(ctx context.Context, userID uuid.UUID, fingerprint string) (*dtos.Card, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByFingerprint", ctx, userID, fingerprint)
+	ret0, _ := ret[0].(*dtos.Card)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByFingerprint indicates an expected call of GetByFingerprint.
+func (mr *MockCardRepositoryMockRecorder) GetByFingerprint(ctx, userID, fingerprint any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByFingerprint", reflect.TypeOf((*MockCardRepository)(nil).GetByFingerprint), ctx, userID, fingerprint)
+}
+
+// GetForUpdate mocks base method.
+func (m *MockCardRepository) GetForUpdate(ctx context.Context, id uuid.UUID) (*dtos.Card, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetForUpdate", ctx, id)
+	ret0, _ := ret[0].(*dtos.Card)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetForUpdate indicates an expected call of GetForUpdate.
+func (mr *MockCardRepositoryMockRecorder) GetForUpdate(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetForUpdate", reflect.TypeOf((*MockCardRepository)(nil).GetForUpdate), ctx, id)
+}
+
+// List mocks base method.
+func (m *MockCardRepository) List(ctx context.Context, filter *dtos.CardFilter) ([]*dtos.Card, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx, filter)
+	ret0, _ := ret[0].([]*dtos.Card)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockCardRepositoryMockRecorder) List(ctx, filter any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockCardRepository)(nil).List), ctx, filter)
+}
+
+// ListByUser mocks base method.
+func (m *MockCardRepository) ListByUser(ctx context.Context, userID, after uuid.UUID, limit int) ([]*dtos.Card, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListByUser", ctx, userID, after, limit)
+	ret0, _ := ret[0].([]*dtos.Card)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListByUser indicates an expected call of ListByUser.
+func (mr *MockCardRepositoryMockRecorder) ListByUser(ctx, userID, after, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListByUser", reflect.TypeOf((*MockCardRepository)(nil).ListByUser), ctx, userID, after, limit)
+}
+
+// UpdateKeyVersion mocks base method.
+func (m *MockCardRepository) UpdateKeyVersion(ctx context.Context, id uuid.UUID, version int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateKeyVersion", ctx, id, version)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateKeyVersion indicates an expected call of UpdateKeyVersion.
+func (mr *MockCardRepositoryMockRecorder) UpdateKeyVersion(ctx, id, version any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateKeyVersion", reflect.TypeOf((*MockCardRepository)(nil).UpdateKeyVersion), ctx, id, version)
+}
+
 // UpdateOne mocks base method.
 func (m *MockCardRepository) UpdateOne(ctx context.Context, card *dtos.Card) error {
 	m.ctrl.T.Helper()
@@ -136,6 +210,21 @@ func (mr *MockKmsRepositoryMockRecorder) Decrypt(ctx, data, key any) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Decrypt", reflect.TypeOf((*MockKmsRepository)(nil).Decrypt), ctx, data, key)
 }
 
+// HMAC mocks base method.
+func (m *MockKmsRepository) HMAC(ctx context.Context, data, key string, version int) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HMAC", ctx, data, key, version)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HMAC indicates an expected call of HMAC.
+func (mr *MockKmsRepositoryMockRecorder) HMAC(ctx, data, key, version any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HMAC", reflect.TypeOf((*MockKmsRepository)(nil).HMAC), ctx, data, key, version)
+}
+
 // MockVaultRepository is a mock of VaultRepository interface.
 type MockVaultRepository struct {
 	ctrl     *gomock.Controller
@@ -186,3 +275,69 @@ func (mr *MockVaultRepositoryMockRecorder) Delete(ctx, key any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockVaultRepository)(nil).Delete), ctx, key)
 }
+
+// Get mocks base method.
+func (m *MockVaultRepository) Get(ctx context.Context, key string) (map[string]any, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, key)
+	ret0, _ := ret[0].(map[string]any)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockVaultRepositoryMockRecorder) Get(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockVaultRepository)(nil).Get), ctx, key)
+}
+
+// MockSecretStore is a mock of SecretStore interface.
+type MockSecretStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockSecretStoreMockRecorder
+}
+
+// MockSecretStoreMockRecorder is the mock recorder for MockSecretStore.
+type MockSecretStoreMockRecorder struct {
+	mock *MockSecretStore
+}
+
+// NewMockSecretStore creates a new mock instance.
+func NewMockSecretStore(ctrl *gomock.Controller) *MockSecretStore {
+	mock := &MockSecretStore{ctrl: ctrl}
+	mock.recorder = &MockSecretStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSecretStore) EXPECT() *MockSecretStoreMockRecorder {
+	return m.recorder
+}
+
+// Delete mocks base method.
+func (m *MockSecretStore) Delete(ctx context.Context, cardID uuid.UUID, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, cardID, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockSecretStoreMockRecorder) Delete(ctx, cardID, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockSecretStore)(nil).Delete), ctx, cardID, key)
+}
+
+// Put mocks base method.
+func (m *MockSecretStore) Put(ctx context.Context, cardID uuid.UUID, key string, data map[string]any) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Put", ctx, cardID, key, data)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Put indicates an expected call of Put.
+func (mr *MockSecretStoreMockRecorder) Put(ctx, cardID, key, data any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Put", reflect.TypeOf((*MockSecretStore)(nil).Put), ctx, cardID, key, data)
+}
@@ -0,0 +1,133 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/cards/reconcile.go
+//
+// Generated by this command:
+//
+//	mockgen -source=internal/cards/reconcile.go -destination=internal/cards/mocks/reconcile_mock.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	uuid "github.com/google/uuid"
+	dtos "github.com/juaguz/yuno/internal/cards/dtos"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockReconcileCardRepository is a mock of ReconcileCardRepository interface.
+type MockReconcileCardRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockReconcileCardRepositoryMockRecorder
+}
+
+// MockReconcileCardRepositoryMockRecorder is the mock recorder for MockReconcileCardRepository.
+type MockReconcileCardRepositoryMockRecorder struct {
+	mock *MockReconcileCardRepository
+}
+
+// NewMockReconcileCardRepository creates a new mock instance.
+func NewMockReconcileCardRepository(ctrl *gomock.Controller) *MockReconcileCardRepository {
+	mock := &MockReconcileCardRepository{ctrl: ctrl}
+	mock.recorder = &MockReconcileCardRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockReconcileCardRepository) EXPECT() *MockReconcileCardRepositoryMockRecorder {
+	return m.recorder
+}
+
+// ListAll mocks base method.
+func (m *MockReconcileCardRepository) ListAll(ctx context.Context, after uuid.UUID, limit int) ([]*dtos.Card, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAll", ctx, after, limit)
+	ret0, _ := ret[0].([]*dtos.Card)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAll indicates an expected call of ListAll.
+func (mr *MockReconcileCardRepositoryMockRecorder) ListAll(ctx, after, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAll", reflect.TypeOf((*MockReconcileCardRepository)(nil).ListAll), ctx, after, limit)
+}
+
+// MockSecretLister is a mock of SecretLister interface.
+type MockSecretLister struct {
+	ctrl     *gomock.Controller
+	recorder *MockSecretListerMockRecorder
+}
+
+// MockSecretListerMockRecorder is the mock recorder for MockSecretLister.
+type MockSecretListerMockRecorder struct {
+	mock *MockSecretLister
+}
+
+// NewMockSecretLister creates a new mock instance.
+func NewMockSecretLister(ctrl *gomock.Controller) *MockSecretLister {
+	mock := &MockSecretLister{ctrl: ctrl}
+	mock.recorder = &MockSecretListerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSecretLister) EXPECT() *MockSecretListerMockRecorder {
+	return m.recorder
+}
+
+// List mocks base method.
+func (m *MockSecretLister) List(ctx context.Context, key string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx, key)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockSecretListerMockRecorder) List(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockSecretLister)(nil).List), ctx, key)
+}
+
+// MockPendingOperationRepository is a mock of PendingOperationRepository interface.
+type MockPendingOperationRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockPendingOperationRepositoryMockRecorder
+}
+
+// MockPendingOperationRepositoryMockRecorder is the mock recorder for MockPendingOperationRepository.
+type MockPendingOperationRepositoryMockRecorder struct {
+	mock *MockPendingOperationRepository
+}
+
+// NewMockPendingOperationRepository creates a new mock instance.
+func NewMockPendingOperationRepository(ctrl *gomock.Controller) *MockPendingOperationRepository {
+	mock := &MockPendingOperationRepository{ctrl: ctrl}
+	mock.recorder = &MockPendingOperationRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPendingOperationRepository) EXPECT() *MockPendingOperationRepositoryMockRecorder {
+	return m.recorder
+}
+
+// HasPending mocks base method.
+func (m *MockPendingOperationRepository) HasPending(ctx context.Context, cardID uuid.UUID) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HasPending", ctx, cardID)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HasPending indicates an expected call of HasPending.
+func (mr *MockPendingOperationRepositoryMockRecorder) HasPending(ctx, cardID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasPending", reflect.TypeOf((*MockPendingOperationRepository)(nil).HasPending), ctx, cardID)
+}
@@ -0,0 +1,250 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/cards/rewrap.go
+//
+// Generated by this command:
+//
+//	mockgen -source=internal/cards/rewrap.go -destination=internal/cards/mocks/rewrap_mock.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	uuid "github.com/google/uuid"
+	dtos "github.com/juaguz/yuno/internal/cards/dtos"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockRewrapKmsRepository is a mock of RewrapKmsRepository interface.
+type MockRewrapKmsRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockRewrapKmsRepositoryMockRecorder
+}
+
+// MockRewrapKmsRepositoryMockRecorder is the mock recorder for MockRewrapKmsRepository.
+type MockRewrapKmsRepositoryMockRecorder struct {
+	mock *MockRewrapKmsRepository
+}
+
+// NewMockRewrapKmsRepository creates a new mock instance.
+func NewMockRewrapKmsRepository(ctrl *gomock.Controller) *MockRewrapKmsRepository {
+	mock := &MockRewrapKmsRepository{ctrl: ctrl}
+	mock.recorder = &MockRewrapKmsRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRewrapKmsRepository) EXPECT() *MockRewrapKmsRepositoryMockRecorder {
+	return m.recorder
+}
+
+// GetPublicKey mocks base method.
+func (m *MockRewrapKmsRepository) GetPublicKey(ctx context.Context, keyID string) (string, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPublicKey", ctx, keyID)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetPublicKey indicates an expected call of GetPublicKey.
+func (mr *MockRewrapKmsRepositoryMockRecorder) GetPublicKey(ctx, keyID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPublicKey", reflect.TypeOf((*MockRewrapKmsRepository)(nil).GetPublicKey), ctx, keyID)
+}
+
+// Rewrap mocks base method.
+func (m *MockRewrapKmsRepository) Rewrap(ctx context.Context, ciphertext, keyID string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Rewrap", ctx, ciphertext, keyID)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Rewrap indicates an expected call of Rewrap.
+func (mr *MockRewrapKmsRepositoryMockRecorder) Rewrap(ctx, ciphertext, keyID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rewrap", reflect.TypeOf((*MockRewrapKmsRepository)(nil).Rewrap), ctx, ciphertext, keyID)
+}
+
+// MockRewrapVaultRepository is a mock of RewrapVaultRepository interface.
+type MockRewrapVaultRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockRewrapVaultRepositoryMockRecorder
+}
+
+// MockRewrapVaultRepositoryMockRecorder is the mock recorder for MockRewrapVaultRepository.
+type MockRewrapVaultRepositoryMockRecorder struct {
+	mock *MockRewrapVaultRepository
+}
+
+// NewMockRewrapVaultRepository creates a new mock instance.
+func NewMockRewrapVaultRepository(ctrl *gomock.Controller) *MockRewrapVaultRepository {
+	mock := &MockRewrapVaultRepository{ctrl: ctrl}
+	mock.recorder = &MockRewrapVaultRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRewrapVaultRepository) EXPECT() *MockRewrapVaultRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockRewrapVaultRepository) Create(ctx context.Context, data map[string]any, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, data, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockRewrapVaultRepositoryMockRecorder) Create(ctx, data, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockRewrapVaultRepository)(nil).Create), ctx, data, key)
+}
+
+// Delete mocks base method.
+func (m *MockRewrapVaultRepository) Delete(ctx context.Context, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockRewrapVaultRepositoryMockRecorder) Delete(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockRewrapVaultRepository)(nil).Delete), ctx, key)
+}
+
+// DestroyPreviousVersions mocks base method.
+func (m *MockRewrapVaultRepository) DestroyPreviousVersions(ctx context.Context, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DestroyPreviousVersions", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DestroyPreviousVersions indicates an expected call of DestroyPreviousVersions.
+func (mr *MockRewrapVaultRepositoryMockRecorder) DestroyPreviousVersions(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DestroyPreviousVersions", reflect.TypeOf((*MockRewrapVaultRepository)(nil).DestroyPreviousVersions), ctx, key)
+}
+
+// Get mocks base method.
+func (m *MockRewrapVaultRepository) Get(ctx context.Context, key string) (map[string]any, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, key)
+	ret0, _ := ret[0].(map[string]any)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockRewrapVaultRepositoryMockRecorder) Get(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockRewrapVaultRepository)(nil).Get), ctx, key)
+}
+
+// MockRewrapJobRepository is a mock of RewrapJobRepository interface.
+type MockRewrapJobRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockRewrapJobRepositoryMockRecorder
+}
+
+// MockRewrapJobRepositoryMockRecorder is the mock recorder for MockRewrapJobRepository.
+type MockRewrapJobRepositoryMockRecorder struct {
+	mock *MockRewrapJobRepository
+}
+
+// NewMockRewrapJobRepository creates a new mock instance.
+func NewMockRewrapJobRepository(ctrl *gomock.Controller) *MockRewrapJobRepository {
+	mock := &MockRewrapJobRepository{ctrl: ctrl}
+	mock.recorder = &MockRewrapJobRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRewrapJobRepository) EXPECT() *MockRewrapJobRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Claim mocks base method.
+func (m *MockRewrapJobRepository) Claim(ctx context.Context, now, lockedUntil time.Time) (*dtos.RewrapJob, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Claim", ctx, now, lockedUntil)
+	ret0, _ := ret[0].(*dtos.RewrapJob)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Claim indicates an expected call of Claim.
+func (mr *MockRewrapJobRepositoryMockRecorder) Claim(ctx, now, lockedUntil any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Claim", reflect.TypeOf((*MockRewrapJobRepository)(nil).Claim), ctx, now, lockedUntil)
+}
+
+// Create mocks base method.
+func (m *MockRewrapJobRepository) Create(ctx context.Context, job *dtos.RewrapJob) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, job)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockRewrapJobRepositoryMockRecorder) Create(ctx, job any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockRewrapJobRepository)(nil).Create), ctx, job)
+}
+
+// FindActiveByUser mocks base method.
+func (m *MockRewrapJobRepository) FindActiveByUser(ctx context.Context, userID uuid.UUID) (*dtos.RewrapJob, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindActiveByUser", ctx, userID)
+	ret0, _ := ret[0].(*dtos.RewrapJob)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindActiveByUser indicates an expected call of FindActiveByUser.
+func (mr *MockRewrapJobRepositoryMockRecorder) FindActiveByUser(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindActiveByUser", reflect.TypeOf((*MockRewrapJobRepository)(nil).FindActiveByUser), ctx, userID)
+}
+
+// Get mocks base method.
+func (m *MockRewrapJobRepository) Get(ctx context.Context, id uuid.UUID) (*dtos.RewrapJob, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, id)
+	ret0, _ := ret[0].(*dtos.RewrapJob)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockRewrapJobRepositoryMockRecorder) Get(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockRewrapJobRepository)(nil).Get), ctx, id)
+}
+
+// SaveProgress mocks base method.
+func (m *MockRewrapJobRepository) SaveProgress(ctx context.Context, job *dtos.RewrapJob, lockedUntil time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveProgress", ctx, job, lockedUntil)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveProgress indicates an expected call of SaveProgress.
+func (mr *MockRewrapJobRepositoryMockRecorder) SaveProgress(ctx, job, lockedUntil any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveProgress", reflect.TypeOf((*MockRewrapJobRepository)(nil).SaveProgress), ctx, job, lockedUntil)
+}
@@ -0,0 +1,101 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/cards/secretoutbox.go
+//
+// Generated by this command:
+//
+//	mockgen -source=internal/cards/secretoutbox.go -destination=internal/cards/mocks/secret_operation_repository_mock.go -package=mocks
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	uuid "github.com/google/uuid"
+	dtos "github.com/juaguz/yuno/internal/cards/dtos"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockSecretOperationRepository is a mock of SecretOperationRepository interface.
+type MockSecretOperationRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockSecretOperationRepositoryMockRecorder
+}
+
+// MockSecretOperationRepositoryMockRecorder is the mock recorder for MockSecretOperationRepository.
+type MockSecretOperationRepositoryMockRecorder struct {
+	mock *MockSecretOperationRepository
+}
+
+// NewMockSecretOperationRepository creates a new mock instance.
+func NewMockSecretOperationRepository(ctrl *gomock.Controller) *MockSecretOperationRepository {
+	mock := &MockSecretOperationRepository{ctrl: ctrl}
+	mock.recorder = &MockSecretOperationRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSecretOperationRepository) EXPECT() *MockSecretOperationRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Claim mocks base method.
+func (m *MockSecretOperationRepository) Claim(ctx context.Context, now, lockedUntil time.Time, limit int) ([]*dtos.SecretOperation, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Claim", ctx, now, lockedUntil, limit)
+	ret0, _ := ret[0].([]*dtos.SecretOperation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Claim indicates an expected call of Claim.
+func (mr *MockSecretOperationRepositoryMockRecorder) Claim(ctx, now, lockedUntil, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Claim", reflect.TypeOf((*MockSecretOperationRepository)(nil).Claim), ctx, now, lockedUntil, limit)
+}
+
+// ClaimByID mocks base method.
+func (m *MockSecretOperationRepository) ClaimByID(ctx context.Context, id uuid.UUID, now, lockedUntil time.Time) (*dtos.SecretOperation, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimByID", ctx, id, now, lockedUntil)
+	ret0, _ := ret[0].(*dtos.SecretOperation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimByID indicates an expected call of ClaimByID.
+func (mr *MockSecretOperationRepositoryMockRecorder) ClaimByID(ctx, id, now, lockedUntil any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimByID", reflect.TypeOf((*MockSecretOperationRepository)(nil).ClaimByID), ctx, id, now, lockedUntil)
+}
+
+// Create mocks base method.
+func (m *MockSecretOperationRepository) Create(ctx context.Context, op *dtos.SecretOperation) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, op)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockSecretOperationRepositoryMockRecorder) Create(ctx, op any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockSecretOperationRepository)(nil).Create), ctx, op)
+}
+
+// Save mocks base method.
+func (m *MockSecretOperationRepository) Save(ctx context.Context, op *dtos.SecretOperation) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Save", ctx, op)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Save indicates an expected call of Save.
+func (mr *MockSecretOperationRepositoryMockRecorder) Save(ctx, op any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockSecretOperationRepository)(nil).Save), ctx, op)
+}
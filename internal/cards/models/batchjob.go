@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/kit/database"
+)
+
+// BatchJob is an entry of the batch queue. LockedUntil is the lease of the
+// instance processing it, once expired another one picks the job up.
+type BatchJob struct {
+	database.Model
+	UserId      uuid.UUID
+	Operation   string
+	Status      string
+	Atomic      bool
+	Total       int
+	Succeeded   int
+	Failed      int
+	LockedUntil *time.Time
+}
+
+// BatchJobItem is a card of a job. The error columns are only set when it failed,
+// Pan holds the ciphertext of a create until it is processed.
+type BatchJobItem struct {
+	ID           uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4()"`
+	JobId        uuid.UUID `gorm:"type:uuid"`
+	Position     int
+	CardId       uuid.UUID `gorm:"type:uuid"`
+	CardHolder   string
+	Pan          string
+	KeyVersion   int
+	ExpiryMonth  int
+	ExpiryYear   int
+	Status       string
+	ErrorCode    string
+	ErrorMessage string
+	Retryable    bool
+	UpdatedAt    time.Time
+}
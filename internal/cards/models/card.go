@@ -5,9 +5,27 @@ import (
 	"github.com/juaguz/yuno/kit/database"
 )
 
+// LastDigitsVersion is the MaskingVersion of the rows holding the last four
+// digits of the PAN. Older rows hold the first four digits instead.
+const LastDigitsVersion = 1
+
+// InvalidPanVersion is the MaskingVersion of the legacy rows whose PAN fails the
+// validation, the backfill can't migrate them and leaves them out of its runs.
+const InvalidPanVersion = -1
+
 type Card struct {
 	database.Model
-	CardHolder string    `json:"card_holder"`
-	UserId     uuid.UUID `json:"user_id"`
-	LastDigits string    `json:"last_digits"`
+	CardHolder     string    `json:"card_holder"`
+	UserId         uuid.UUID `json:"user_id"`
+	LastDigits     string    `json:"last_digits"`
+	KeyVersion     int       `json:"key_version"`
+	Brand          string    `json:"brand"`
+	Bin            string    `json:"bin"`
+	PanLength      int       `json:"pan_length"`
+	ExpiryMonth    int       `json:"expiry_month"`
+	ExpiryYear     int       `json:"expiry_year"`
+	MaskingVersion int       `json:"masking_version"`
+	Fingerprint    string    `json:"fingerprint"`
+	// Deduplicated cards have a unique fingerprint among the live cards of the user
+	Deduplicated bool `json:"deduplicated"`
 }
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/kit/database"
+)
+
+// RewrapJob tracks the progress of rewrapping the PAN secrets of a user,
+// LastCardID is the cursor used to resume after a crash. LockedUntil is the
+// lease of the instance processing it, once expired another one picks it up.
+type RewrapJob struct {
+	database.Model
+	UserId        uuid.UUID
+	Status        string
+	TargetVersion int
+	LastCardID    uuid.UUID `gorm:"type:uuid"`
+	Processed     int
+	Failed        int
+	LockedUntil   *time.Time
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/kit/database"
+)
+
+// SecretOperation is an entry of the secret outbox. Data holds the secret to write
+// and is cleared once the operation is applied. Sequence orders the operations of
+// a card, LockedUntil is the lease of the instance applying it.
+type SecretOperation struct {
+	database.Model
+	CardId        uuid.UUID `gorm:"type:uuid"`
+	Type          string
+	Status        string
+	SecretKey     string
+	Data          map[string]interface{} `gorm:"serializer:json"`
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	Sequence      int64 `gorm:"->"`
+	LockedUntil   *time.Time
+}
@@ -0,0 +1,288 @@
+package cards
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/internal/cards/validation"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"github.com/juaguz/yuno/kit/kms"
+)
+
+const (
+	reconcilePageSize = 100
+	secretsRoot       = "secrets/cards"
+
+	// DefaultReconcileMinAge skips the cards created too recently to have their secret yet.
+	DefaultReconcileMinAge = 10 * time.Minute
+)
+
+type ReconcileCardRepository interface {
+	ListAll(ctx context.Context, after uuid.UUID, limit int) ([]*dtos.Card, error)
+}
+
+type SecretLister interface {
+	List(ctx context.Context, key string) ([]string, error)
+}
+
+type PendingOperationRepository interface {
+	HasPending(ctx context.Context, cardID uuid.UUID) (bool, error)
+}
+
+// Reconciler finds the drift between the cards table and the card secrets in
+// Vault: secrets without a card row and card rows without a secret. Cards with
+// secret operations still pending in the outbox are in flight and skipped.
+type Reconciler struct {
+	CardService         *CardService
+	CardRepository      ReconcileCardRepository
+	SecretLister        SecretLister
+	OperationRepository PendingOperationRepository
+	MinAge              time.Duration
+}
+
+func NewReconciler(cardService *CardService, cardRepository ReconcileCardRepository, secretLister SecretLister, operationRepository PendingOperationRepository, minAge time.Duration) *Reconciler {
+	return &Reconciler{
+		CardService:         cardService,
+		CardRepository:      cardRepository,
+		SecretLister:        secretLister,
+		OperationRepository: operationRepository,
+		MinAge:              minAge,
+	}
+}
+
+// Reconcile checks every card and secret. Depending on mode the drift is only
+// reported, orphaned secrets get their card row rebuilt from the PAN, or both
+// orphaned secrets and secretless rows are deleted. With dryRun nothing is changed.
+func (r *Reconciler) Reconcile(ctx context.Context, mode dtos.ReconcileMode, dryRun bool) (*dtos.ReconcileResult, error) {
+	switch mode {
+	case dtos.ReconcileReportOnly, dtos.ReconcileRepair, dtos.ReconcilePurge:
+	default:
+		return nil, fmt.Errorf("unknown reconcile mode %q", mode)
+	}
+
+	result := &dtos.ReconcileResult{}
+
+	if err := r.checkCards(ctx, mode, dryRun, result); err != nil {
+		return result, err
+	}
+	if err := r.checkSecrets(ctx, mode, dryRun, result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// Run reports the drift every interval until ctx is cancelled, it never changes anything.
+func (r *Reconciler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		result, err := r.Reconcile(ctx, dtos.ReconcileReportOnly, true)
+		if err != nil {
+			log.Printf("reconcile: %s", err)
+		} else if len(result.OrphanSecrets) > 0 || len(result.SecretlessCards) > 0 {
+			log.Printf("reconcile: %d orphan secrets, %d secretless cards", len(result.OrphanSecrets), len(result.SecretlessCards))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkCards looks for card rows whose secret is missing.
+func (r *Reconciler) checkCards(ctx context.Context, mode dtos.ReconcileMode, dryRun bool, result *dtos.ReconcileResult) error {
+	createdBefore := time.Now().Add(-r.MinAge)
+	after := uuid.Nil
+
+	for {
+		cards, err := r.CardRepository.ListAll(ctx, after, reconcilePageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, card := range cards {
+			after = card.ID
+			if card.CreatedAt.After(createdBefore) {
+				continue
+			}
+			result.CheckedCards++
+
+			_, err := r.CardService.VaultRepository.Get(ctx, buildKey(card.UserId, card.ID))
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, senital.ErrNotFound) {
+				return err
+			}
+
+			pending, err := r.OperationRepository.HasPending(ctx, card.ID)
+			if err != nil {
+				return err
+			}
+			if pending {
+				continue
+			}
+
+			log.Printf("reconcile: card %s has no secret", card.ID)
+			result.SecretlessCards = append(result.SecretlessCards, card.ID)
+
+			// there is nothing to rebuild the secret from, the row can only be purged
+			if mode != dtos.ReconcilePurge || dryRun {
+				continue
+			}
+			if err := r.CardService.CardRepository.Delete(ctx, card.ID); err != nil {
+				log.Printf("reconcile: deleting card %s: %s", card.ID, err)
+				result.Failed++
+				continue
+			}
+			result.Purged++
+		}
+
+		if len(cards) < reconcilePageSize {
+			return nil
+		}
+	}
+}
+
+// checkSecrets looks for secrets whose card row is missing.
+func (r *Reconciler) checkSecrets(ctx context.Context, mode dtos.ReconcileMode, dryRun bool, result *dtos.ReconcileResult) error {
+	users, err := r.SecretLister.List(ctx, secretsRoot)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		userID, err := uuid.Parse(strings.TrimSuffix(user, "/"))
+		if err != nil {
+			log.Printf("reconcile: unexpected secret folder %s", user)
+			continue
+		}
+
+		names, err := r.SecretLister.List(ctx, secretsRoot+"/"+userID.String())
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			cardID, err := uuid.Parse(name)
+			if err != nil {
+				log.Printf("reconcile: unexpected secret %s/%s", userID, name)
+				continue
+			}
+			result.CheckedSecrets++
+
+			if err := r.checkSecret(ctx, userID, cardID, mode, dryRun, result); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *Reconciler) checkSecret(ctx context.Context, userID, cardID uuid.UUID, mode dtos.ReconcileMode, dryRun bool, result *dtos.ReconcileResult) error {
+	card, err := r.CardService.CardRepository.Get(ctx, cardID)
+	if err != nil && !errors.Is(err, senital.ErrNotFound) {
+		return err
+	}
+	if card != nil && card.UserId == userID {
+		return nil
+	}
+
+	key := buildKey(userID, cardID)
+	secret, err := r.CardService.VaultRepository.Get(ctx, key)
+	if errors.Is(err, senital.ErrNotFound) {
+		// every version was deleted, only the metadata is left
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	pending, err := r.OperationRepository.HasPending(ctx, cardID)
+	if err != nil {
+		return err
+	}
+	if pending {
+		return nil
+	}
+
+	log.Printf("reconcile: secret %s has no card", key)
+	result.OrphanSecrets = append(result.OrphanSecrets, key)
+
+	if dryRun {
+		return nil
+	}
+
+	switch mode {
+	case dtos.ReconcileRepair:
+		if card != nil {
+			// the ID is taken by a card of another user, the row can't be rebuilt
+			result.Failed++
+			return nil
+		}
+		if err := r.restoreCard(ctx, userID, cardID, key, secret); err != nil {
+			log.Printf("reconcile: restoring card %s: %s", cardID, err)
+			result.Failed++
+			return nil
+		}
+		result.Repaired++
+	case dtos.ReconcilePurge:
+		if err := r.CardService.VaultRepository.Delete(ctx, key); err != nil {
+			log.Printf("reconcile: deleting secret %s: %s", key, err)
+			result.Failed++
+			return nil
+		}
+		result.Purged++
+	}
+
+	return nil
+}
+
+// restoreCard rebuilds the card row of a secret from its PAN. The card holder
+// isn't part of the secret and is left empty.
+func (r *Reconciler) restoreCard(ctx context.Context, userID, cardID uuid.UUID, key string, secret map[string]interface{}) error {
+	ciphertext, err := secretCiphertext(key, secret)
+	if err != nil {
+		return err
+	}
+
+	version, err := kms.CiphertextVersion(ciphertext)
+	if err != nil {
+		return err
+	}
+
+	card := &dtos.Card{
+		ID:         cardID,
+		UserId:     userID,
+		KeyVersion: version,
+	}
+
+	pan, err := r.CardService.decryptPan(ctx, card)
+	if err != nil {
+		return err
+	}
+
+	network, err := validation.Validate(pan)
+	if err != nil {
+		return err
+	}
+	setPanMetadata(card, pan, network)
+
+	card.Fingerprint, err = r.CardService.fingerprint(ctx, pan)
+	if err != nil {
+		return err
+	}
+
+	return r.CardService.CardRepository.Create(ctx, card)
+}
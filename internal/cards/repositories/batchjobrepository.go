@@ -0,0 +1,241 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/internal/cards/models"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"gorm.io/gorm"
+)
+
+const batchItemsInsertSize = 500
+
+// claimBatchJobSQL leases the oldest unfinished job nobody holds. SKIP LOCKED
+// lets several instances claim concurrently without waiting on each other.
+const claimBatchJobSQL = `
+UPDATE batch_jobs SET status = ?, locked_until = ?, updated_at = ?
+WHERE id = (
+	SELECT id FROM batch_jobs
+	WHERE deleted_at IS NULL AND status IN ? AND (locked_until IS NULL OR locked_until < ?)
+	ORDER BY created_at
+	LIMIT 1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+type BatchJobRepository struct {
+	DB *gorm.DB
+}
+
+func NewBatchJobRepository(DB *gorm.DB) *BatchJobRepository {
+	return &BatchJobRepository{DB: DB}
+}
+
+// Create stores the job along with its items, in a single transaction.
+func (r BatchJobRepository) Create(ctx context.Context, job *dtos.BatchJob, items []*dtos.BatchJobItem) error {
+	m := &models.BatchJob{
+		UserId:    job.UserID,
+		Operation: string(job.Operation),
+		Status:    string(job.Status),
+		Atomic:    job.Atomic,
+		Total:     len(items),
+	}
+
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(m).Error; err != nil {
+			return err
+		}
+
+		ms := make([]models.BatchJobItem, 0, len(items))
+		for _, item := range items {
+			ms = append(ms, models.BatchJobItem{
+				JobId:       m.ID,
+				Position:    item.Position,
+				CardId:      item.CardID,
+				CardHolder:  item.CardHolder,
+				Pan:         item.Pan,
+				KeyVersion:  item.KeyVersion,
+				ExpiryMonth: item.ExpiryMonth,
+				ExpiryYear:  item.ExpiryYear,
+				Status:      string(item.Status),
+				UpdatedAt:   m.CreatedAt,
+			})
+		}
+
+		return tx.CreateInBatches(ms, batchItemsInsertSize).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	*job = *toBatchJobDto(m)
+	return nil
+}
+
+func (r BatchJobRepository) Get(ctx context.Context, id uuid.UUID) (*dtos.BatchJob, error) {
+	var m models.BatchJob
+	err := r.DB.WithContext(ctx).First(&m, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, senital.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return toBatchJobDto(&m), nil
+}
+
+// ListItems returns the items of the job in request order.
+func (r BatchJobRepository) ListItems(ctx context.Context, jobID uuid.UUID) ([]*dtos.BatchJobItem, error) {
+	var ms []models.BatchJobItem
+	err := r.DB.WithContext(ctx).Where("job_id = ?", jobID).Order("position").Find(&ms).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return toBatchJobItemDtos(ms), nil
+}
+
+// ListPendingItems returns up to limit items of the job that weren't processed yet, in request order.
+func (r BatchJobRepository) ListPendingItems(ctx context.Context, jobID uuid.UUID, limit int) ([]*dtos.BatchJobItem, error) {
+	var ms []models.BatchJobItem
+	err := r.DB.WithContext(ctx).
+		Where("job_id = ? AND status = ?", jobID, string(dtos.Pending)).
+		Order("position").
+		Limit(limit).
+		Find(&ms).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return toBatchJobItemDtos(ms), nil
+}
+
+// Claim marks the oldest unfinished job as running and leases it until
+// lockedUntil. senital.ErrNotFound when there is no job to claim.
+func (r BatchJobRepository) Claim(ctx context.Context, now time.Time, lockedUntil time.Time) (*dtos.BatchJob, error) {
+	var ms []models.BatchJob
+	err := r.DB.WithContext(ctx).
+		Raw(claimBatchJobSQL, string(dtos.BatchJobRunning), lockedUntil, now, activeBatchJobStatuses(), now).
+		Scan(&ms).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(ms) == 0 {
+		return nil, senital.ErrNotFound
+	}
+
+	return toBatchJobDto(&ms[0]), nil
+}
+
+// CountActiveByUser returns how many jobs of the user are pending or running.
+func (r BatchJobRepository) CountActiveByUser(ctx context.Context, userID uuid.UUID) (int, error) {
+	var count int64
+	err := r.DB.WithContext(ctx).Model(&models.BatchJob{}).
+		Where("user_id = ? AND status IN ?", userID, activeBatchJobStatuses()).
+		Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return int(count), nil
+}
+
+// CountQueuedItems returns how many items of the pending and running jobs are
+// still to be processed, from the counters of the jobs.
+func (r BatchJobRepository) CountQueuedItems(ctx context.Context) (int, error) {
+	var count int64
+	err := r.DB.WithContext(ctx).Model(&models.BatchJob{}).
+		Select("COALESCE(SUM(total - succeeded - failed), 0)").
+		Where("status IN ?", activeBatchJobStatuses()).
+		Scan(&count).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return int(count), nil
+}
+
+// SaveProgress persists the outcome of the items along with the status and
+// counters of the job, and extends its lease until lockedUntil. The PAN
+// ciphertext of the items is dropped, they are never processed again.
+func (r BatchJobRepository) SaveProgress(ctx context.Context, job *dtos.BatchJob, items []*dtos.BatchJobItem, lockedUntil time.Time) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, item := range items {
+			itemError := item.Error
+			if itemError == nil {
+				itemError = &dtos.BatchError{}
+			}
+
+			err := tx.Model(&models.BatchJobItem{}).Where("id = ?", item.ID).Updates(map[string]interface{}{
+				"status":        string(item.Status),
+				"card_id":       item.CardID,
+				"pan":           "",
+				"error_code":    string(itemError.Code),
+				"error_message": itemError.Message,
+				"retryable":     itemError.Retryable,
+				"updated_at":    time.Now(),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&models.BatchJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+			"status":       string(job.Status),
+			"succeeded":    job.Succeeded,
+			"failed":       job.Failed,
+			"locked_until": lockedUntil,
+		}).Error
+	})
+}
+
+func activeBatchJobStatuses() []string {
+	return []string{string(dtos.BatchJobPending), string(dtos.BatchJobRunning)}
+}
+
+func toBatchJobDto(m *models.BatchJob) *dtos.BatchJob {
+	return &dtos.BatchJob{
+		ID:        m.ID,
+		UserID:    m.UserId,
+		Operation: dtos.BatchOperation(m.Operation),
+		Status:    dtos.BatchJobStatus(m.Status),
+		Atomic:    m.Atomic,
+		Total:     m.Total,
+		Succeeded: m.Succeeded,
+		Failed:    m.Failed,
+		CreatedAt: m.CreatedAt,
+		UpdatedAt: m.UpdatedAt,
+	}
+}
+
+func toBatchJobItemDtos(ms []models.BatchJobItem) []*dtos.BatchJobItem {
+	items := make([]*dtos.BatchJobItem, 0, len(ms))
+	for _, m := range ms {
+		item := &dtos.BatchJobItem{
+			ID:          m.ID,
+			Position:    m.Position,
+			CardID:      m.CardId,
+			CardHolder:  m.CardHolder,
+			Pan:         m.Pan,
+			KeyVersion:  m.KeyVersion,
+			ExpiryMonth: m.ExpiryMonth,
+			ExpiryYear:  m.ExpiryYear,
+			Status:      dtos.Status(m.Status),
+		}
+		if m.ErrorCode != "" {
+			item.Error = &dtos.BatchError{
+				Code:      dtos.BatchErrorCode(m.ErrorCode),
+				Message:   m.ErrorMessage,
+				Retryable: m.Retryable,
+			}
+		}
+		items = append(items, item)
+	}
+
+	return items
+}
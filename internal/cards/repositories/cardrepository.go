@@ -2,12 +2,24 @@ package repositories
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/juaguz/yuno/internal/cards/dtos"
 	"github.com/juaguz/yuno/internal/cards/models"
 	"github.com/juaguz/yuno/kit/database"
+	"github.com/juaguz/yuno/kit/errors/senital"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	uniqueViolation = "23505"
+	// uniqueFingerprintIndex holds the fingerprints of the deduplicated cards
+	uniqueFingerprintIndex = "idx_cards_user_fingerprint_unique"
 )
 
 type CardRepository struct {
@@ -21,26 +33,174 @@ func NewCardRepository(DB *gorm.DB) *CardRepository {
 func (c CardRepository) Create(ctx context.Context, card *dtos.Card) error {
 	db := database.GetTx(ctx, c.DB)
 	cc := &models.Card{
-		CardHolder: card.CardHolder,
-		UserId:     card.UserId,
-		LastDigits: card.Pan,
+		CardHolder:     card.CardHolder,
+		UserId:         card.UserId,
+		LastDigits:     card.LastDigits,
+		MaskingVersion: models.LastDigitsVersion,
+		KeyVersion:     card.KeyVersion,
+		Brand:          card.Brand,
+		Bin:            card.Bin,
+		PanLength:      card.PanLength,
+		ExpiryMonth:    card.ExpiryMonth,
+		ExpiryYear:     card.ExpiryYear,
+		Fingerprint:    card.Fingerprint,
+		Deduplicated:   card.Deduplicated,
+	}
+	// the service picks the ID because it is also part of the secret path
+	cc.ID = card.ID
+
+	err := db.Create(cc).Error
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == uniqueFingerprintIndex {
+		return fmt.Errorf("fingerprint of card %s: %w", card.ID, senital.ErrConflict)
 	}
 
-	return db.Create(cc).Error
+	return database.Unavailable(err)
 }
 
+// Get returns the card, reading through the transaction of ctx when there is one.
 func (c CardRepository) Get(ctx context.Context, id uuid.UUID) (*dtos.Card, error) {
+	db := database.GetTx(ctx, c.DB)
+
+	var cardModel models.Card
+	if err := db.First(&cardModel, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, senital.ErrNotFound
+		}
+		return nil, database.Unavailable(err)
+	}
+
+	return toDto(&cardModel), nil
+}
+
+// GetForUpdate returns the card and locks it until the transaction of ctx ends.
+func (c CardRepository) GetForUpdate(ctx context.Context, id uuid.UUID) (*dtos.Card, error) {
+	db := database.GetTx(ctx, c.DB)
+
 	var cardModel models.Card
-	if err := c.DB.First(&cardModel, "id = ?", id).Error; err != nil {
-		return nil, err
+	err := db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cardModel, "id = ?", id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, senital.ErrNotFound
+		}
+		return nil, database.Unavailable(err)
+	}
+
+	return toDto(&cardModel), nil
+}
+
+// GetByFingerprint returns the oldest card of the user with the given fingerprint,
+// nil when the user has none.
+func (c CardRepository) GetByFingerprint(ctx context.Context, userID uuid.UUID, fingerprint string) (*dtos.Card, error) {
+	db := database.GetTx(ctx, c.DB)
+
+	var cardModels []models.Card
+	err := db.
+		Where("user_id = ? AND fingerprint = ?", userID, fingerprint).
+		Order("created_at").
+		Limit(1).
+		Find(&cardModels).Error
+	if err != nil {
+		return nil, database.Unavailable(err)
+	}
+	if len(cardModels) == 0 {
+		return nil, nil
+	}
+
+	return toDto(&cardModels[0]), nil
+}
+
+// ListByUser returns up to limit cards of the user ordered by ID, starting after the given ID.
+func (c CardRepository) ListByUser(ctx context.Context, userID uuid.UUID, after uuid.UUID, limit int) ([]*dtos.Card, error) {
+	var cardModels []models.Card
+	err := c.DB.WithContext(ctx).
+		Where("user_id = ? AND id > ?", userID, after).
+		Order("id").
+		Limit(limit).
+		Find(&cardModels).Error
+	if err != nil {
+		return nil, database.Unavailable(err)
+	}
+
+	cards := make([]*dtos.Card, 0, len(cardModels))
+	for i := range cardModels {
+		cards = append(cards, toDto(&cardModels[i]))
+	}
+
+	return cards, nil
+}
+
+// List returns the cards matching the filter sorted by creation date, ties broken by ID.
+func (c CardRepository) List(ctx context.Context, filter *dtos.CardFilter) ([]*dtos.Card, error) {
+	query := c.DB.WithContext(ctx).Where("user_id = ?", filter.UserID)
+
+	if filter.LastDigits != "" {
+		query = query.Where("last_digits = ? AND masking_version >= ?", filter.LastDigits, models.LastDigitsVersion)
+	}
+	if filter.Brand != "" {
+		query = query.Where("brand = ?", filter.Brand)
+	}
+	if filter.CardHolder != "" {
+		query = query.Where("card_holder ILIKE ?", "%"+escapeLike(filter.CardHolder)+"%")
+	}
+
+	direction := "DESC"
+	comparison := "<"
+	if filter.Order == dtos.Ascending {
+		direction = "ASC"
+		comparison = ">"
 	}
 
-	return &dtos.Card{
-		ID:         cardModel.ID,
-		CardHolder: cardModel.CardHolder,
-		Pan:        cardModel.LastDigits,
-		UserId:     cardModel.UserId,
-	}, nil
+	if filter.AfterID != uuid.Nil {
+		query = query.Where("(created_at, id) "+comparison+" (?, ?)", filter.AfterCreatedAt, filter.AfterID)
+	}
+
+	var cardModels []models.Card
+	err := query.
+		Order("created_at " + direction).
+		Order("id " + direction).
+		Limit(filter.Limit).
+		Find(&cardModels).Error
+	if err != nil {
+		return nil, database.Unavailable(err)
+	}
+
+	cards := make([]*dtos.Card, 0, len(cardModels))
+	for i := range cardModels {
+		cards = append(cards, toDto(&cardModels[i]))
+	}
+
+	return cards, nil
+}
+
+func (c CardRepository) UpdateKeyVersion(ctx context.Context, id uuid.UUID, version int) error {
+	db := database.GetTx(ctx, c.DB)
+	err := db.Model(&models.Card{}).Where("id = ?", id).Update("key_version", version).Error
+	return database.Unavailable(err)
+}
+
+func toDto(cardModel *models.Card) *dtos.Card {
+	card := &dtos.Card{
+		ID:          cardModel.ID,
+		CardHolder:  cardModel.CardHolder,
+		UserId:      cardModel.UserId,
+		KeyVersion:  cardModel.KeyVersion,
+		Brand:       cardModel.Brand,
+		Bin:         cardModel.Bin,
+		PanLength:   cardModel.PanLength,
+		ExpiryMonth: cardModel.ExpiryMonth,
+		ExpiryYear:  cardModel.ExpiryYear,
+		Fingerprint: cardModel.Fingerprint,
+		CreatedAt:   cardModel.CreatedAt,
+	}
+
+	// rows not migrated yet hold the first digits, better show nothing than wrong digits
+	if cardModel.MaskingVersion >= models.LastDigitsVersion {
+		card.LastDigits = cardModel.LastDigits
+		card.MaskedPan = dtos.MaskPan(cardModel.Bin, cardModel.PanLength, cardModel.LastDigits)
+	}
+
+	return card
 }
 
 func (c CardRepository) UpdateOne(ctx context.Context, card *dtos.Card) error {
@@ -49,8 +209,13 @@ func (c CardRepository) UpdateOne(ctx context.Context, card *dtos.Card) error {
 		CardHolder: card.CardHolder,
 	}
 
-	if err := db.Model(&models.Card{}).Where("id = ?", card.ID).Updates(updateData).Error; err != nil {
-		return err
+	result := db.Model(&models.Card{}).Where("id = ?", card.ID).Updates(updateData)
+	if result.Error != nil {
+		return database.Unavailable(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		// deleted since it was read
+		return fmt.Errorf("updating card %s: %w", card.ID, senital.ErrNotFound)
 	}
 
 	return nil
@@ -59,8 +224,78 @@ func (c CardRepository) UpdateOne(ctx context.Context, card *dtos.Card) error {
 func (c CardRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	db := database.GetTx(ctx, c.DB)
 	if err := db.Delete(&models.Card{}, "id = ?", id).Error; err != nil {
-		return err
+		return database.Unavailable(err)
 	}
 
 	return nil
 }
+
+// ListAll returns up to limit cards of every user ordered by ID, starting after the given ID.
+func (c CardRepository) ListAll(ctx context.Context, after uuid.UUID, limit int) ([]*dtos.Card, error) {
+	var cardModels []models.Card
+	err := c.DB.WithContext(ctx).
+		Where("id > ?", after).
+		Order("id").
+		Limit(limit).
+		Find(&cardModels).Error
+	if err != nil {
+		return nil, database.Unavailable(err)
+	}
+
+	cards := make([]*dtos.Card, 0, len(cardModels))
+	for i := range cardModels {
+		cards = append(cards, toDto(&cardModels[i]))
+	}
+
+	return cards, nil
+}
+
+// ListLegacyDigits returns up to limit cards whose last_digits column still holds
+// the first digits of the PAN or that have no fingerprint, ordered by ID and
+// starting after the given ID. Cards marked with an invalid PAN are left out.
+func (c CardRepository) ListLegacyDigits(ctx context.Context, after uuid.UUID, limit int) ([]*dtos.Card, error) {
+	var cardModels []models.Card
+	err := c.DB.WithContext(ctx).
+		Where("(masking_version < ? OR fingerprint IS NULL) AND masking_version <> ? AND id > ?",
+			models.LastDigitsVersion, models.InvalidPanVersion, after).
+		Order("id").
+		Limit(limit).
+		Find(&cardModels).Error
+	if err != nil {
+		return nil, database.Unavailable(err)
+	}
+
+	cards := make([]*dtos.Card, 0, len(cardModels))
+	for i := range cardModels {
+		cards = append(cards, toDto(&cardModels[i]))
+	}
+
+	return cards, nil
+}
+
+// UpdatePanMetadata stores the digits, fingerprint and metadata derived from the
+// PAN and marks the row as migrated.
+func (c CardRepository) UpdatePanMetadata(ctx context.Context, card *dtos.Card) error {
+	db := database.GetTx(ctx, c.DB)
+	err := db.Model(&models.Card{}).Where("id = ?", card.ID).Updates(map[string]interface{}{
+		"last_digits":     card.LastDigits,
+		"bin":             card.Bin,
+		"brand":           card.Brand,
+		"pan_length":      card.PanLength,
+		"fingerprint":     card.Fingerprint,
+		"masking_version": models.LastDigitsVersion,
+	}).Error
+	return database.Unavailable(err)
+}
+
+// MarkInvalidPan flags a legacy card whose PAN fails the validation, so the
+// backfill doesn't pick it up again.
+func (c CardRepository) MarkInvalidPan(ctx context.Context, id uuid.UUID) error {
+	db := database.GetTx(ctx, c.DB)
+	err := db.Model(&models.Card{}).Where("id = ?", id).Update("masking_version", models.InvalidPanVersion).Error
+	return database.Unavailable(err)
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
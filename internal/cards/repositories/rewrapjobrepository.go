@@ -0,0 +1,150 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/internal/cards/models"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"gorm.io/gorm"
+)
+
+const foreignKeyViolation = "23503"
+
+// claimRewrapJobSQL leases the oldest unfinished job nobody holds, see claimBatchJobSQL.
+const claimRewrapJobSQL = `
+UPDATE rewrap_jobs SET locked_until = ?, updated_at = ?
+WHERE id = (
+	SELECT id FROM rewrap_jobs
+	WHERE deleted_at IS NULL AND status IN ? AND (locked_until IS NULL OR locked_until < ?)
+	ORDER BY created_at
+	LIMIT 1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+type RewrapJobRepository struct {
+	DB *gorm.DB
+}
+
+func NewRewrapJobRepository(DB *gorm.DB) *RewrapJobRepository {
+	return &RewrapJobRepository{DB: DB}
+}
+
+// Create stores the job, senital.ErrNotFound when its user doesn't exist.
+func (r RewrapJobRepository) Create(ctx context.Context, job *dtos.RewrapJob) error {
+	m := &models.RewrapJob{
+		UserId:        job.UserID,
+		Status:        string(job.Status),
+		TargetVersion: job.TargetVersion,
+	}
+
+	err := r.DB.WithContext(ctx).Create(m).Error
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		return fmt.Errorf("user %s: %w", job.UserID, senital.ErrNotFound)
+	}
+	if err != nil {
+		return err
+	}
+
+	*job = *toRewrapJobDto(m)
+	return nil
+}
+
+func (r RewrapJobRepository) Get(ctx context.Context, id uuid.UUID) (*dtos.RewrapJob, error) {
+	var m models.RewrapJob
+	err := r.DB.WithContext(ctx).First(&m, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, senital.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return toRewrapJobDto(&m), nil
+}
+
+// FindActiveByUser returns the newest pending or running job of the user, or
+// senital.ErrNotFound.
+func (r RewrapJobRepository) FindActiveByUser(ctx context.Context, userID uuid.UUID) (*dtos.RewrapJob, error) {
+	var m models.RewrapJob
+	err := r.DB.WithContext(ctx).
+		Where("user_id = ? AND status IN ?", userID, activeRewrapStatuses()).
+		Order("created_at DESC").
+		First(&m).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, senital.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return toRewrapJobDto(&m), nil
+}
+
+// Claim leases the oldest unfinished job until lockedUntil, senital.ErrNotFound
+// when there is no job to claim.
+func (r RewrapJobRepository) Claim(ctx context.Context, now time.Time, lockedUntil time.Time) (*dtos.RewrapJob, error) {
+	var ms []models.RewrapJob
+	err := r.DB.WithContext(ctx).
+		Raw(claimRewrapJobSQL, lockedUntil, now, activeRewrapStatuses(), now).
+		Scan(&ms).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(ms) == 0 {
+		return nil, senital.ErrNotFound
+	}
+
+	return toRewrapJobDto(&ms[0]), nil
+}
+
+// SaveProgress persists the status, cursor and counters of the job, and extends
+// its lease until lockedUntil. It fails with senital.ErrConflict once the lease
+// of job expired and another instance claimed it.
+func (r RewrapJobRepository) SaveProgress(ctx context.Context, job *dtos.RewrapJob, lockedUntil time.Time) error {
+	result := r.DB.WithContext(ctx).Model(&models.RewrapJob{}).
+		Where("id = ? AND locked_until = ?", job.ID, job.LockedUntil).
+		Updates(map[string]interface{}{
+			"status":         string(job.Status),
+			"target_version": job.TargetVersion,
+			"last_card_id":   job.LastCardID,
+			"processed":      job.Processed,
+			"failed":         job.Failed,
+			"locked_until":   lockedUntil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("rewrap job %s lease lost: %w", job.ID, senital.ErrConflict)
+	}
+
+	job.LockedUntil = &lockedUntil
+	return nil
+}
+
+func activeRewrapStatuses() []string {
+	return []string{string(dtos.RewrapPending), string(dtos.RewrapRunning)}
+}
+
+func toRewrapJobDto(m *models.RewrapJob) *dtos.RewrapJob {
+	return &dtos.RewrapJob{
+		ID:            m.ID,
+		UserID:        m.UserId,
+		Status:        dtos.RewrapStatus(m.Status),
+		TargetVersion: m.TargetVersion,
+		LastCardID:    m.LastCardID,
+		Processed:     m.Processed,
+		Failed:        m.Failed,
+		CreatedAt:     m.CreatedAt,
+		UpdatedAt:     m.UpdatedAt,
+		LockedUntil:   m.LockedUntil,
+	}
+}
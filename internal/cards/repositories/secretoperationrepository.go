@@ -0,0 +1,177 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/internal/cards/models"
+	"github.com/juaguz/yuno/kit/database"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"gorm.io/gorm"
+)
+
+// claimableSecretOperation matches the pending operations nobody holds that are
+// first in line for their card, so the operations of a card are applied one at
+// a time and in the order they were recorded.
+const claimableSecretOperation = `
+o.deleted_at IS NULL AND o.status = @pending AND (o.locked_until IS NULL OR o.locked_until < @now)
+AND NOT EXISTS (
+	SELECT 1 FROM secret_operations p
+	WHERE p.card_id = o.card_id AND p.deleted_at IS NULL AND p.status = @pending AND p.sequence < o.sequence
+)`
+
+// claimSecretOperationsSQL leases the oldest due operations, SKIP LOCKED lets
+// several instances claim concurrently without waiting on each other.
+const claimSecretOperationsSQL = `
+UPDATE secret_operations SET locked_until = @locked_until, updated_at = @now
+WHERE id IN (
+	SELECT o.id FROM secret_operations o
+	WHERE o.next_attempt_at <= @now AND` + claimableSecretOperation + `
+	ORDER BY o.sequence
+	LIMIT @limit
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+// claimSecretOperationSQL leases a single operation, whether it is due or not.
+const claimSecretOperationSQL = `
+UPDATE secret_operations o SET locked_until = @locked_until, updated_at = @now
+WHERE o.id = @id AND` + claimableSecretOperation + `
+RETURNING *`
+
+type SecretOperationRepository struct {
+	DB *gorm.DB
+}
+
+func NewSecretOperationRepository(DB *gorm.DB) *SecretOperationRepository {
+	return &SecretOperationRepository{DB: DB}
+}
+
+// Create records the operation in the transaction of ctx, if any, so it is only
+// persisted along with the card row it belongs to. The pending operations of the
+// same secret nobody holds are superseded, only the newest one is applied.
+func (r SecretOperationRepository) Create(ctx context.Context, op *dtos.SecretOperation) error {
+	db := database.GetTx(ctx, r.DB)
+	err := db.Model(&models.SecretOperation{}).
+		Where("card_id = ? AND secret_key = ? AND status = ?", op.CardID, op.Key, string(dtos.SecretPending)).
+		Where("locked_until IS NULL OR locked_until < ?", time.Now()).
+		Updates(map[string]interface{}{
+			"status": string(dtos.SecretSuperseded),
+			"data":   nil,
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	m := &models.SecretOperation{
+		CardId:        op.CardID,
+		Type:          string(op.Type),
+		Status:        string(op.Status),
+		SecretKey:     op.Key,
+		Data:          op.Data,
+		NextAttemptAt: op.NextAttemptAt,
+	}
+
+	if err := db.Create(m).Error; err != nil {
+		return err
+	}
+
+	op.ID = m.ID
+	op.CreatedAt = m.CreatedAt
+	return nil
+}
+
+// Claim leases up to limit due operations until lockedUntil, oldest first and at
+// most one per card.
+func (r SecretOperationRepository) Claim(ctx context.Context, now time.Time, lockedUntil time.Time, limit int) ([]*dtos.SecretOperation, error) {
+	var ms []models.SecretOperation
+	err := r.DB.WithContext(ctx).
+		Raw(claimSecretOperationsSQL, map[string]interface{}{
+			"pending":      string(dtos.SecretPending),
+			"now":          now,
+			"locked_until": lockedUntil,
+			"limit":        limit,
+		}).
+		Scan(&ms).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]*dtos.SecretOperation, 0, len(ms))
+	for i := range ms {
+		ops = append(ops, toSecretOperationDto(&ms[i]))
+	}
+
+	return ops, nil
+}
+
+// ClaimByID leases the operation until lockedUntil. senital.ErrNotFound when it
+// is no longer pending, is held by another instance or waits for an older
+// operation of its card.
+func (r SecretOperationRepository) ClaimByID(ctx context.Context, id uuid.UUID, now time.Time, lockedUntil time.Time) (*dtos.SecretOperation, error) {
+	var ms []models.SecretOperation
+	err := r.DB.WithContext(ctx).
+		Raw(claimSecretOperationSQL, map[string]interface{}{
+			"id":           id,
+			"pending":      string(dtos.SecretPending),
+			"now":          now,
+			"locked_until": lockedUntil,
+		}).
+		Scan(&ms).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(ms) == 0 {
+		return nil, senital.ErrNotFound
+	}
+
+	return toSecretOperationDto(&ms[0]), nil
+}
+
+// HasPending reports whether the card has operations not applied yet.
+func (r SecretOperationRepository) HasPending(ctx context.Context, cardID uuid.UUID) (bool, error) {
+	var count int64
+	err := r.DB.WithContext(ctx).Model(&models.SecretOperation{}).
+		Where("card_id = ? AND status = ?", cardID, string(dtos.SecretPending)).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// Save persists the state of the operation after an attempt and releases its lease.
+func (r SecretOperationRepository) Save(ctx context.Context, op *dtos.SecretOperation) error {
+	// a struct instead of a map so data goes through the json serializer
+	return r.DB.WithContext(ctx).Model(&models.SecretOperation{}).
+		Where("id = ?", op.ID).
+		Select("type", "status", "data", "attempts", "last_error", "next_attempt_at", "locked_until").
+		Updates(&models.SecretOperation{
+			Type:          string(op.Type),
+			Status:        string(op.Status),
+			Data:          op.Data,
+			Attempts:      op.Attempts,
+			LastError:     op.LastError,
+			NextAttemptAt: op.NextAttemptAt,
+			LockedUntil:   op.LockedUntil,
+		}).Error
+}
+
+func toSecretOperationDto(m *models.SecretOperation) *dtos.SecretOperation {
+	return &dtos.SecretOperation{
+		ID:            m.ID,
+		CardID:        m.CardId,
+		Type:          dtos.SecretOperationType(m.Type),
+		Status:        dtos.SecretOperationStatus(m.Status),
+		Key:           m.SecretKey,
+		Data:          m.Data,
+		Attempts:      m.Attempts,
+		LastError:     m.LastError,
+		NextAttemptAt: m.NextAttemptAt,
+		LockedUntil:   m.LockedUntil,
+		CreatedAt:     m.CreatedAt,
+	}
+}
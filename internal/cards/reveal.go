@@ -0,0 +1,71 @@
+package cards
+
+import (
+	"context"
+	"log"
+
+	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/kit/audit"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"github.com/juaguz/yuno/kit/users/auth"
+)
+
+const (
+	DefaultRevealPermission = "cards:reveal"
+	revealAction            = "cards.reveal"
+)
+
+type AuditRepository interface {
+	Record(ctx context.Context, event *audit.Event) error
+}
+
+// Revealer returns clear PANs to callers whose token grants Permission.
+// Every attempt, allowed or not, is written to the audit trail.
+type Revealer struct {
+	CardService     *CardService
+	AuditRepository AuditRepository
+	Permission      string
+}
+
+func NewRevealer(cardService *CardService, auditRepository AuditRepository, permission string) *Revealer {
+	return &Revealer{
+		CardService:     cardService,
+		AuditRepository: auditRepository,
+		Permission:      permission,
+	}
+}
+
+func (r *Revealer) Reveal(ctx context.Context, card *dtos.Card) (*dtos.RevealedCard, error) {
+	event := &audit.Event{
+		UserID:     card.UserId,
+		Action:     revealAction,
+		ResourceID: card.ID.String(),
+	}
+
+	if !auth.HasPermission(ctx, r.Permission) {
+		event.Outcome = audit.Denied
+		event.Reason = "missing permission " + r.Permission
+		if err := r.AuditRepository.Record(ctx, event); err != nil {
+			return nil, err
+		}
+		return nil, senital.ErrForbidden
+	}
+
+	revealed, err := r.CardService.Detokenize(ctx, card)
+	if err != nil {
+		event.Outcome = audit.Failed
+		event.Reason = err.Error()
+		if auditErr := r.AuditRepository.Record(ctx, event); auditErr != nil {
+			log.Printf("reveal: recording audit event: %s", auditErr)
+		}
+		return nil, err
+	}
+
+	// the PAN is only returned once the reveal is on the audit trail
+	event.Outcome = audit.Allowed
+	if err := r.AuditRepository.Record(ctx, event); err != nil {
+		return nil, err
+	}
+
+	return revealed, nil
+}
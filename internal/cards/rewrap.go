@@ -0,0 +1,304 @@
+package cards
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"github.com/juaguz/yuno/kit/kms"
+)
+
+const (
+	rewrapPageSize = 100
+	// DefaultRewrapLease is how long a claimed job is held by an instance
+	// without saving progress before another one can take it over.
+	DefaultRewrapLease = 5 * time.Minute
+)
+
+type RewrapKmsRepository interface {
+	GetPublicKey(ctx context.Context, keyID string) (string, int, error)
+	Rewrap(ctx context.Context, ciphertext string, keyID string) (string, error)
+}
+
+// RewrapVaultRepository also destroys the secret versions holding ciphertexts
+// of the previous key versions.
+type RewrapVaultRepository interface {
+	VaultRepository
+	DestroyPreviousVersions(ctx context.Context, key string) error
+}
+
+type RewrapJobRepository interface {
+	Create(ctx context.Context, job *dtos.RewrapJob) error
+	Get(ctx context.Context, id uuid.UUID) (*dtos.RewrapJob, error)
+	FindActiveByUser(ctx context.Context, userID uuid.UUID) (*dtos.RewrapJob, error)
+	Claim(ctx context.Context, now time.Time, lockedUntil time.Time) (*dtos.RewrapJob, error)
+	SaveProgress(ctx context.Context, job *dtos.RewrapJob, lockedUntil time.Time) error
+}
+
+// Rewrapper moves the PAN secrets of a user to the latest version of their key.
+// Jobs are persisted and processed by Run, which claims them with a lease and
+// resumes from the last saved card, so a crash only repeats the current page.
+// Rewrapping is idempotent, so a card processed twice is harmless. Once a secret
+// is rewrapped, its previous versions are destroyed.
+type Rewrapper struct {
+	CardRepository  CardRepository
+	KmsRepository   RewrapKmsRepository
+	VaultRepository RewrapVaultRepository
+	JobRepository   RewrapJobRepository
+	Transactor      Transactor
+	SecretStore     SecretStore
+	Lease           time.Duration
+	now             func() time.Time
+}
+
+type RewrapOption func(*Rewrapper)
+
+// WithRewrapSecretStore changes how the rewrapped secrets are written, Vault is
+// called directly by default. It should be the store of the card service, so the
+// writes are ordered with the ones of the cards.
+func WithRewrapSecretStore(store SecretStore) RewrapOption {
+	return func(r *Rewrapper) {
+		r.SecretStore = store
+	}
+}
+
+func NewRewrapper(cardRepository CardRepository, kmsRepository RewrapKmsRepository, vaultRepository RewrapVaultRepository, jobRepository RewrapJobRepository, transactor Transactor, opts ...RewrapOption) *Rewrapper {
+	r := &Rewrapper{
+		CardRepository:  cardRepository,
+		KmsRepository:   kmsRepository,
+		VaultRepository: vaultRepository,
+		JobRepository:   jobRepository,
+		Transactor:      transactor,
+		SecretStore:     vaultSecretStore{VaultRepository: vaultRepository},
+		Lease:           DefaultRewrapLease,
+		now:             time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Schedule creates a rewrap job for the user, or returns the one already in
+// progress when it moves the cards to the latest key version. A job running for
+// an older version gets a follow-up job, the cards it went past would stay on
+// that version otherwise.
+func (r *Rewrapper) Schedule(ctx context.Context, userID uuid.UUID) (*dtos.RewrapJob, error) {
+	job, err := r.JobRepository.FindActiveByUser(ctx, userID)
+	if err != nil && !errors.Is(err, senital.ErrNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		latest, err := r.targetsLatest(ctx, job)
+		if err != nil {
+			return nil, err
+		}
+		if latest {
+			return job, nil
+		}
+	}
+
+	job = &dtos.RewrapJob{
+		UserID: userID,
+		Status: dtos.RewrapPending,
+	}
+	if err := r.JobRepository.Create(ctx, job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// targetsLatest reports whether job moves the cards to the latest version of the
+// key. Pending jobs pick the version once they start.
+func (r *Rewrapper) targetsLatest(ctx context.Context, job *dtos.RewrapJob) (bool, error) {
+	if job.Status == dtos.RewrapPending {
+		return true, nil
+	}
+
+	_, version, err := r.KmsRepository.GetPublicKey(ctx, job.UserID.String())
+	if err != nil {
+		return false, err
+	}
+
+	return job.TargetVersion >= version, nil
+}
+
+// Get returns a job of the user, jobs of other users are reported as not found.
+func (r *Rewrapper) Get(ctx context.Context, userID uuid.UUID, jobID uuid.UUID) (*dtos.RewrapJob, error) {
+	job, err := r.JobRepository.Get(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.UserID != userID {
+		return nil, senital.ErrNotFound
+	}
+
+	return job, nil
+}
+
+// GetJob returns any job, for the operators following a rewrap they scheduled.
+func (r *Rewrapper) GetJob(ctx context.Context, jobID uuid.UUID) (*dtos.RewrapJob, error) {
+	return r.JobRepository.Get(ctx, jobID)
+}
+
+// Run processes the active jobs every interval until ctx is cancelled.
+func (r *Rewrapper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		r.processActive(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Rewrapper) processActive(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := r.now()
+		job, err := r.JobRepository.Claim(ctx, now, now.Add(r.Lease))
+		if errors.Is(err, senital.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			log.Printf("rewrap: claiming job: %s", err)
+			return
+		}
+
+		if err := r.Process(ctx, job); err != nil {
+			log.Printf("rewrap: job %s: %s", job.ID, err)
+		}
+	}
+}
+
+// Process rewraps every card of a claimed job's user, saving the progress and
+// extending the lease after each page.
+func (r *Rewrapper) Process(ctx context.Context, job *dtos.RewrapJob) error {
+	keyID := job.UserID.String()
+
+	if job.Status == dtos.RewrapPending {
+		_, version, err := r.KmsRepository.GetPublicKey(ctx, keyID)
+		if err != nil {
+			return err
+		}
+
+		job.Status = dtos.RewrapRunning
+		job.TargetVersion = version
+		if err := r.JobRepository.SaveProgress(ctx, job, r.now().Add(r.Lease)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		cards, err := r.CardRepository.ListByUser(ctx, job.UserID, job.LastCardID, rewrapPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, card := range cards {
+			if err := r.rewrapCard(ctx, card, job.TargetVersion); err != nil {
+				log.Printf("rewrap: card %s: %s", card.ID, err)
+				job.Failed++
+			} else {
+				job.Processed++
+			}
+			job.LastCardID = card.ID
+		}
+
+		if len(cards) < rewrapPageSize {
+			job.Status = dtos.RewrapCompleted
+		}
+
+		if err := r.JobRepository.SaveProgress(ctx, job, r.now().Add(r.Lease)); err != nil {
+			return err
+		}
+
+		if job.Status == dtos.RewrapCompleted {
+			return nil
+		}
+	}
+}
+
+// rewrapCard writes the secret rewrapped with the card locked, so a delete of the
+// card is either seen here or queued after the write. A deleted card is skipped.
+func (r *Rewrapper) rewrapCard(ctx context.Context, card *dtos.Card, targetVersion int) error {
+	if card.KeyVersion >= targetVersion {
+		return nil
+	}
+
+	key := buildKey(card.UserId, card.ID)
+	var rewrapped string
+	err := r.Transactor.InTx(ctx, func(ctx context.Context) error {
+		locked, err := r.CardRepository.GetForUpdate(ctx, card.ID)
+		if errors.Is(err, senital.ErrNotFound) {
+			// deleted since it was listed
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if locked.KeyVersion >= targetVersion {
+			return nil
+		}
+
+		secret, err := r.VaultRepository.Get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("reading secret: %w", err)
+		}
+
+		ciphertext, err := secretCiphertext(key, secret)
+		if err != nil {
+			return err
+		}
+
+		rewrapped, err = r.KmsRepository.Rewrap(ctx, ciphertext, card.UserId.String())
+		if err != nil {
+			return err
+		}
+
+		data := make(map[string]interface{}, len(secret))
+		for k, v := range secret {
+			data[k] = v
+		}
+		data["pan"] = rewrapped
+		return r.SecretStore.Put(ctx, card.ID, key, data)
+	})
+	if err != nil || rewrapped == "" {
+		return err
+	}
+
+	version, err := kms.CiphertextVersion(rewrapped)
+	if err != nil {
+		return err
+	}
+
+	// the store may write after the commit, the previous versions are only
+	// destroyed once the rewrapped secret is the current one
+	secret, err := r.VaultRepository.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if secret["pan"] != rewrapped {
+		return fmt.Errorf("rewrapped secret of card %s isn't written yet", card.ID)
+	}
+
+	// the key version is saved last, a card whose old versions survived a crash
+	// is still behind and gets rewrapped again
+	if err := r.VaultRepository.DestroyPreviousVersions(ctx, key); err != nil {
+		return err
+	}
+
+	return r.CardRepository.UpdateKeyVersion(ctx, card.ID, version)
+}
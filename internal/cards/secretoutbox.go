@@ -0,0 +1,244 @@
+package cards
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/kit/audit"
+	"github.com/juaguz/yuno/kit/database"
+	"github.com/juaguz/yuno/kit/errors/senital"
+)
+
+const (
+	secretOutboxPageSize = 100
+
+	// DefaultSecretCompensateAfter is how long a secret write is retried before
+	// its card is deleted, long enough to ride out a Vault outage.
+	DefaultSecretCompensateAfter = 72 * time.Hour
+	DefaultSecretBackoff         = 5 * time.Second
+	maxSecretBackoff             = time.Hour
+	// DefaultSecretLease is how long a claimed operation is held by an instance
+	// before another one may apply it.
+	DefaultSecretLease = time.Minute
+
+	compensateAction = "cards.compensate"
+)
+
+type SecretOperationRepository interface {
+	Create(ctx context.Context, op *dtos.SecretOperation) error
+	Claim(ctx context.Context, now time.Time, lockedUntil time.Time, limit int) ([]*dtos.SecretOperation, error)
+	ClaimByID(ctx context.Context, id uuid.UUID, now time.Time, lockedUntil time.Time) (*dtos.SecretOperation, error)
+	Save(ctx context.Context, op *dtos.SecretOperation) error
+}
+
+// SecretOutbox keeps the card rows and their Vault secrets consistent. Put and
+// Delete record the operation in the transaction of the card row, so it only
+// exists if the row change is committed, and apply it to Vault once committed.
+// Failed operations are retried by Run with exponential backoff. A secret that
+// still can't be written CompensateAfter it was recorded is compensated: the
+// card row is deleted, which is written to the audit trail, and the write
+// becomes a removal, so neither side is left behind. A zero CompensateAfter
+// retries forever.
+// Vault writes and deletes are idempotent, applying an operation twice is harmless.
+//
+// The operations of a card are applied one at a time and in the order they were
+// recorded, so a write retried after the removal of the card can't bring its
+// secret back. A new operation supersedes the pending ones of the same secret,
+// and an operation is claimed with a lease before it is applied, so replicas
+// don't apply it concurrently.
+type SecretOutbox struct {
+	VaultRepository     VaultRepository
+	CardRepository      CardRepository
+	OperationRepository SecretOperationRepository
+	AuditRepository     AuditRepository
+	CompensateAfter     time.Duration
+	Backoff             time.Duration
+	Lease               time.Duration
+	now                 func() time.Time
+}
+
+func NewSecretOutbox(vaultRepository VaultRepository, cardRepository CardRepository, operationRepository SecretOperationRepository, auditRepository AuditRepository, compensateAfter time.Duration, backoff time.Duration) *SecretOutbox {
+	return &SecretOutbox{
+		VaultRepository:     vaultRepository,
+		CardRepository:      cardRepository,
+		OperationRepository: operationRepository,
+		AuditRepository:     auditRepository,
+		CompensateAfter:     compensateAfter,
+		Backoff:             backoff,
+		Lease:               DefaultSecretLease,
+		now:                 time.Now,
+	}
+}
+
+func (o *SecretOutbox) Put(ctx context.Context, cardID uuid.UUID, key string, data map[string]interface{}) error {
+	return o.enqueue(ctx, &dtos.SecretOperation{
+		CardID: cardID,
+		Type:   dtos.SecretPut,
+		Key:    key,
+		Data:   data,
+	})
+}
+
+func (o *SecretOutbox) Delete(ctx context.Context, cardID uuid.UUID, key string) error {
+	return o.enqueue(ctx, &dtos.SecretOperation{
+		CardID: cardID,
+		Type:   dtos.SecretDelete,
+		Key:    key,
+	})
+}
+
+func (o *SecretOutbox) enqueue(ctx context.Context, op *dtos.SecretOperation) error {
+	op.Status = dtos.SecretPending
+	// leaves time to the call after commit before Run picks the operation up
+	op.NextAttemptAt = o.now().Add(o.Backoff)
+
+	if err := o.OperationRepository.Create(ctx, op); err != nil {
+		return err
+	}
+
+	database.AfterCommit(ctx, func(ctx context.Context) {
+		now := o.now()
+		claimed, err := o.OperationRepository.ClaimByID(ctx, op.ID, now, now.Add(o.Lease))
+		if errors.Is(err, senital.ErrNotFound) {
+			// an older operation of the card goes first, Run applies this one after it
+			return
+		}
+		if err != nil {
+			log.Printf("secret outbox: claiming operation %s: %s", op.ID, err)
+			return
+		}
+
+		if err := o.Apply(ctx, claimed); err != nil {
+			log.Printf("secret outbox: operation %s: %s", op.ID, err)
+		}
+	})
+
+	return nil
+}
+
+// Run retries the due operations every interval until ctx is cancelled.
+func (o *SecretOutbox) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		o.processDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (o *SecretOutbox) processDue(ctx context.Context) {
+	now := o.now()
+	ops, err := o.OperationRepository.Claim(ctx, now, now.Add(o.Lease), secretOutboxPageSize)
+	if err != nil {
+		log.Printf("secret outbox: claiming operations: %s", err)
+		return
+	}
+
+	for _, op := range ops {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := o.Apply(ctx, op); err != nil {
+			log.Printf("secret outbox: operation %s: %s", op.ID, err)
+		}
+	}
+}
+
+// Apply runs the operation against Vault and saves its outcome, which releases it.
+// The operation must be claimed first. The returned error is the Vault one, the
+// operation is already scheduled for a retry when it happens.
+func (o *SecretOutbox) Apply(ctx context.Context, op *dtos.SecretOperation) error {
+	op.LockedUntil = nil
+
+	var err error
+	switch op.Type {
+	case dtos.SecretPut:
+		err = o.VaultRepository.Create(ctx, op.Data, op.Key)
+	case dtos.SecretDelete:
+		err = o.VaultRepository.Delete(ctx, op.Key)
+	}
+
+	if err == nil {
+		op.Status = dtos.SecretDone
+		// the ciphertext is only kept until it reaches Vault
+		op.Data = nil
+		op.LastError = ""
+		return o.OperationRepository.Save(ctx, op)
+	}
+
+	op.Attempts++
+	op.LastError = err.Error()
+	op.NextAttemptAt = o.now().Add(o.backoff(op.Attempts))
+
+	if op.Type == dtos.SecretPut && o.CompensateAfter > 0 && o.now().Sub(op.CreatedAt) >= o.CompensateAfter {
+		if compensateErr := o.compensate(ctx, op); compensateErr != nil {
+			log.Printf("secret outbox: compensating operation %s: %s", op.ID, compensateErr)
+		}
+	}
+
+	if saveErr := o.OperationRepository.Save(ctx, op); saveErr != nil {
+		log.Printf("secret outbox: saving operation %s: %s", op.ID, saveErr)
+	}
+
+	return err
+}
+
+// compensate deletes the card whose secret can't be written and turns the
+// operation into a removal of the secret, in case it was partially written. The
+// card is only deleted once the deletion is on the audit trail.
+func (o *SecretOutbox) compensate(ctx context.Context, op *dtos.SecretOperation) error {
+	// the write keeps being retried until the row is gone
+	card, err := o.CardRepository.Get(ctx, op.CardID)
+	if err != nil && !errors.Is(err, senital.ErrNotFound) {
+		return err
+	}
+
+	if card != nil {
+		reason := fmt.Sprintf("card deleted, its secret couldn't be written for %s after %d attempts: %s",
+			o.now().Sub(op.CreatedAt).Round(time.Second), op.Attempts, op.LastError)
+		err := o.AuditRepository.Record(ctx, &audit.Event{
+			UserID:     card.UserId,
+			Action:     compensateAction,
+			ResourceID: card.ID.String(),
+			Outcome:    audit.Failed,
+			Reason:     reason,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := o.CardRepository.Delete(ctx, op.CardID); err != nil {
+			return err
+		}
+
+		log.Printf("secret outbox: card %s: %s", op.CardID, reason)
+	}
+
+	op.Type = dtos.SecretDelete
+	op.Status = dtos.SecretPending
+	op.Data = nil
+	op.Attempts = 0
+	op.NextAttemptAt = o.now()
+
+	return nil
+}
+
+func (o *SecretOutbox) backoff(attempts int) time.Duration {
+	backoff := o.Backoff
+	for i := 1; i < attempts && backoff < maxSecretBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, maxSecretBackoff)
+}
@@ -0,0 +1,137 @@
+package validation
+
+// iinRange is an inclusive range of issuer identification number prefixes,
+// low and high must have the same number of digits.
+type iinRange struct {
+	low  string
+	high string
+}
+
+func (r iinRange) contains(pan string) bool {
+	if len(pan) < len(r.low) {
+		return false
+	}
+
+	prefix := pan[:len(r.low)]
+	return prefix >= r.low && prefix <= r.high
+}
+
+func prefix(p string) iinRange {
+	return iinRange{low: p, high: p}
+}
+
+func between(low, high string) iinRange {
+	return iinRange{low: low, high: high}
+}
+
+type rule struct {
+	network Network
+	ranges  []iinRange
+	lengths []int
+	luhn    bool
+}
+
+func (r *rule) acceptsLength(length int) bool {
+	for _, l := range r.lengths {
+		if l == length {
+			return true
+		}
+	}
+	return false
+}
+
+func lengths(min, max int) []int {
+	l := make([]int, 0, max-min+1)
+	for i := min; i <= max; i++ {
+		l = append(l, i)
+	}
+	return l
+}
+
+// rules lists the IIN ranges and lengths of every supported network. Ranges of
+// different networks overlap (e.g. Elo inside Visa and Discover), match picks
+// the most specific one.
+var rules = []rule{
+	{
+		network: Visa,
+		ranges:  []iinRange{prefix("4")},
+		lengths: []int{13, 16, 19},
+		luhn:    true,
+	},
+	{
+		network: MasterCard,
+		ranges:  []iinRange{between("51", "55"), between("2221", "2720")},
+		lengths: []int{16},
+		luhn:    true,
+	},
+	{
+		network: Amex,
+		ranges:  []iinRange{prefix("34"), prefix("37")},
+		lengths: []int{15},
+		luhn:    true,
+	},
+	{
+		network: Discover,
+		ranges:  []iinRange{prefix("6011"), between("644", "649"), prefix("65"), between("622126", "622925")},
+		lengths: lengths(16, 19),
+		luhn:    true,
+	},
+	{
+		network: JCB,
+		ranges:  []iinRange{between("3528", "3589"), prefix("2131"), prefix("1800")},
+		lengths: lengths(15, 19),
+		luhn:    true,
+	},
+	{
+		network: DinersClub,
+		ranges:  []iinRange{between("300", "305"), prefix("3095"), prefix("36"), between("38", "39")},
+		lengths: lengths(14, 19),
+		luhn:    true,
+	},
+	{
+		network: Maestro,
+		ranges: []iinRange{
+			prefix("5018"), prefix("5020"), prefix("5038"), prefix("5893"),
+			prefix("6304"), prefix("6759"), between("6761", "6763"),
+		},
+		lengths: lengths(12, 19),
+		luhn:    true,
+	},
+	{
+		// UnionPay doesn't require Luhn valid numbers
+		network: UnionPay,
+		ranges:  []iinRange{prefix("62"), prefix("81")},
+		lengths: lengths(16, 19),
+		luhn:    false,
+	},
+	{
+		network: Elo,
+		ranges: []iinRange{
+			prefix("401178"), prefix("401179"), prefix("431274"), prefix("438935"),
+			prefix("451416"), prefix("457393"), prefix("457631"), prefix("457632"),
+			prefix("504175"), between("506699", "506778"), between("509000", "509999"),
+			prefix("627780"), prefix("636297"), prefix("636368"),
+			between("650031", "650033"), between("650035", "650051"), between("650405", "650439"),
+			between("650485", "650538"), between("650541", "650598"), between("650700", "650718"),
+			between("650720", "650727"), between("650901", "650978"), between("651652", "651679"),
+			between("655000", "655019"), between("655021", "655058"),
+		},
+		lengths: []int{16},
+		luhn:    true,
+	},
+	{
+		network: Mir,
+		ranges:  []iinRange{between("2200", "2204")},
+		lengths: lengths(16, 19),
+		luhn:    true,
+	},
+	{
+		network: RuPay,
+		ranges: []iinRange{
+			between("508500", "508999"), between("606985", "607984"),
+			between("608001", "608500"), between("652150", "653149"),
+		},
+		lengths: []int{16},
+		luhn:    true,
+	},
+}
@@ -0,0 +1,121 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidPan = errors.New("invalid pan")
+
+type Network string
+
+const (
+	Visa       Network = "visa"
+	MasterCard Network = "mastercard"
+	Amex       Network = "amex"
+	Discover   Network = "discover"
+	JCB        Network = "jcb"
+	DinersClub Network = "diners"
+	Maestro    Network = "maestro"
+	UnionPay   Network = "unionpay"
+	Elo        Network = "elo"
+	Mir        Network = "mir"
+	RuPay      Network = "rupay"
+)
+
+// Reason is the machine readable cause of a validation error.
+type Reason string
+
+const (
+	ReasonInvalidFormat  Reason = "invalid_format"
+	ReasonUnknownNetwork Reason = "unknown_network"
+	ReasonInvalidLength  Reason = "invalid_length"
+	ReasonLuhnCheck      Reason = "luhn_check_failed"
+)
+
+// Error describes why a PAN was rejected. It matches ErrInvalidPan with errors.Is.
+type Error struct {
+	Reason  Reason
+	Network Network
+}
+
+func (e *Error) Error() string {
+	if e.Network != "" {
+		return fmt.Sprintf("invalid pan: %s for %s", e.Reason, e.Network)
+	}
+	return fmt.Sprintf("invalid pan: %s", e.Reason)
+}
+
+func (e *Error) Unwrap() error {
+	return ErrInvalidPan
+}
+
+// Validate checks the PAN against the rules of the network it belongs to and
+// returns that network.
+func Validate(pan string) (Network, error) {
+	if len(pan) < 12 || len(pan) > 19 || !isNumeric(pan) {
+		return "", &Error{Reason: ReasonInvalidFormat}
+	}
+
+	r := match(pan)
+	if r == nil {
+		return "", &Error{Reason: ReasonUnknownNetwork}
+	}
+
+	if !r.acceptsLength(len(pan)) {
+		return "", &Error{Reason: ReasonInvalidLength, Network: r.network}
+	}
+
+	if r.luhn && !luhn(pan) {
+		return "", &Error{Reason: ReasonLuhnCheck, Network: r.network}
+	}
+
+	return r.network, nil
+}
+
+// match returns the rule with the most specific IIN range containing the PAN.
+// On ties the rule listed first wins.
+func match(pan string) *rule {
+	var best *rule
+	bestLength := 0
+
+	for i := range rules {
+		for _, rg := range rules[i].ranges {
+			if len(rg.low) > bestLength && rg.contains(pan) {
+				best = &rules[i]
+				bestLength = len(rg.low)
+			}
+		}
+	}
+
+	return best
+}
+
+func isNumeric(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// luhn reports whether the PAN passes the mod 10 check.
+func luhn(pan string) bool {
+	sum := 0
+	double := false
+
+	for i := len(pan) - 1; i >= 0; i-- {
+		d := int(pan[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+
+	return sum%10 == 0
+}
@@ -0,0 +1,69 @@
+package validation_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/juaguz/yuno/internal/cards/validation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		pan     string
+		network validation.Network
+		reason  validation.Reason
+	}{
+		{name: "visa 13 digits", pan: "4222222222222", network: validation.Visa},
+		{name: "visa 16 digits", pan: "4111111111111111", network: validation.Visa},
+		{name: "visa 19 digits", pan: "4000000000000000006", network: validation.Visa},
+		{name: "mastercard 5 series", pan: "5555555555554444", network: validation.MasterCard},
+		{name: "mastercard 2 series", pan: "2223003122003222", network: validation.MasterCard},
+		{name: "amex 37", pan: "378282246310005", network: validation.Amex},
+		{name: "amex 34", pan: "343434343434343", network: validation.Amex},
+		{name: "discover 6011", pan: "6011111111111117", network: validation.Discover},
+		{name: "discover 65", pan: "6500000000000002", network: validation.Discover},
+		{name: "jcb", pan: "3530111333300000", network: validation.JCB},
+		{name: "diners 305", pan: "30569309025904", network: validation.DinersClub},
+		{name: "diners 36", pan: "36227206271667", network: validation.DinersClub},
+		{name: "maestro 16 digits", pan: "6759649826438453", network: validation.Maestro},
+		{name: "maestro 12 digits", pan: "501800000009", network: validation.Maestro},
+		{name: "unionpay", pan: "6200000000000005", network: validation.UnionPay},
+		{name: "unionpay without luhn", pan: "6212345678901233", network: validation.UnionPay},
+		{name: "elo", pan: "6363680000000007", network: validation.Elo},
+		{name: "elo inside visa range", pan: "4011780000000006", network: validation.Elo},
+		{name: "mir", pan: "2200000000000004", network: validation.Mir},
+		{name: "rupay 508", pan: "5085000000000007", network: validation.RuPay},
+		{name: "rupay 607", pan: "6070000000000002", network: validation.RuPay},
+
+		{name: "empty", pan: "", reason: validation.ReasonInvalidFormat},
+		{name: "too short", pan: "41111", reason: validation.ReasonInvalidFormat},
+		{name: "too long", pan: "41111111111111111111", reason: validation.ReasonInvalidFormat},
+		{name: "non numeric", pan: "4111-1111-1111-1111", reason: validation.ReasonInvalidFormat},
+		{name: "unknown network", pan: "1234567890123456", reason: validation.ReasonUnknownNetwork},
+		{name: "mastercard outside 2 series", pan: "2721000000000000", reason: validation.ReasonUnknownNetwork},
+		{name: "visa 15 digits", pan: "411100000000001", network: validation.Visa, reason: validation.ReasonInvalidLength},
+		{name: "amex 16 digits", pan: "3782822463100051", network: validation.Amex, reason: validation.ReasonInvalidLength},
+		{name: "visa luhn", pan: "4111111111111112", network: validation.Visa, reason: validation.ReasonLuhnCheck},
+		{name: "mastercard luhn", pan: "5555555555554445", network: validation.MasterCard, reason: validation.ReasonLuhnCheck},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network, err := validation.Validate(tt.pan)
+
+			if tt.reason == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.network, network)
+				return
+			}
+
+			var validationErr *validation.Error
+			assert.True(t, errors.As(err, &validationErr))
+			assert.ErrorIs(t, err, validation.ErrInvalidPan)
+			assert.Equal(t, tt.reason, validationErr.Reason)
+			assert.Equal(t, tt.network, validationErr.Network)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package cards
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultBatchWorkers is the number of batch items applied at the same time.
+const DefaultBatchWorkers = 15
+
+// WorkerPool runs tasks on a fixed set of workers shared by every batch, so the
+// load batches put on the database and Vault doesn't grow with their size or
+// number.
+type WorkerPool struct {
+	tasks chan func()
+	wg    sync.WaitGroup
+}
+
+// NewWorkerPool starts workers workers. A pool without workers never runs the
+// submitted tasks, Submit waits until its context is done.
+func NewWorkerPool(workers int) *WorkerPool {
+	p := &WorkerPool{tasks: make(chan func())}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+
+	return p
+}
+
+// Submit hands task to the next idle worker, waiting for one until ctx is done.
+func (p *WorkerPool) Submit(ctx context.Context, task func()) error {
+	select {
+	case p.tasks <- task:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops the workers once they finish their current task.
+func (p *WorkerPool) Close() {
+	close(p.tasks)
+	p.wg.Wait()
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	for task := range p.tasks {
+		task()
+	}
+}
@@ -7,8 +7,16 @@ import (
 )
 
 type KmsRepo interface {
-	GetPublicKey(ctx context.Context, keyID string) (string, error)
+	GetPublicKey(ctx context.Context, keyID string) (string, int, error)
 	CreateKey(ctx context.Context, keyID string) error
+	RotateKey(ctx context.Context, keyID string) error
+}
+
+// Key is the public part of the latest version of a user's key. Clients must
+// send Version along with the ciphertexts produced with PublicKey.
+type Key struct {
+	PublicKey string
+	Version   int
 }
 
 type KeysProvider struct {
@@ -19,12 +27,31 @@ func NewKeysProvider(kmsRepo KmsRepo) *KeysProvider {
 	return &KeysProvider{KmsRepo: kmsRepo}
 }
 
-func (k *KeysProvider) CreateKey(ctx context.Context, userID uuid.UUID) (string, error) {
+func (k *KeysProvider) CreateKey(ctx context.Context, userID uuid.UUID) (*Key, error) {
 	keyID := userID.String()
 	err := k.KmsRepo.CreateKey(ctx, keyID)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	return k.GetPublicKey(ctx, userID)
+}
+
+func (k *KeysProvider) GetPublicKey(ctx context.Context, userID uuid.UUID) (*Key, error) {
+	publicKey, version, err := k.KmsRepo.GetPublicKey(ctx, userID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{PublicKey: publicKey, Version: version}, nil
+}
+
+// RotateKey creates a new version of the user's key and returns it.
+func (k *KeysProvider) RotateKey(ctx context.Context, userID uuid.UUID) (*Key, error) {
+	err := k.KmsRepo.RotateKey(ctx, userID.String())
+	if err != nil {
+		return nil, err
 	}
 
-	return k.KmsRepo.GetPublicKey(ctx, keyID)
+	return k.GetPublicKey(ctx, userID)
 }
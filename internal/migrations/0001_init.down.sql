@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS cards;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,7 @@
+-- Wrapped keyrings of the local KMS backend (KMS_BACKEND=local, KMS_LOCAL_STORE=postgres)
+CREATE TABLE IF NOT EXISTS kms_keys (
+    key_id VARCHAR(255) PRIMARY KEY,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+ALTER TABLE cards DROP COLUMN IF EXISTS key_version;
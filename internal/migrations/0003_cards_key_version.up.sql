@@ -0,0 +1,2 @@
+-- Version of the user's key the stored PAN is encrypted with
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS key_version INTEGER NOT NULL DEFAULT 1;
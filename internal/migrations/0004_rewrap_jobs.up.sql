@@ -0,0 +1,16 @@
+-- Progress of the jobs that rewrap stored PANs to the latest key version
+CREATE TABLE IF NOT EXISTS rewrap_jobs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP,
+    user_id UUID NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    target_version INTEGER NOT NULL DEFAULT 0,
+    last_card_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000',
+    processed INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_rewrap_jobs_status ON rewrap_jobs (status);
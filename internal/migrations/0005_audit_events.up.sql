@@ -0,0 +1,12 @@
+-- Audit trail of sensitive operations such as PAN reveals
+CREATE TABLE IF NOT EXISTS audit_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    user_id UUID NOT NULL,
+    action VARCHAR(100) NOT NULL,
+    resource_id VARCHAR(255) NOT NULL,
+    outcome VARCHAR(20) NOT NULL,
+    reason TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_user_id ON audit_events (user_id, created_at);
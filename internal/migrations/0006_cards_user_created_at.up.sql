@@ -0,0 +1,2 @@
+-- Cursor pagination of the cards of a user
+CREATE INDEX IF NOT EXISTS idx_cards_user_created_at ON cards (user_id, created_at, id);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_cards_user_brand;
+
+ALTER TABLE cards DROP COLUMN IF EXISTS expiry_year;
+ALTER TABLE cards DROP COLUMN IF EXISTS expiry_month;
+ALTER TABLE cards DROP COLUMN IF EXISTS pan_length;
+ALTER TABLE cards DROP COLUMN IF EXISTS bin;
+ALTER TABLE cards DROP COLUMN IF EXISTS brand;
@@ -0,0 +1,8 @@
+-- Card metadata derived from the PAN when the card is stored
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS brand VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS bin VARCHAR(8) NOT NULL DEFAULT '';
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS pan_length SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS expiry_month SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS expiry_year SMALLINT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_cards_user_brand ON cards (user_id, brand);
@@ -0,0 +1,3 @@
+-- Rows with masking_version 0 hold the first four digits of the PAN in last_digits,
+-- `go run ./cmd/backfill` moves them to the last four digits.
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS masking_version SMALLINT NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_cards_fingerprint;
+DROP INDEX IF EXISTS idx_cards_user_fingerprint;
+
+ALTER TABLE cards DROP COLUMN IF EXISTS fingerprint;
@@ -0,0 +1,5 @@
+-- HMAC of the PAN computed with the fingerprint key held in the KMS
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS fingerprint CHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_cards_user_fingerprint ON cards (user_id, fingerprint);
+CREATE INDEX IF NOT EXISTS idx_cards_fingerprint ON cards (fingerprint);
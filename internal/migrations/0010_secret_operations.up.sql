@@ -0,0 +1,17 @@
+-- Vault secret writes and deletes recorded with the card row they belong to
+CREATE TABLE IF NOT EXISTS secret_operations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP,
+    card_id UUID NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    secret_key VARCHAR(255) NOT NULL,
+    data JSONB,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_secret_operations_due ON secret_operations (status, next_attempt_at);
@@ -0,0 +1,2 @@
+ALTER TABLE users ALTER COLUMN email DROP DEFAULT;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
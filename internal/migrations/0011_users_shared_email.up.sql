@@ -0,0 +1,4 @@
+-- users provisioned from tokens of different issuers may share an email, and
+-- service accounts have none
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+ALTER TABLE users ALTER COLUMN email SET DEFAULT '';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS batch_job_items;
+DROP TABLE IF EXISTS batch_jobs;
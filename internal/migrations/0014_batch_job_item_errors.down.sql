@@ -0,0 +1,3 @@
+ALTER TABLE batch_job_items DROP COLUMN IF EXISTS retryable;
+ALTER TABLE batch_job_items DROP COLUMN IF EXISTS error_message;
+ALTER TABLE batch_job_items DROP COLUMN IF EXISTS error_code;
@@ -0,0 +1,4 @@
+-- Why a batch item failed, and whether submitting it again may succeed
+ALTER TABLE batch_job_items ADD COLUMN IF NOT EXISTS error_code VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE batch_job_items ADD COLUMN IF NOT EXISTS error_message TEXT NOT NULL DEFAULT '';
+ALTER TABLE batch_job_items ADD COLUMN IF NOT EXISTS retryable BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+-- atomic jobs apply all their updates in a single transaction, or none of them
+ALTER TABLE batch_jobs ADD COLUMN IF NOT EXISTS atomic BOOLEAN NOT NULL DEFAULT false;
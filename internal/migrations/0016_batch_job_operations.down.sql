@@ -0,0 +1,5 @@
+ALTER TABLE batch_job_items DROP COLUMN IF EXISTS expiry_year;
+ALTER TABLE batch_job_items DROP COLUMN IF EXISTS expiry_month;
+ALTER TABLE batch_job_items DROP COLUMN IF EXISTS key_version;
+ALTER TABLE batch_job_items DROP COLUMN IF EXISTS pan;
+ALTER TABLE batch_jobs DROP COLUMN IF EXISTS operation;
@@ -0,0 +1,7 @@
+-- batch jobs also create and delete cards. The PAN ciphertext of a create is
+-- kept until the item is processed, the card ID is only known afterwards.
+ALTER TABLE batch_jobs ADD COLUMN IF NOT EXISTS operation VARCHAR(10) NOT NULL DEFAULT 'update';
+ALTER TABLE batch_job_items ADD COLUMN IF NOT EXISTS pan TEXT NOT NULL DEFAULT '';
+ALTER TABLE batch_job_items ADD COLUMN IF NOT EXISTS key_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE batch_job_items ADD COLUMN IF NOT EXISTS expiry_month INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE batch_job_items ADD COLUMN IF NOT EXISTS expiry_year INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_rewrap_jobs_status;
+CREATE INDEX IF NOT EXISTS idx_rewrap_jobs_status ON rewrap_jobs (status);
+
+ALTER TABLE rewrap_jobs DROP COLUMN IF EXISTS locked_until;
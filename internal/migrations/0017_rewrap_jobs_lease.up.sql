@@ -0,0 +1,5 @@
+-- rewrap jobs are leased by the instance processing them, like batch jobs
+ALTER TABLE rewrap_jobs ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
+
+DROP INDEX IF EXISTS idx_rewrap_jobs_status;
+CREATE INDEX IF NOT EXISTS idx_rewrap_jobs_status ON rewrap_jobs (status, created_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_cards_user_fingerprint_unique;
+
+ALTER TABLE cards DROP COLUMN IF EXISTS deduplicated;
@@ -0,0 +1,7 @@
+-- Cards created under a policy refusing the same PAN twice are deduplicated, at
+-- most one live card of a user can hold a given fingerprint among them. Cards
+-- created under the allow policy are left out so they may repeat.
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS deduplicated BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cards_user_fingerprint_unique ON cards (user_id, fingerprint)
+    WHERE deduplicated AND deleted_at IS NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_secret_operations_card;
+
+ALTER TABLE secret_operations DROP COLUMN IF EXISTS locked_until;
+ALTER TABLE secret_operations DROP COLUMN IF EXISTS sequence;
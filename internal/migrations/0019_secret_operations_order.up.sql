@@ -0,0 +1,6 @@
+-- operations of a card are applied one at a time, in the order they were
+-- recorded, by the instance holding their lease
+ALTER TABLE secret_operations ADD COLUMN IF NOT EXISTS sequence BIGSERIAL;
+ALTER TABLE secret_operations ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_secret_operations_card ON secret_operations (card_id, status, sequence);
@@ -0,0 +1,4 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_issuer_user_id_key;
+ALTER TABLE users ADD CONSTRAINT users_user_id_key UNIQUE (user_id);
+
+ALTER TABLE users DROP COLUMN IF EXISTS issuer;
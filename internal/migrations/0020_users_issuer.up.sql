@@ -0,0 +1,16 @@
+-- several issuers are trusted and each one picks its own subjects, so a user is
+-- identified by the issuer and the sub of its tokens. Existing users belong to
+-- the issuer `migrate up` passes in yuno.users_issuer, the primary one.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM users) AND COALESCE(current_setting('yuno.users_issuer', true), '') = '' THEN
+        RAISE EXCEPTION 'users exist but no issuer was given for them, configure the trusted issuers before migrating';
+    END IF;
+END $$;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS issuer VARCHAR(255) NOT NULL DEFAULT '';
+UPDATE users SET issuer = current_setting('yuno.users_issuer', true) WHERE issuer = '';
+ALTER TABLE users ALTER COLUMN issuer DROP DEFAULT;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_user_id_key;
+ALTER TABLE users ADD CONSTRAINT users_issuer_user_id_key UNIQUE (issuer, user_id);
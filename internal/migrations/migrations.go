@@ -0,0 +1,20 @@
+// Package migrations holds the versioned schema of the database, embedded in the binaries.
+package migrations
+
+import (
+	"embed"
+
+	"github.com/juaguz/yuno/kit/database"
+)
+
+// UsersIssuerSetting is the setting migrations read the issuer of the users
+// created before users were identified by issuer from.
+const UsersIssuerSetting = "yuno.users_issuer"
+
+//go:embed *.sql
+var files embed.FS
+
+// All returns the migrations sorted by version.
+func All() ([]database.Migration, error) {
+	return database.LoadMigrations(files)
+}
@@ -0,0 +1,43 @@
+package migrations_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/juaguz/yuno/internal/migrations"
+	"github.com/juaguz/yuno/kit/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAll(t *testing.T) {
+	all, err := migrations.All()
+	require.NoError(t, err)
+	require.NotEmpty(t, all)
+
+	// versions are consecutive so a missing or duplicated file is noticed
+	for i, m := range all {
+		assert.Equal(t, i+1, m.Version, m.Name)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_second.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+		"0002_second.down.sql": {Data: []byte("DROP TABLE b;")},
+		"0001_first.up.sql":    {Data: []byte("CREATE TABLE a ();")},
+		"0001_first.down.sql":  {Data: []byte("DROP TABLE a;")},
+		"README.md":            {Data: []byte("ignored")},
+	}
+
+	all, err := database.LoadMigrations(fsys)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, database.Migration{Version: 1, Name: "first", Up: "CREATE TABLE a ();", Down: "DROP TABLE a;"}, all[0])
+	assert.Equal(t, "second", all[1].Name)
+
+	_, err = database.LoadMigrations(fstest.MapFS{
+		"0001_first.up.sql": {Data: []byte("CREATE TABLE a ();")},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,267 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/kit/audit"
+)
+
+const (
+	relayAction = "cards.relay"
+	// maxResponseSize caps the upstream body kept in memory
+	maxResponseSize = 1 << 20
+)
+
+var (
+	ErrHostNotAllowed     = errors.New("upstream host is not allowed")
+	ErrInvalidRequest     = errors.New("invalid relay request")
+	ErrUnknownPlaceholder = errors.New("unknown placeholder")
+	ErrUpstream           = errors.New("upstream request failed")
+)
+
+var placeholderRegex = regexp.MustCompile(`{{\s*([a-zA-Z0-9_.]+)\s*}}`)
+
+type Detokenizer interface {
+	Detokenize(ctx context.Context, card *dtos.Card) (*dtos.RevealedCard, error)
+}
+
+type AuditRepository interface {
+	Record(ctx context.Context, event *audit.Event) error
+}
+
+type Request struct {
+	UserID  uuid.UUID
+	CardID  uuid.UUID
+	URL     string
+	Method  string
+	Headers map[string]string
+	Body    string
+}
+
+type Response struct {
+	StatusCode int                 `json:"status_code"`
+	Headers    map[string][]string `json:"headers"`
+	Body       string              `json:"body"`
+}
+
+// hopByHopHeaders only apply to the connection with the upstream. Cookies are
+// dropped too, they are set for yuno and not for the caller.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Set-Cookie",
+	"Set-Cookie2",
+}
+
+// Relay forwards requests to allow-listed upstreams replacing the card
+// placeholders of the body and headers with the values stored in Vault, so
+// callers never handle the PAN themselves. Upstreams are called over https,
+// plain http is only accepted for the hosts in PlainHTTPHosts.
+type Relay struct {
+	Detokenizer     Detokenizer
+	AuditRepository AuditRepository
+	Client          *http.Client
+	AllowedHosts    map[string]struct{}
+	PlainHTTPHosts  map[string]struct{}
+}
+
+type Option func(*Relay)
+
+// WithPlainHTTPHosts lets the relay reach hosts over plain http, which sends the
+// PAN in cleartext. Meant for sandboxes, the hosts must still be allow-listed.
+func WithPlainHTTPHosts(hosts []string) Option {
+	return func(r *Relay) {
+		r.PlainHTTPHosts = hostSet(hosts)
+	}
+}
+
+func NewRelay(detokenizer Detokenizer, auditRepository AuditRepository, allowedHosts []string, timeout time.Duration, options ...Option) *Relay {
+	r := &Relay{
+		Detokenizer:     detokenizer,
+		AuditRepository: auditRepository,
+		Client: &http.Client{
+			Timeout: timeout,
+			// following a redirect could send the PAN to a host outside the allow-list
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		AllowedHosts: hostSet(allowedHosts),
+	}
+	for _, option := range options {
+		option(r)
+	}
+
+	return r
+}
+
+func (r *Relay) Forward(ctx context.Context, req *Request) (*Response, error) {
+	target, err := r.validate(req)
+	if err != nil {
+		return nil, err
+	}
+
+	card, err := r.Detokenizer.Detokenize(ctx, &dtos.Card{ID: req.CardID, UserId: req.UserID})
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := render(req.Body, card)
+	if err != nil {
+		return nil, err
+	}
+
+	upstreamReq, err := http.NewRequestWithContext(ctx, strings.ToUpper(req.Method), target.String(), strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRequest, err)
+	}
+
+	for name, value := range req.Headers {
+		rendered, err := render(value, card)
+		if err != nil {
+			return nil, err
+		}
+		upstreamReq.Header.Set(name, rendered)
+	}
+
+	resp, err := r.Client.Do(upstreamReq)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", ErrUpstream, err)
+		r.record(ctx, req, audit.Failed, fmt.Sprintf("%s: %s", target.Host, err))
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	r.record(ctx, req, audit.Allowed, fmt.Sprintf("%s: %d", target.Host, resp.StatusCode))
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUpstream, err)
+	}
+
+	return &Response{
+		StatusCode: resp.StatusCode,
+		Headers:    responseHeaders(resp.Header),
+		Body:       string(respBody),
+	}, nil
+}
+
+// responseHeaders returns the headers of the upstream response meant for the
+// caller, with all their values.
+func responseHeaders(upstream http.Header) map[string][]string {
+	header := upstream.Clone()
+	// headers named in Connection are hop-by-hop as well
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			header.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+
+	return header
+}
+
+// record writes the outcome of the upstream call to the audit trail, even when
+// the caller went away. The PAN may already have left, so a failure to record
+// doesn't fail the relayed request, which the caller could retry.
+func (r *Relay) record(ctx context.Context, req *Request, outcome audit.Outcome, reason string) {
+	event := &audit.Event{
+		UserID:     req.UserID,
+		Action:     relayAction,
+		ResourceID: req.CardID.String(),
+		Outcome:    outcome,
+		Reason:     reason,
+	}
+	if err := r.AuditRepository.Record(context.WithoutCancel(ctx), event); err != nil {
+		log.Printf("relay: recording audit event of card %s: %s", req.CardID, err)
+	}
+}
+
+func (r *Relay) validate(req *Request) (*url.URL, error) {
+	target, err := url.Parse(req.URL)
+	if err != nil || target.Host == "" {
+		return nil, fmt.Errorf("%w: invalid url", ErrInvalidRequest)
+	}
+
+	if target.Scheme != "https" && target.Scheme != "http" {
+		return nil, fmt.Errorf("%w: unsupported scheme %q", ErrInvalidRequest, target.Scheme)
+	}
+	if target.Scheme == "http" && !matchHost(r.PlainHTTPHosts, target) {
+		return nil, fmt.Errorf("%w: plain http to %s", ErrHostNotAllowed, target.Host)
+	}
+
+	if target.User != nil {
+		return nil, fmt.Errorf("%w: credentials in url", ErrInvalidRequest)
+	}
+
+	if req.Method == "" {
+		return nil, fmt.Errorf("%w: missing method", ErrInvalidRequest)
+	}
+
+	if !matchHost(r.AllowedHosts, target) {
+		return nil, fmt.Errorf("%w: %s", ErrHostNotAllowed, target.Host)
+	}
+
+	return target, nil
+}
+
+func hostSet(hosts []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(hosts))
+	for _, host := range hosts {
+		set[strings.ToLower(strings.TrimSpace(host))] = struct{}{}
+	}
+
+	return set
+}
+
+// matchHost reports whether target is in hosts, both "host" and "host:port"
+// entries are accepted.
+func matchHost(hosts map[string]struct{}, target *url.URL) bool {
+	_, hostAllowed := hosts[strings.ToLower(target.Hostname())]
+	_, hostPortAllowed := hosts[strings.ToLower(target.Host)]
+
+	return hostAllowed || hostPortAllowed
+}
+
+// render replaces the {{card.*}} placeholders of template.
+func render(template string, card *dtos.RevealedCard) (string, error) {
+	var renderErr error
+
+	rendered := placeholderRegex.ReplaceAllStringFunc(template, func(match string) string {
+		name := placeholderRegex.FindStringSubmatch(match)[1]
+		switch name {
+		case "card.pan":
+			return card.Pan
+		case "card.holder":
+			return card.CardHolder
+		default:
+			renderErr = fmt.Errorf("%w: %s", ErrUnknownPlaceholder, name)
+			return match
+		}
+	})
+	if renderErr != nil {
+		return "", renderErr
+	}
+
+	return rendered, nil
+}
@@ -0,0 +1,168 @@
+package relay_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/internal/relay"
+	"github.com/juaguz/yuno/kit/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDetokenizer struct {
+	card *dtos.RevealedCard
+}
+
+func (f *fakeDetokenizer) Detokenize(ctx context.Context, card *dtos.Card) (*dtos.RevealedCard, error) {
+	return f.card, nil
+}
+
+type fakeAudit struct {
+	events []*audit.Event
+}
+
+func (f *fakeAudit) Record(ctx context.Context, event *audit.Event) error {
+	f.events = append(f.events, event)
+	return nil
+}
+
+func TestRelay_Forward(t *testing.T) {
+	var gotBody, gotHeader string
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		gotHeader = r.Header.Get("X-Holder")
+		w.Header().Add("X-Request-Id", "42")
+		w.Header().Add("Link", "<https://payments.example.com/1>")
+		w.Header().Add("Link", "<https://payments.example.com/2>")
+		w.Header().Set("Set-Cookie", "session=upstream")
+		w.Header().Set("Connection", "X-Upstream-Hop")
+		w.Header().Set("X-Upstream-Hop", "1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	u, _ := url.Parse(upstream.URL)
+	detokenizer := &fakeDetokenizer{card: &dtos.RevealedCard{Pan: "4111111111111111", CardHolder: "John Doe"}}
+	auditRepo := &fakeAudit{}
+	r := relay.NewRelay(detokenizer, auditRepo, []string{u.Host}, time.Second)
+	r.Client.Transport = upstream.Client().Transport
+
+	res, err := r.Forward(context.Background(), &relay.Request{
+		UserID:  uuid.New(),
+		CardID:  uuid.New(),
+		URL:     upstream.URL + "/payments",
+		Method:  http.MethodPost,
+		Headers: map[string]string{"X-Holder": "{{ card.holder }}"},
+		Body:    `{"number":"{{card.pan}}"}`,
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, `{"ok":true}`, res.Body)
+	assert.Equal(t, `{"number":"4111111111111111"}`, gotBody)
+	assert.Equal(t, "John Doe", gotHeader)
+	assert.Equal(t, []string{"42"}, res.Headers["X-Request-Id"])
+	assert.Equal(t, []string{"<https://payments.example.com/1>", "<https://payments.example.com/2>"}, res.Headers["Link"])
+	// cookies and hop-by-hop headers stay between yuno and the upstream
+	assert.NotContains(t, res.Headers, "Set-Cookie")
+	assert.NotContains(t, res.Headers, "Connection")
+	assert.NotContains(t, res.Headers, "X-Upstream-Hop")
+	require.Len(t, auditRepo.events, 1)
+	assert.Equal(t, audit.Allowed, auditRepo.events[0].Outcome)
+	assert.Equal(t, u.Host+": 201", auditRepo.events[0].Reason)
+}
+
+func TestRelay_Forward_UpstreamFailure(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.NotFoundHandler())
+	u, _ := url.Parse(upstream.URL)
+	upstream.Close()
+
+	detokenizer := &fakeDetokenizer{card: &dtos.RevealedCard{Pan: "4111111111111111"}}
+	auditRepo := &fakeAudit{}
+	r := relay.NewRelay(detokenizer, auditRepo, []string{u.Host}, time.Second)
+
+	_, err := r.Forward(context.Background(), &relay.Request{URL: upstream.URL, Method: http.MethodPost, Body: "{{card.pan}}"})
+
+	// the trail tells the PAN may not have left
+	assert.ErrorIs(t, err, relay.ErrUpstream)
+	require.Len(t, auditRepo.events, 1)
+	assert.Equal(t, audit.Failed, auditRepo.events[0].Outcome)
+	assert.Contains(t, auditRepo.events[0].Reason, "upstream request failed")
+}
+
+func TestRelay_Forward_Rejected(t *testing.T) {
+	detokenizer := &fakeDetokenizer{card: &dtos.RevealedCard{Pan: "4111111111111111"}}
+	r := relay.NewRelay(detokenizer, &fakeAudit{}, []string{"payments.example.com"}, time.Second)
+
+	tests := []struct {
+		name string
+		req  *relay.Request
+		err  error
+	}{
+		{
+			name: "host not allowed",
+			req:  &relay.Request{URL: "https://evil.example.com", Method: http.MethodPost},
+			err:  relay.ErrHostNotAllowed,
+		},
+		{
+			name: "plain http",
+			req:  &relay.Request{URL: "http://payments.example.com", Method: http.MethodPost},
+			err:  relay.ErrHostNotAllowed,
+		},
+		{
+			name: "unsupported scheme",
+			req:  &relay.Request{URL: "ftp://payments.example.com", Method: http.MethodPost},
+			err:  relay.ErrInvalidRequest,
+		},
+		{
+			name: "unknown placeholder",
+			req:  &relay.Request{URL: "https://payments.example.com", Method: http.MethodPost, Body: "{{card.cvv}}"},
+			err:  relay.ErrUnknownPlaceholder,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := r.Forward(context.Background(), tt.req)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestRelay_Forward_PlainHTTP(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	u, _ := url.Parse(upstream.URL)
+	detokenizer := &fakeDetokenizer{card: &dtos.RevealedCard{Pan: "4111111111111111"}}
+	auditRepo := &fakeAudit{}
+	req := &relay.Request{URL: upstream.URL, Method: http.MethodPost, Body: "{{card.pan}}"}
+
+	// the PAN isn't sent in cleartext unless the host opted in
+	r := relay.NewRelay(detokenizer, auditRepo, []string{u.Host}, time.Second)
+	_, err := r.Forward(context.Background(), req)
+	assert.ErrorIs(t, err, relay.ErrHostNotAllowed)
+	assert.Empty(t, auditRepo.events)
+
+	r = relay.NewRelay(detokenizer, auditRepo, []string{u.Host}, time.Second, relay.WithPlainHTTPHosts([]string{u.Host}))
+	res, err := r.Forward(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// opting in doesn't allow-list the host
+	r = relay.NewRelay(detokenizer, auditRepo, nil, time.Second, relay.WithPlainHTTPHosts([]string{u.Host}))
+	_, err = r.Forward(context.Background(), req)
+	assert.ErrorIs(t, err, relay.ErrHostNotAllowed)
+}
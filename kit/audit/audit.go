@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Outcome string
+
+const (
+	Allowed Outcome = "allowed"
+	Denied  Outcome = "denied"
+	Failed  Outcome = "failed"
+)
+
+// Event is an append only record of a sensitive operation.
+type Event struct {
+	ID         uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4()"`
+	CreatedAt  time.Time
+	UserID     uuid.UUID
+	Action     string
+	ResourceID string
+	Outcome    Outcome
+	Reason     string
+}
+
+func (Event) TableName() string {
+	return "audit_events"
+}
+
+type Repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) *Repository {
+	return &Repository{db: db}
+}
+
+// Record stores the event. It doesn't join the transaction in ctx on purpose,
+// so the trail is kept even when the audited operation is rolled back.
+func (r *Repository) Record(ctx context.Context, event *Event) error {
+	return r.db.WithContext(ctx).Create(event).Error
+}
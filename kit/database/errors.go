@@ -0,0 +1,26 @@
+package database
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/juaguz/yuno/kit/errors/senital"
+)
+
+// Unavailable wraps err with senital.ErrUnavailable when the statement failed
+// because the database couldn't be reached, so callers can tell an outage from a
+// statement the database rejected.
+func Unavailable(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) || errors.Is(err, driver.ErrBadConn) || pgconn.SafeToRetry(err) {
+		return fmt.Errorf("%w: %w", senital.ErrUnavailable, err)
+	}
+
+	return err
+}
@@ -8,17 +8,39 @@ import (
 
 type contextKey string
 
-const txKey = contextKey("tx")
+const (
+	txKey    = contextKey("tx")
+	hooksKey = contextKey("hooks")
+)
 
 func SetTx(ctx context.Context, tx *gorm.DB) context.Context {
 	return context.WithValue(ctx, txKey, tx)
 }
 
+// GetTx returns the transaction in ctx, or db without one, bound to ctx so its
+// queries are canceled along with the request.
 func GetTx(ctx context.Context, db *gorm.DB) *gorm.DB {
 	if tx, ok := ctx.Value(txKey).(*gorm.DB); ok {
-		return tx
+		return tx.WithContext(ctx)
 	}
-	return db
+	return db.WithContext(ctx)
+}
+
+type commitHooks struct {
+	fns []func(ctx context.Context)
+}
+
+// AfterCommit registers fn to run once the transaction in ctx is committed, it is
+// dropped on rollback. Without a transaction the writes are already committed and
+// fn runs right away.
+func AfterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	hooks, ok := ctx.Value(hooksKey).(*commitHooks)
+	if !ok {
+		fn(ctx)
+		return
+	}
+
+	hooks.fns = append(hooks.fns, fn)
 }
 
 type Service[T any] interface {
@@ -28,53 +50,76 @@ type Service[T any] interface {
 	Get(ctx context.Context, entity *T) (*T, error)
 }
 
+// Transactor runs functions in a transaction, the repositories called with the
+// context it passes write through that transaction.
+type Transactor struct {
+	db *gorm.DB
+}
+
+func NewTransactor(db *gorm.DB) *Transactor {
+	return &Transactor{db: db}
+}
+
+// InTx runs fn in a transaction, rolled back when fn fails, and once committed
+// the hooks registered with AfterCommit.
+func (t *Transactor) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx := t.db.WithContext(ctx).Begin()
+	hooks := &commitHooks{}
+	ctxWithTx := context.WithValue(SetTx(ctx, tx), hooksKey, hooks)
+
+	if err := fn(ctxWithTx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	for _, hook := range hooks.fns {
+		hook(ctx)
+	}
+
+	return nil
+}
+
 type TransactionalService[T any] struct {
-	db        *gorm.DB
-	decorated Service[T]
+	transactor *Transactor
+	decorated  Service[T]
 }
 
 // NewTransactionalRepository constructor for the transactional decorator
 func NewTransactionalRepository[T any](db *gorm.DB, decorated Service[T]) *TransactionalService[T] {
 	return &TransactionalService[T]{
-		db:        db,
-		decorated: decorated,
+		transactor: NewTransactor(db),
+		decorated:  decorated,
 	}
 }
 
 func (t *TransactionalService[T]) Create(ctx context.Context, entity *T) (*T, error) {
-	tx := t.db.Begin()
-	ctxWithTx := SetTx(ctx, tx)
-
-	res, err := t.decorated.Create(ctxWithTx, entity)
+	var res *T
+	err := t.transactor.InTx(ctx, func(ctxWithTx context.Context) error {
+		var err error
+		res, err = t.decorated.Create(ctxWithTx, entity)
+		return err
+	})
 	if err != nil {
-		tx.Rollback()
 		return nil, err
 	}
 
-	return res, tx.Commit().Error
-
+	return res, nil
 }
 
 func (t *TransactionalService[T]) Update(ctx context.Context, entity *T) error {
-	tx := t.db.Begin()
-	ctxWithTx := SetTx(ctx, tx)
-	err := t.decorated.Update(ctxWithTx, entity)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	return tx.Commit().Error
+	return t.transactor.InTx(ctx, func(ctxWithTx context.Context) error {
+		return t.decorated.Update(ctxWithTx, entity)
+	})
 }
 
 func (t *TransactionalService[T]) Delete(ctx context.Context, entity *T) error {
-	tx := t.db.Begin()
-	ctxWithTx := SetTx(ctx, tx)
-	err := t.decorated.Delete(ctxWithTx, entity)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	return tx.Commit().Error
+	return t.transactor.InTx(ctx, func(ctxWithTx context.Context) error {
+		return t.decorated.Delete(ctxWithTx, entity)
+	})
 }
 
 func (t *TransactionalService[T]) Get(ctx context.Context, entity *T) (*T, error) {
@@ -0,0 +1,137 @@
+// Package problem writes errors as RFC 7807 problem details.
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const ContentType = "application/problem+json"
+
+// Problem is the body of an error response. Extensions are added as top level
+// members, e.g. the reason a PAN was rejected.
+type Problem struct {
+	Type       string                 `json:"type"`
+	Title      string                 `json:"title"`
+	Status     int                    `json:"status"`
+	Detail     string                 `json:"detail,omitempty"`
+	Instance   string                 `json:"instance,omitempty"`
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// New returns a problem of the generic about:blank type.
+func New(status int, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+
+	members["type"] = p.Type
+	members["title"] = p.Title
+	members["status"] = p.Status
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+
+	return json.Marshal(members)
+}
+
+// Write answers the request with p, its path as instance.
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Instance == "" && r != nil {
+		p.Instance = r.URL.Path
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// Error answers the request with a problem of status and detail.
+func Error(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	Write(w, r, New(status, detail))
+}
+
+// Mapping turns the errors matching Err into a problem of Status. The detail is
+// Detail, or the message of the error when Expose is set, which is only safe for
+// errors written for clients. Extensions adds members from the error, and
+// RetryAfter, when set, tells clients when to try again.
+type Mapping struct {
+	Err        error
+	Status     int
+	Detail     string
+	Expose     bool
+	Extensions func(err error) map[string]interface{}
+	RetryAfter time.Duration
+}
+
+// Mapper turns errors into problems with the first mapping they match. Errors
+// matching none are 500s, logged but never shown to the client.
+type Mapper struct {
+	mappings []Mapping
+}
+
+func NewMapper(mappings ...Mapping) *Mapper {
+	return &Mapper{mappings: mappings}
+}
+
+func (m *Mapper) Problem(err error) *Problem {
+	mapping := m.match(err)
+	if mapping == nil {
+		return New(http.StatusInternalServerError, "")
+	}
+
+	p := New(mapping.Status, mapping.Detail)
+	if mapping.Expose {
+		p.Detail = err.Error()
+	}
+	if mapping.Extensions != nil {
+		p.Extensions = mapping.Extensions(err)
+	}
+
+	return p
+}
+
+func (m *Mapper) match(err error) *Mapping {
+	for i := range m.mappings {
+		if errors.Is(err, m.mappings[i].Err) {
+			return &m.mappings[i]
+		}
+	}
+
+	return nil
+}
+
+// Write answers the request with the problem of err.
+func (m *Mapper) Write(w http.ResponseWriter, r *http.Request, err error) {
+	if mapping := m.match(err); mapping != nil && mapping.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(mapping.RetryAfter.Seconds())))
+	}
+
+	p := m.Problem(err)
+	if p.Status >= http.StatusInternalServerError {
+		path := ""
+		if r != nil {
+			path = r.Method + " " + r.URL.Path
+		}
+		log.Printf("%s: %d: %s", path, p.Status, err)
+	}
+
+	Write(w, r, p)
+}
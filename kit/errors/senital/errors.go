@@ -3,5 +3,11 @@ package senital
 import "errors"
 
 var (
-	ErrNotFound = errors.New("not found")
+	ErrNotFound        = errors.New("not found")
+	ErrForbidden       = errors.New("forbidden")
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrConflict is a write rejected by a uniqueness constraint
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable wraps the failures of a service the request depends on, like Vault
+	ErrUnavailable = errors.New("service unavailable")
 )
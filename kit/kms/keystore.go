@@ -0,0 +1,157 @@
+package kms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FileKeyStore keeps one wrapped keyring per file inside dir. Updates are only
+// serialized within the process, the dir must not be shared between instances.
+type FileKeyStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileKeyStore(dir string) (*FileKeyStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating key store dir: %w", err)
+	}
+
+	return &FileKeyStore{dir: dir}, nil
+}
+
+func (f *FileKeyStore) Update(ctx context.Context, keyID string, update UpdateFunc) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	current, err := f.Load(ctx, keyID)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return err
+	}
+
+	data, err := update(current)
+	if err != nil || data == nil {
+		return err
+	}
+
+	return f.save(keyID, data)
+}
+
+func (f *FileKeyStore) save(keyID string, data []byte) error {
+	path := f.path(keyID)
+	tmp := path + ".tmp"
+
+	// write and rename so a crash never leaves a truncated keyring behind
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("saving key %s: %w", keyID, err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("saving key %s: %w", keyID, err)
+	}
+
+	return nil
+}
+
+func (f *FileKeyStore) Load(ctx context.Context, keyID string) ([]byte, error) {
+	data, err := os.ReadFile(f.path(keyID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading key %s: %w", keyID, err)
+	}
+
+	return data, nil
+}
+
+func (f *FileKeyStore) path(keyID string) string {
+	return filepath.Join(f.dir, filepath.Base(keyID)+".key")
+}
+
+type KmsKey struct {
+	KeyID     string `gorm:"primaryKey"`
+	Data      []byte
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// DBKeyStore keeps the wrapped keyrings in the kms_keys table. It can be shared
+// by several instances, an update holds the row lock of its key until it's saved.
+type DBKeyStore struct {
+	db *gorm.DB
+}
+
+func NewDBKeyStore(db *gorm.DB) *DBKeyStore {
+	return &DBKeyStore{db: db}
+}
+
+func (d *DBKeyStore) Update(ctx context.Context, keyID string, update UpdateFunc) error {
+	for {
+		err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return d.update(tx, keyID, update)
+		})
+		// a key created meanwhile by another instance is updated again, now locked
+		if !errors.Is(err, errKeyCreatedConcurrently) {
+			return err
+		}
+	}
+}
+
+func (d *DBKeyStore) update(tx *gorm.DB, keyID string, update UpdateFunc) error {
+	var key KmsKey
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&key, "key_id = ?", keyID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		data, err := update(nil)
+		if err != nil || data == nil {
+			return err
+		}
+
+		// there is no row to lock yet, the first insert wins
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&KmsKey{KeyID: keyID, Data: data})
+		if result.Error != nil {
+			return fmt.Errorf("saving key %s: %w", keyID, result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return errKeyCreatedConcurrently
+		}
+
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("loading key %s: %w", keyID, err)
+	}
+
+	data, err := update(key.Data)
+	if err != nil || data == nil {
+		return err
+	}
+
+	err = tx.Model(&key).Updates(map[string]interface{}{"data": data, "updated_at": time.Now()}).Error
+	if err != nil {
+		return fmt.Errorf("saving key %s: %w", keyID, err)
+	}
+
+	return nil
+}
+
+func (d *DBKeyStore) Load(ctx context.Context, keyID string) ([]byte, error) {
+	var key KmsKey
+	err := d.db.WithContext(ctx).First(&key, "key_id = ?", keyID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading key %s: %w", keyID, err)
+	}
+
+	return key.Data, nil
+}
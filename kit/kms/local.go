@@ -0,0 +1,383 @@
+package kms
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	ciphertextPrefix = "vault"
+	localKeyBits     = 2048
+	hmacKeyBytes     = 32
+)
+
+var (
+	ErrKeyNotFound       = errors.New("key not found")
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+	ErrInvalidMasterKey  = errors.New("master key must be 32 bytes")
+	// ErrExportableKey is an HMAC key that can leave the KMS
+	ErrExportableKey = errors.New("key is exportable")
+
+	errKeyCreatedConcurrently = errors.New("key created concurrently")
+)
+
+// UpdateFunc returns the new data of a key from its current data, nil when the
+// key doesn't exist yet. Returning nil data leaves the key untouched.
+type UpdateFunc func(current []byte) ([]byte, error)
+
+// KeyStore persists the wrapped keyring of every key handled by LocalKmsService.
+// Load must return ErrKeyNotFound when nothing was saved under keyID. Update must
+// not run concurrently with another Update of the same key, including from other
+// instances sharing the store, or a key version could be lost.
+type KeyStore interface {
+	Update(ctx context.Context, keyID string, update UpdateFunc) error
+	Load(ctx context.Context, keyID string) ([]byte, error)
+}
+
+// keyring holds every version of a key, mirroring Vault's transit key layout where
+// each version also has its own HMAC key.
+type keyring struct {
+	LatestVersion int            `json:"latest_version"`
+	Keys          map[int][]byte `json:"keys"`
+	HMACKeys      map[int][]byte `json:"hmac_keys,omitempty"`
+}
+
+// LocalKmsService is a pure Go replacement of VaultKmsService. Each key is a set of
+// RSA-2048 versions used with OAEP/SHA-256, sealed with an AES-GCM master key before
+// reaching the KeyStore. Ciphertexts follow the transit format "vault:v<version>:<base64>".
+type LocalKmsService struct {
+	store  KeyStore
+	master cipher.AEAD
+}
+
+func NewLocalKmsService(store KeyStore, masterKey []byte) (*LocalKmsService, error) {
+	if len(masterKey) != 32 {
+		return nil, ErrInvalidMasterKey
+	}
+
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("creating master cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating master cipher: %w", err)
+	}
+
+	return &LocalKmsService{store: store, master: aead}, nil
+}
+
+func (l *LocalKmsService) CreateKey(ctx context.Context, keyID string) error {
+	return l.update(ctx, keyID, func(ring *keyring) (*keyring, error) {
+		if ring != nil {
+			// same as Vault: creating an existing key is a no-op
+			return nil, nil
+		}
+
+		priv, err := rsa.GenerateKey(rand.Reader, localKeyBits)
+		if err != nil {
+			return nil, fmt.Errorf("creating keys: %w", err)
+		}
+
+		ring = &keyring{
+			LatestVersion: 1,
+			Keys:          map[int][]byte{1: x509.MarshalPKCS1PrivateKey(priv)},
+		}
+		if err := ring.addHMACKey(1); err != nil {
+			return nil, fmt.Errorf("creating keys: %w", err)
+		}
+
+		return ring, nil
+	})
+}
+
+// CreateHMACKey creates a key used to compute HMACs. Local keyrings are never
+// exported, so it's the same as CreateKey.
+func (l *LocalKmsService) CreateHMACKey(ctx context.Context, keyID string) error {
+	return l.CreateKey(ctx, keyID)
+}
+
+// RotateKey adds a new version to the keyring. Older versions are kept so
+// ciphertexts produced with them can still be decrypted.
+func (l *LocalKmsService) RotateKey(ctx context.Context, keyID string) error {
+	return l.update(ctx, keyID, func(ring *keyring) (*keyring, error) {
+		if ring == nil {
+			return nil, fmt.Errorf("rotating key: %w", ErrKeyNotFound)
+		}
+
+		priv, err := rsa.GenerateKey(rand.Reader, localKeyBits)
+		if err != nil {
+			return nil, fmt.Errorf("rotating key: %w", err)
+		}
+
+		ring.LatestVersion++
+		ring.Keys[ring.LatestVersion] = x509.MarshalPKCS1PrivateKey(priv)
+		if err := ring.addHMACKey(ring.LatestVersion); err != nil {
+			return nil, fmt.Errorf("rotating key: %w", err)
+		}
+
+		return ring, nil
+	})
+}
+
+// GetPublicKey returns the public key of the latest version along with the version number.
+func (l *LocalKmsService) GetPublicKey(ctx context.Context, keyID string) (string, int, error) {
+	ring, err := l.load(ctx, keyID)
+	if err != nil {
+		return "", 0, fmt.Errorf("getting public key: %w", err)
+	}
+
+	priv, err := ring.privateKey(ring.LatestVersion)
+	if err != nil {
+		return "", 0, fmt.Errorf("getting public key: %w", err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		return "", 0, fmt.Errorf("getting public key: %w", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), ring.LatestVersion, nil
+}
+
+// Decrypt returns the plaintext base64 encoded, like Vault's transit/decrypt does.
+func (l *LocalKmsService) Decrypt(ctx context.Context, encryptedData, keyID string) (string, error) {
+	version, raw, err := parseCiphertext(encryptedData)
+	if err != nil {
+		return "", err
+	}
+
+	ring, err := l.load(ctx, keyID)
+	if err != nil {
+		return "", fmt.Errorf("decrypting data: %w", err)
+	}
+
+	priv, err := ring.privateKey(version)
+	if err != nil {
+		return "", fmt.Errorf("decrypting data: %w", err)
+	}
+
+	plaintext, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, raw, nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypting data: %w", ErrInvalidCiphertext)
+	}
+
+	return base64.StdEncoding.EncodeToString(plaintext), nil
+}
+
+// Rewrap re-encrypts ciphertext with the latest version of the key.
+func (l *LocalKmsService) Rewrap(ctx context.Context, ciphertext, keyID string) (string, error) {
+	version, raw, err := parseCiphertext(ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	ring, err := l.load(ctx, keyID)
+	if err != nil {
+		return "", fmt.Errorf("rewrapping data: %w", err)
+	}
+
+	if version == ring.LatestVersion {
+		return ciphertext, nil
+	}
+
+	priv, err := ring.privateKey(version)
+	if err != nil {
+		return "", fmt.Errorf("rewrapping data: %w", err)
+	}
+
+	plaintext, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, raw, nil)
+	if err != nil {
+		return "", fmt.Errorf("rewrapping data: %w", ErrInvalidCiphertext)
+	}
+
+	latest, err := ring.privateKey(ring.LatestVersion)
+	if err != nil {
+		return "", fmt.Errorf("rewrapping data: %w", err)
+	}
+
+	rewrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &latest.PublicKey, plaintext, nil)
+	if err != nil {
+		return "", fmt.Errorf("rewrapping data: %w", err)
+	}
+
+	return FormatCiphertext(ring.LatestVersion, base64.StdEncoding.EncodeToString(rewrapped)), nil
+}
+
+// HMAC returns the base64 HMAC-SHA256 of the base64 encoded data computed with the
+// given version of the key, like Vault's transit/hmac does.
+func (l *LocalKmsService) HMAC(ctx context.Context, data, keyID string, version int) (string, error) {
+	input, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("computing hmac: %w", err)
+	}
+
+	ring, err := l.load(ctx, keyID)
+	if err != nil {
+		return "", fmt.Errorf("computing hmac: %w", err)
+	}
+
+	if _, ok := ring.Keys[version]; !ok {
+		return "", fmt.Errorf("computing hmac: version %d: %w", version, ErrKeyNotFound)
+	}
+
+	key, ok := ring.HMACKeys[version]
+	if !ok {
+		// keyrings saved before HMAC support get their key on first use, the
+		// keyring is read again under the update so only one key is ever kept
+		err := l.update(ctx, keyID, func(ring *keyring) (*keyring, error) {
+			if ring == nil {
+				return nil, ErrKeyNotFound
+			}
+			if key, ok = ring.HMACKeys[version]; ok {
+				return nil, nil
+			}
+			if err := ring.addHMACKey(version); err != nil {
+				return nil, err
+			}
+			key = ring.HMACKeys[version]
+
+			return ring, nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("computing hmac: %w", err)
+		}
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(input)
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (l *LocalKmsService) load(ctx context.Context, keyID string) (*keyring, error) {
+	sealed, err := l.store.Load(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.open(keyID, sealed)
+}
+
+// update applies change to the keyring of keyID under the store's update lock,
+// change gets a nil keyring when the key doesn't exist and returns nil to keep it.
+func (l *LocalKmsService) update(ctx context.Context, keyID string, change func(*keyring) (*keyring, error)) error {
+	return l.store.Update(ctx, keyID, func(current []byte) ([]byte, error) {
+		var ring *keyring
+		if current != nil {
+			var err error
+			if ring, err = l.open(keyID, current); err != nil {
+				return nil, err
+			}
+		}
+
+		ring, err := change(ring)
+		if err != nil || ring == nil {
+			return nil, err
+		}
+
+		return l.seal(keyID, ring)
+	})
+}
+
+func (l *LocalKmsService) open(keyID string, sealed []byte) (*keyring, error) {
+	nonceSize := l.master.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("keyring for %s is corrupted", keyID)
+	}
+
+	data, err := l.master.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping keyring for %s: %w", keyID, err)
+	}
+
+	var ring keyring
+	if err := json.Unmarshal(data, &ring); err != nil {
+		return nil, fmt.Errorf("decoding keyring for %s: %w", keyID, err)
+	}
+
+	return &ring, nil
+}
+
+func (l *LocalKmsService) seal(keyID string, ring *keyring) ([]byte, error) {
+	data, err := json.Marshal(ring)
+	if err != nil {
+		return nil, fmt.Errorf("encoding keyring: %w", err)
+	}
+
+	nonce := make([]byte, l.master.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("wrapping keyring: %w", err)
+	}
+
+	// the key ID is bound as additional data so a keyring can't be swapped between users
+	return l.master.Seal(nonce, nonce, data, []byte(keyID)), nil
+}
+
+func (k *keyring) privateKey(version int) (*rsa.PrivateKey, error) {
+	der, ok := k.Keys[version]
+	if !ok {
+		return nil, fmt.Errorf("version %d: %w", version, ErrKeyNotFound)
+	}
+
+	return x509.ParsePKCS1PrivateKey(der)
+}
+
+func (k *keyring) addHMACKey(version int) error {
+	key := make([]byte, hmacKeyBytes)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	if k.HMACKeys == nil {
+		k.HMACKeys = map[int][]byte{}
+	}
+	k.HMACKeys[version] = key
+
+	return nil
+}
+
+// FormatCiphertext builds a transit ciphertext from the base64 output of an encryption.
+func FormatCiphertext(version int, data string) string {
+	return fmt.Sprintf("%s:v%d:%s", ciphertextPrefix, version, data)
+}
+
+// CiphertextVersion returns the key version a transit ciphertext was produced with.
+func CiphertextVersion(ciphertext string) (int, error) {
+	version, _, err := parseCiphertext(ciphertext)
+	return version, err
+}
+
+// parseCiphertext splits a "vault:v<version>:<base64>" ciphertext.
+func parseCiphertext(ciphertext string) (int, []byte, error) {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != ciphertextPrefix || !strings.HasPrefix(parts[1], "v") {
+		return 0, nil, ErrInvalidCiphertext
+	}
+
+	version, err := strconv.Atoi(strings.TrimPrefix(parts[1], "v"))
+	if err != nil || version < 1 {
+		return 0, nil, ErrInvalidCiphertext
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return 0, nil, ErrInvalidCiphertext
+	}
+
+	return version, raw, nil
+}
@@ -0,0 +1,183 @@
+package kms_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"sync"
+	"testing"
+
+	"github.com/juaguz/yuno/kit/kms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encrypt mimics the client in example/encrypt.go
+func encrypt(t *testing.T, publicKeyPEM, plainText string) string {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	require.NotNil(t, block)
+
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	require.NoError(t, err)
+
+	encrypted, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey.(*rsa.PublicKey), []byte(plainText), []byte(""))
+	require.NoError(t, err)
+
+	return base64.StdEncoding.EncodeToString(encrypted)
+}
+
+func newLocalKms(t *testing.T) *kms.LocalKmsService {
+	store, err := kms.NewFileKeyStore(t.TempDir())
+	require.NoError(t, err)
+
+	masterKey := make([]byte, 32)
+	_, err = rand.Read(masterKey)
+	require.NoError(t, err)
+
+	service, err := kms.NewLocalKmsService(store, masterKey)
+	require.NoError(t, err)
+
+	return service
+}
+
+func TestLocalKmsService_Decrypt(t *testing.T) {
+	ctx := context.Background()
+	service := newLocalKms(t)
+
+	require.NoError(t, service.CreateKey(ctx, "user"))
+
+	publicKey, version, err := service.GetPublicKey(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	ciphertext := encrypt(t, publicKey, "4111111111111111")
+
+	plaintext, err := service.Decrypt(ctx, "vault:v1:"+ciphertext, "user")
+	require.NoError(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("4111111111111111")), plaintext)
+}
+
+func TestLocalKmsService_CreateKeyIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	service := newLocalKms(t)
+
+	require.NoError(t, service.CreateKey(ctx, "user"))
+	first, _, err := service.GetPublicKey(ctx, "user")
+	require.NoError(t, err)
+
+	require.NoError(t, service.CreateKey(ctx, "user"))
+	second, _, err := service.GetPublicKey(ctx, "user")
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second)
+}
+
+func TestLocalKmsService_RotateKey(t *testing.T) {
+	ctx := context.Background()
+	service := newLocalKms(t)
+
+	require.NoError(t, service.CreateKey(ctx, "user"))
+	oldPublicKey, _, err := service.GetPublicKey(ctx, "user")
+	require.NoError(t, err)
+
+	require.NoError(t, service.RotateKey(ctx, "user"))
+	newPublicKey, version, err := service.GetPublicKey(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+	assert.NotEqual(t, oldPublicKey, newPublicKey)
+
+	expected := base64.StdEncoding.EncodeToString([]byte("4111111111111111"))
+
+	plaintext, err := service.Decrypt(ctx, "vault:v1:"+encrypt(t, oldPublicKey, "4111111111111111"), "user")
+	require.NoError(t, err)
+	assert.Equal(t, expected, plaintext)
+
+	plaintext, err = service.Decrypt(ctx, "vault:v2:"+encrypt(t, newPublicKey, "4111111111111111"), "user")
+	require.NoError(t, err)
+	assert.Equal(t, expected, plaintext)
+}
+
+func TestLocalKmsService_Errors(t *testing.T) {
+	ctx := context.Background()
+	service := newLocalKms(t)
+
+	_, _, err := service.GetPublicKey(ctx, "missing")
+	assert.ErrorIs(t, err, kms.ErrKeyNotFound)
+
+	require.NoError(t, service.CreateKey(ctx, "user"))
+
+	_, err = service.Decrypt(ctx, "not-a-ciphertext", "user")
+	assert.ErrorIs(t, err, kms.ErrInvalidCiphertext)
+
+	_, err = service.Decrypt(ctx, "vault:v2:AAAA", "user")
+	assert.ErrorIs(t, err, kms.ErrKeyNotFound)
+}
+
+func TestLocalKmsService_HMAC(t *testing.T) {
+	ctx := context.Background()
+	service := newLocalKms(t)
+
+	require.NoError(t, service.CreateKey(ctx, "fingerprint"))
+	require.NoError(t, service.CreateKey(ctx, "other"))
+
+	input := base64.StdEncoding.EncodeToString([]byte("4111111111111111"))
+
+	first, err := service.HMAC(ctx, input, "fingerprint", 1)
+	require.NoError(t, err)
+	second, err := service.HMAC(ctx, input, "fingerprint", 1)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	other, err := service.HMAC(ctx, input, "other", 1)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, other)
+
+	require.NoError(t, service.RotateKey(ctx, "fingerprint"))
+
+	pinned, err := service.HMAC(ctx, input, "fingerprint", 1)
+	require.NoError(t, err)
+	assert.Equal(t, first, pinned)
+
+	rotated, err := service.HMAC(ctx, input, "fingerprint", 2)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, rotated)
+
+	_, err = service.HMAC(ctx, input, "fingerprint", 3)
+	assert.ErrorIs(t, err, kms.ErrKeyNotFound)
+}
+
+func TestLocalKmsService_ConcurrentRotations(t *testing.T) {
+	ctx := context.Background()
+	store, err := kms.NewFileKeyStore(t.TempDir())
+	require.NoError(t, err)
+
+	masterKey := make([]byte, 32)
+	_, err = rand.Read(masterKey)
+	require.NoError(t, err)
+
+	// two services sharing a store, like two instances sharing kms_keys
+	first, err := kms.NewLocalKmsService(store, masterKey)
+	require.NoError(t, err)
+	second, err := kms.NewLocalKmsService(store, masterKey)
+	require.NoError(t, err)
+
+	require.NoError(t, first.CreateKey(ctx, "user"))
+
+	var wg sync.WaitGroup
+	for _, service := range []*kms.LocalKmsService{first, second, first, second} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, service.RotateKey(ctx, "user"))
+		}()
+	}
+	wg.Wait()
+
+	_, version, err := second.GetPublicKey(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, 5, version)
+}
@@ -2,10 +2,14 @@ package kms
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 
 	vault "github.com/hashicorp/vault/api"
+	"github.com/juaguz/yuno/kit/errors/senital"
 )
 
 type VaultKmsService struct {
@@ -25,17 +29,39 @@ func (v *VaultKmsService) Decrypt(ctx context.Context, encryptedData, keyID stri
 
 	secret, err := v.client.Logical().Write(transitPath, data)
 	if err != nil {
-		return "", fmt.Errorf("error desencriptando datos: %w", err)
+		// Vault answers 400 to ciphertexts it can't decrypt with the key
+		return "", vaultError("decrypting data", err, ErrInvalidCiphertext)
 	}
 
 	decryptedData, ok := secret.Data["plaintext"].(string)
 	if !ok {
-		return "", fmt.Errorf("error al obtener datos desencriptados desde Vault")
+		return "", fmt.Errorf("can't get decrypted data from Vault")
 	}
 
 	return decryptedData, nil
 }
 
+// Rewrap re-encrypts ciphertext with the latest version of the key without exposing the plaintext.
+func (v *VaultKmsService) Rewrap(ctx context.Context, ciphertext, keyID string) (string, error) {
+	transitPath := fmt.Sprintf("transit/rewrap/%s", keyID)
+
+	data := map[string]interface{}{
+		"ciphertext": ciphertext,
+	}
+
+	secret, err := v.client.Logical().Write(transitPath, data)
+	if err != nil {
+		return "", vaultError("rewrapping data", err, ErrInvalidCiphertext)
+	}
+
+	rewrapped, ok := secret.Data["ciphertext"].(string)
+	if !ok {
+		return "", fmt.Errorf("can't get rewrapped ciphertext from Vault")
+	}
+
+	return rewrapped, nil
+}
+
 func (v *VaultKmsService) CreateKey(ctx context.Context, keyID string) error {
 	transitPath := fmt.Sprintf("transit/keys/%s", keyID)
 
@@ -47,40 +73,137 @@ func (v *VaultKmsService) CreateKey(ctx context.Context, keyID string) error {
 
 	_, err := v.client.Logical().Write(transitPath, data)
 	if err != nil {
-		return fmt.Errorf("creating keys: %w", err)
+		return vaultError("creating keys", err, nil)
 	}
 
 	return nil
 }
 
-func (v *VaultKmsService) GetPublicKey(ctx context.Context, keyID string) (string, error) {
+// CreateHMACKey creates a symmetric key only used to compute HMACs. It can't be
+// exported, so the HMACs can't be computed, nor brute-forced, outside Vault.
+func (v *VaultKmsService) CreateHMACKey(ctx context.Context, keyID string) error {
 	transitPath := fmt.Sprintf("transit/keys/%s", keyID)
 
+	data := map[string]interface{}{
+		"type":                   "hmac",
+		"key_size":               hmacKeyBytes,
+		"exportable":             false,
+		"allow_plaintext_backup": false,
+	}
+
+	_, err := v.client.Logical().Write(transitPath, data)
+	if err != nil {
+		return vaultError("creating hmac key", err, nil)
+	}
+
+	// creating an existing key is a no-op, one created exportable stays so
 	secret, err := v.client.Logical().Read(transitPath)
 	if err != nil {
-		return "", fmt.Errorf("getting public key: %w", err)
+		return vaultError("reading hmac key", err, nil)
+	}
+	if secret != nil && secret.Data["exportable"] == true {
+		return fmt.Errorf("hmac key %s: %w", keyID, ErrExportableKey)
+	}
+
+	return nil
+}
+
+func (v *VaultKmsService) RotateKey(ctx context.Context, keyID string) error {
+	transitPath := fmt.Sprintf("transit/keys/%s/rotate", keyID)
+
+	_, err := v.client.Logical().Write(transitPath, nil)
+	if err != nil {
+		return vaultError("rotating key", err, nil)
+	}
+
+	return nil
+}
+
+// GetPublicKey returns the public key of the latest version along with the version number.
+func (v *VaultKmsService) GetPublicKey(ctx context.Context, keyID string) (string, int, error) {
+	transitPath := fmt.Sprintf("transit/keys/%s", keyID)
+
+	secret, err := v.client.Logical().Read(transitPath)
+	if err != nil {
+		return "", 0, vaultError("getting public key", err, nil)
+	}
+	if secret == nil {
+		return "", 0, fmt.Errorf("getting public key: %w", ErrKeyNotFound)
 	}
 
 	latestVersion, ok := secret.Data["latest_version"].(json.Number)
 	if !ok {
-		return "", fmt.Errorf("can't get latest version from Vault")
+		return "", 0, fmt.Errorf("can't get latest version from Vault")
+	}
+
+	version, err := latestVersion.Int64()
+	if err != nil {
+		return "", 0, fmt.Errorf("can't parse latest version from Vault: %w", err)
 	}
 
 	keys, ok := secret.Data["keys"].(map[string]interface{})
 	if !ok {
-		return "", fmt.Errorf("can't get keys map from Vault")
+		return "", 0, fmt.Errorf("can't get keys map from Vault")
 	}
 
-	versionKey := fmt.Sprintf("%s", latestVersion.String())
-	keyData, ok := keys[versionKey].(map[string]interface{})
+	keyData, ok := keys[latestVersion.String()].(map[string]interface{})
 	if !ok {
-		return "", fmt.Errorf("can't get key data for latest version from Vault")
+		return "", 0, fmt.Errorf("can't get key data for latest version from Vault")
 	}
 
 	publicKey, ok := keyData["public_key"].(string)
 	if !ok {
-		return "", fmt.Errorf("can't get public key from Vault")
+		return "", 0, fmt.Errorf("can't get public key from Vault")
 	}
 
-	return publicKey, nil
+	return publicKey, int(version), nil
+}
+
+// HMAC returns the base64 HMAC-SHA256 of the base64 encoded data computed with the
+// given version of the key. The HMAC key never leaves Vault.
+func (v *VaultKmsService) HMAC(ctx context.Context, data, keyID string, version int) (string, error) {
+	transitPath := fmt.Sprintf("transit/hmac/%s/sha2-256", keyID)
+
+	secret, err := v.client.Logical().Write(transitPath, map[string]interface{}{
+		"input":       data,
+		"key_version": version,
+	})
+	if err != nil {
+		return "", vaultError("computing hmac", err, nil)
+	}
+
+	hmac, ok := secret.Data["hmac"].(string)
+	if !ok {
+		return "", fmt.Errorf("can't get hmac from Vault")
+	}
+
+	_, raw, err := parseCiphertext(hmac)
+	if err != nil {
+		return "", fmt.Errorf("parsing hmac from Vault: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// vaultError classifies err, returned by Vault while doing action. Only the
+// failures to reach Vault and its 5xx and 429 answers are senital.ErrUnavailable,
+// retrying them may succeed. Vault answers 404 to a missing key and 400 to a
+// request it can't process, wrapped as invalid when not nil. Any other 4xx, like
+// a denied token, is a fault of the service itself.
+func vaultError(action string, err error, invalid error) error {
+	var respErr *vault.ResponseError
+	if !errors.As(err, &respErr) {
+		return fmt.Errorf("%w: %s: %w", senital.ErrUnavailable, action, err)
+	}
+
+	switch {
+	case respErr.StatusCode >= http.StatusInternalServerError, respErr.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s: %w", senital.ErrUnavailable, action, err)
+	case respErr.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%s: %w", action, ErrKeyNotFound)
+	case respErr.StatusCode == http.StatusBadRequest && invalid != nil:
+		return fmt.Errorf("%s: %w", action, invalid)
+	default:
+		return fmt.Errorf("%s: %w", action, err)
+	}
 }
@@ -0,0 +1,85 @@
+package kms_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"github.com/juaguz/yuno/kit/kms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// vaultAnswering returns a service whose Vault answers every request with status.
+func vaultAnswering(t *testing.T, status int) *kms.VaultKmsService {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"errors": ["failed"]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	config := vault.DefaultConfig()
+	config.Address = server.URL
+	config.MaxRetries = 0
+	client, err := vault.NewClient(config)
+	require.NoError(t, err)
+
+	return kms.NewVaultKmsService(client)
+}
+
+func TestVaultKmsService_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		err         error
+		unavailable bool
+	}{
+		{"invalid ciphertext", http.StatusBadRequest, kms.ErrInvalidCiphertext, false},
+		{"denied token", http.StatusForbidden, nil, false},
+		{"missing key", http.StatusNotFound, kms.ErrKeyNotFound, false},
+		{"rate limited", http.StatusTooManyRequests, senital.ErrUnavailable, true},
+		{"sealed", http.StatusServiceUnavailable, senital.ErrUnavailable, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := vaultAnswering(t, tt.status)
+
+			_, err := service.Decrypt(context.Background(), "vault:v1:abc", "key")
+
+			require.Error(t, err)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+			}
+			assert.Equal(t, tt.unavailable, errors.Is(err, senital.ErrUnavailable))
+		})
+	}
+}
+
+func TestVaultKmsService_Unreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	config := vault.DefaultConfig()
+	config.Address = server.URL
+	config.MaxRetries = 0
+	client, err := vault.NewClient(config)
+	require.NoError(t, err)
+
+	err = kms.NewVaultKmsService(client).RotateKey(context.Background(), "key")
+
+	assert.ErrorIs(t, err, senital.ErrUnavailable)
+}
+
+func TestVaultKmsService_CreateKeyDenied(t *testing.T) {
+	err := vaultAnswering(t, http.StatusForbidden).CreateKey(context.Background(), "key")
+
+	// retrying won't help, the token has to be fixed
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, senital.ErrUnavailable)
+}
@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/kit/audit"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"github.com/juaguz/yuno/kit/users/dto"
+)
+
+const (
+	// APIKeyHeader is the header requests carry their API key in.
+	APIKeyHeader = "X-API-Key"
+	// PermissionAPIKeysAdmin is required to issue, list and revoke API keys. Keys
+	// can't grant it, so a leaked key can't mint others.
+	PermissionAPIKeysAdmin = "apikeys:admin"
+
+	issueAction = "apikeys.issue"
+
+	apiKeyPrefix = "yuno"
+	// lastUsedResolution bounds the writes of busy keys to one per minute
+	lastUsedResolution = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey       = errors.New("invalid API key")
+	ErrInvalidIssueRequest = errors.New("invalid API key request")
+)
+
+type AuditRepository interface {
+	Record(ctx context.Context, event *audit.Event) error
+}
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *dto.APIKey) error
+	FindByPrefix(ctx context.Context, prefix string) (*dto.APIKey, error)
+	List(ctx context.Context) ([]*dto.APIKey, error)
+	Revoke(ctx context.Context, id uuid.UUID, at time.Time) error
+	Touch(ctx context.Context, id uuid.UUID, at time.Time) error
+}
+
+// APIKeys issues and checks the keys services authenticate with. Keys look like
+// yuno_<prefix>_<secret>, the prefix finds the stored key and the whole key is
+// compared against its hash. Every issuance, allowed or not, is written to the
+// audit trail along with the caller issuing the key.
+type APIKeys struct {
+	Repository      APIKeyRepository
+	AuditRepository AuditRepository
+	now             func() time.Time
+}
+
+func NewAPIKeys(repository APIKeyRepository, auditRepository AuditRepository) *APIKeys {
+	return &APIKeys{Repository: repository, AuditRepository: auditRepository, now: time.Now}
+}
+
+// WithAPIKeys accepts the API keys of keys in the X-API-Key header, in place of a
+// bearer token.
+func WithAPIKeys(keys *APIKeys) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.apiKeys = keys
+	}
+}
+
+// Issue creates a key of the user granting scopes, expiresAt may be nil. The
+// caller in ctx can only grant the scopes it holds itself, and never
+// PermissionAPIKeysAdmin. The key is only returned here, it can't be recovered
+// afterwards.
+func (k *APIKeys) Issue(ctx context.Context, userID uuid.UUID, name string, scopes []string, expiresAt *time.Time) (string, *dto.APIKey, error) {
+	caller, err := GetUserFromContext(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if name == "" {
+		return "", nil, fmt.Errorf("%w: name is required", ErrInvalidIssueRequest)
+	}
+	if expiresAt != nil && !expiresAt.After(k.now()) {
+		return "", nil, fmt.Errorf("%w: expiry must be in the future", ErrInvalidIssueRequest)
+	}
+
+	event := &audit.Event{
+		UserID:     caller.ID,
+		Action:     issueAction,
+		ResourceID: userID.String(),
+	}
+
+	if err := grantable(ctx, scopes); err != nil {
+		event.Outcome = audit.Denied
+		event.Reason = err.Error()
+		if auditErr := k.AuditRepository.Record(ctx, event); auditErr != nil {
+			return "", nil, auditErr
+		}
+		return "", nil, err
+	}
+
+	prefix, err := randomString(6)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return "", nil, err
+	}
+	raw := fmt.Sprintf("%s_%s_%s", apiKeyPrefix, prefix, secret)
+
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	key := &dto.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hashAPIKey(raw),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := k.Repository.Create(ctx, key); err != nil {
+		event.Outcome = audit.Failed
+		event.Reason = err.Error()
+		if auditErr := k.AuditRepository.Record(ctx, event); auditErr != nil {
+			log.Printf("api keys: recording audit event: %s", auditErr)
+		}
+		return "", nil, err
+	}
+
+	// the key is only returned once its issuance is on the audit trail
+	event.Outcome = audit.Allowed
+	event.Reason = fmt.Sprintf("key %s with scopes %q", key.Prefix, strings.Join(scopes, " "))
+	if err := k.AuditRepository.Record(ctx, event); err != nil {
+		return "", nil, err
+	}
+
+	return raw, key, nil
+}
+
+// grantable fails when scopes holds PermissionAPIKeysAdmin or a scope the caller
+// in ctx isn't granted.
+func grantable(ctx context.Context, scopes []string) error {
+	for _, scope := range scopes {
+		if scope == PermissionAPIKeysAdmin {
+			return fmt.Errorf("%w: keys can't grant %s", ErrInvalidIssueRequest, PermissionAPIKeysAdmin)
+		}
+		if !HasPermission(ctx, scope) {
+			return fmt.Errorf("%w: can't grant %s, the caller doesn't hold it", senital.ErrForbidden, scope)
+		}
+	}
+
+	return nil
+}
+
+func (k *APIKeys) List(ctx context.Context) ([]*dto.APIKey, error) {
+	return k.Repository.List(ctx)
+}
+
+// Revoke disables the key right away, ErrNotFound when unknown or already revoked.
+func (k *APIKeys) Revoke(ctx context.Context, id uuid.UUID) error {
+	return k.Repository.Revoke(ctx, id, k.now())
+}
+
+// Authenticate returns the key matching raw, along with its user. Unknown,
+// revoked and expired keys are ErrInvalidAPIKey.
+func (k *APIKeys) Authenticate(ctx context.Context, raw string) (*dto.APIKey, error) {
+	parts := strings.Split(raw, "_")
+	if len(parts) != 3 || parts[0] != apiKeyPrefix {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidAPIKey)
+	}
+
+	key, err := k.Repository.FindByPrefix(ctx, parts[1])
+	if err != nil {
+		if errors.Is(err, senital.ErrNotFound) {
+			return nil, fmt.Errorf("%w: unknown", ErrInvalidAPIKey)
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(raw)), []byte(key.Hash)) != 1 {
+		return nil, fmt.Errorf("%w: unknown", ErrInvalidAPIKey)
+	}
+
+	now := k.now()
+	if key.RevokedAt != nil {
+		return nil, fmt.Errorf("%w: %s revoked", ErrInvalidAPIKey, key.Prefix)
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(now) {
+		return nil, fmt.Errorf("%w: %s expired", ErrInvalidAPIKey, key.Prefix)
+	}
+	if key.User == nil {
+		return nil, fmt.Errorf("%w: %s has no user", ErrInvalidAPIKey, key.Prefix)
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= lastUsedResolution {
+		// a missed update shouldn't reject the request
+		if err := k.Repository.Touch(ctx, key.ID, now); err != nil {
+			log.Printf("api keys: recording use of %s: %s", key.Prefix, err)
+		}
+		key.LastUsedAt = &now
+	}
+
+	return key, nil
+}
+
+// apiKeyClaims returns the claims of a request authenticated with key, so its
+// scopes are checked the same as the ones of a token.
+func apiKeyClaims(key *dto.APIKey) *UserClaims {
+	return &UserClaims{
+		Username: key.User.Username,
+		Email:    key.User.Email,
+		UserID:   key.User.ExternalID,
+		Scope:    strings.Join(key.Scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: key.User.Issuer,
+		},
+	}
+}
+
+func hashAPIKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	// underscores separate the parts of the key, so the URL alphabet can't be used as is
+	return strings.ReplaceAll(base64.RawURLEncoding.EncodeToString(b), "_", "-"), nil
+}
@@ -0,0 +1,254 @@
+package auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/kit/audit"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"github.com/juaguz/yuno/kit/users/auth"
+	"github.com/juaguz/yuno/kit/users/dto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// apiKeyRepository keeps the keys in memory, they all belong to user.
+type apiKeyRepository struct {
+	user    *dto.User
+	keys    map[string]*dto.APIKey
+	touches int
+}
+
+func newAPIKeyRepository() *apiKeyRepository {
+	return &apiKeyRepository{
+		user: &dto.User{ID: uuid.New(), ExternalID: "service-1", Username: "batch"},
+		keys: map[string]*dto.APIKey{},
+	}
+}
+
+func (r *apiKeyRepository) Create(ctx context.Context, key *dto.APIKey) error {
+	key.ID = uuid.New()
+	r.keys[key.Prefix] = key
+	return nil
+}
+
+func (r *apiKeyRepository) FindByPrefix(ctx context.Context, prefix string) (*dto.APIKey, error) {
+	key, ok := r.keys[prefix]
+	if !ok {
+		return nil, senital.ErrNotFound
+	}
+
+	stored := *key
+	stored.User = r.user
+	return &stored, nil
+}
+
+func (r *apiKeyRepository) List(ctx context.Context) ([]*dto.APIKey, error) {
+	var keys []*dto.APIKey
+	for _, key := range r.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (r *apiKeyRepository) Revoke(ctx context.Context, id uuid.UUID, at time.Time) error {
+	for _, key := range r.keys {
+		if key.ID == id && key.RevokedAt == nil {
+			key.RevokedAt = &at
+			return nil
+		}
+	}
+	return senital.ErrNotFound
+}
+
+func (r *apiKeyRepository) Touch(ctx context.Context, id uuid.UUID, at time.Time) error {
+	r.touches++
+	for _, key := range r.keys {
+		if key.ID == id {
+			key.LastUsedAt = &at
+		}
+	}
+	return nil
+}
+
+type auditLog struct {
+	events []*audit.Event
+}
+
+func (l *auditLog) Record(ctx context.Context, event *audit.Event) error {
+	l.events = append(l.events, event)
+	return nil
+}
+
+// issuing returns the context of a request of user holding the API keys admin
+// permission along with scopes.
+func issuing(user *dto.User, scopes ...string) context.Context {
+	ctx := context.WithValue(context.Background(), auth.UserKey, user)
+	claims := &auth.UserClaims{Scope: strings.Join(append(scopes, auth.PermissionAPIKeysAdmin), " ")}
+	return context.WithValue(ctx, auth.ClaimsKey, claims)
+}
+
+func TestAPIKeys_Authenticate(t *testing.T) {
+	repo := newAPIKeyRepository()
+	keys := auth.NewAPIKeys(repo, &auditLog{})
+
+	raw, issued, err := keys.Issue(issuing(repo.user, "cards:read"), repo.user.ID, "batch", []string{"cards:read"}, nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(raw, "yuno_"+issued.Prefix+"_"))
+	assert.Len(t, issued.Hash, 64)
+
+	key, err := keys.Authenticate(context.Background(), raw)
+	require.NoError(t, err)
+	assert.Equal(t, issued.ID, key.ID)
+	assert.Equal(t, repo.user, key.User)
+
+	// the last use is only recorded once a minute
+	_, err = keys.Authenticate(context.Background(), raw)
+	require.NoError(t, err)
+	assert.Equal(t, 1, repo.touches)
+}
+
+func TestAPIKeys_RejectsKeys(t *testing.T) {
+	repo := newAPIKeyRepository()
+	keys := auth.NewAPIKeys(repo, &auditLog{})
+
+	revoked, revokedKey, err := keys.Issue(issuing(repo.user), repo.user.ID, "revoked", nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, keys.Revoke(context.Background(), revokedKey.ID))
+
+	expiresAt := time.Now().Add(time.Hour)
+	expired, expiredKey, err := keys.Issue(issuing(repo.user), repo.user.ID, "expired", nil, &expiresAt)
+	require.NoError(t, err)
+	past := time.Now().Add(-time.Minute)
+	expiredKey.ExpiresAt = &past
+
+	valid, validKey, err := keys.Issue(issuing(repo.user), repo.user.ID, "valid", nil, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"revoked", revoked},
+		{"expired", expired},
+		{"wrong secret", "yuno_" + validKey.Prefix + "_" + strings.Repeat("a", 43)},
+		{"unknown prefix", strings.Replace(valid, validKey.Prefix, "unknown1", 1)},
+		{"malformed", "not-a-key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := keys.Authenticate(context.Background(), tt.raw)
+			assert.ErrorIs(t, err, auth.ErrInvalidAPIKey)
+		})
+	}
+}
+
+func TestAPIKeys_IssueValidation(t *testing.T) {
+	repo := newAPIKeyRepository()
+	keys := auth.NewAPIKeys(repo, &auditLog{})
+
+	_, _, err := keys.Issue(issuing(repo.user), repo.user.ID, "", nil, nil)
+	assert.ErrorIs(t, err, auth.ErrInvalidIssueRequest)
+
+	past := time.Now().Add(-time.Hour)
+	_, _, err = keys.Issue(issuing(repo.user), repo.user.ID, "batch", nil, &past)
+	assert.ErrorIs(t, err, auth.ErrInvalidIssueRequest)
+
+	assert.Empty(t, repo.keys)
+}
+
+func TestAPIKeys_IssueScopes(t *testing.T) {
+	admin := &dto.User{ID: uuid.New(), ExternalID: "admin"}
+	target := uuid.New()
+
+	tests := []struct {
+		name    string
+		scopes  []string
+		err     error
+		outcome audit.Outcome
+	}{
+		{"held scopes", []string{"cards:read"}, nil, audit.Allowed},
+		{"scope not held", []string{"cards:read", "cards:reveal"}, senital.ErrForbidden, audit.Denied},
+		{"admin scope", []string{auth.PermissionAPIKeysAdmin}, auth.ErrInvalidIssueRequest, audit.Denied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newAPIKeyRepository()
+			trail := &auditLog{}
+			keys := auth.NewAPIKeys(repo, trail)
+
+			_, _, err := keys.Issue(issuing(admin, "cards:read"), target, "batch", tt.scopes, nil)
+
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				assert.Empty(t, repo.keys)
+			} else {
+				require.NoError(t, err)
+				assert.Len(t, repo.keys, 1)
+			}
+
+			// the trail records who issued the key, not the user it acts as
+			require.Len(t, trail.events, 1)
+			assert.Equal(t, admin.ID, trail.events[0].UserID)
+			assert.Equal(t, target.String(), trail.events[0].ResourceID)
+			assert.Equal(t, tt.outcome, trail.events[0].Outcome)
+		})
+	}
+}
+
+func TestAPIKeys_IssueWithoutCaller(t *testing.T) {
+	repo := newAPIKeyRepository()
+	keys := auth.NewAPIKeys(repo, &auditLog{})
+
+	_, _, err := keys.Issue(context.Background(), repo.user.ID, "batch", nil, nil)
+
+	assert.ErrorIs(t, err, senital.ErrUnauthenticated)
+	assert.Empty(t, repo.keys)
+}
+
+func TestJWTMiddleware_APIKey(t *testing.T) {
+	p := newProvider(t)
+	verifier, err := auth.NewVerifier([]auth.IssuerConfig{{Issuer: p.URL, Audiences: []string{"cards"}}})
+	require.NoError(t, err)
+
+	repo := newAPIKeyRepository()
+	keys := auth.NewAPIKeys(repo, &auditLog{})
+	raw, _, err := keys.Issue(issuing(repo.user, "cards:read"), repo.user.ID, "batch", []string{"cards:read"}, nil)
+	require.NoError(t, err)
+
+	middleware := auth.JWTMiddleware(&userRepository{users: map[string]*dto.User{}}, verifier, auth.WithAPIKeys(keys))
+	handler := middleware(auth.Require("cards:read")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := auth.GetUserFromContext(r.Context())
+		require.NoError(t, err)
+		assert.Equal(t, repo.user.ID, user.ID)
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	tests := []struct {
+		name   string
+		key    string
+		status int
+	}{
+		{"valid key", raw, http.StatusNoContent},
+		{"invalid key", raw + "x", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/cards", nil)
+			r.Header.Set(auth.APIKeyHeader, tt.key)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/juaguz/yuno/kit/errors/problem"
+)
+
+// ReasonMissingPermission is the reason of the 403 returned when the token lacks
+// a permission the route requires.
+const ReasonMissingPermission = "missing_permission"
+
+// HasPermission reports whether the token grants permission, either as one of its
+// scopes, a realm role or a role of the client configured for its issuer. Roles
+// of other clients are ignored, any client of the realm can be given them.
+func (c *UserClaims) HasPermission(permission string) bool {
+	for _, scope := range strings.Fields(c.Scope) {
+		if scope == permission {
+			return true
+		}
+	}
+
+	for _, role := range c.RealmAccess.Roles {
+		if role == permission {
+			return true
+		}
+	}
+
+	if c.clientID == "" {
+		return false
+	}
+	for _, role := range c.ResourceAccess[c.clientID].Roles {
+		if role == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Require lets the request through only when its token grants every one of
+// permissions, otherwise it answers 403 with the missing ones as extension. It must run after
+// JWTMiddleware.
+func Require(permissions ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var missing []string
+			for _, permission := range permissions {
+				if !HasPermission(r.Context(), permission) {
+					missing = append(missing, permission)
+				}
+			}
+
+			if len(missing) > 0 {
+				p := problem.New(http.StatusForbidden, "missing permission")
+				p.Extensions = map[string]interface{}{
+					"reason":  ReasonMissingPermission,
+					"missing": missing,
+				}
+				problem.Write(w, r, p)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package auth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/juaguz/yuno/kit/users/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserClaims_HasPermission(t *testing.T) {
+	claims := &auth.UserClaims{Scope: "openid cards:read"}
+	claims.RealmAccess.Roles = []string{"cards:write"}
+	require.NoError(t, json.Unmarshal([]byte(`{"resource_access": {"yuno": {"roles": ["keys:create"]}}}`), claims))
+
+	assert.True(t, claims.HasPermission("cards:read"))
+	assert.True(t, claims.HasPermission("cards:write"))
+	// client roles only count for the client configured for the issuer
+	assert.False(t, claims.HasPermission("keys:create"))
+	assert.False(t, claims.HasPermission("cards:reveal"))
+	assert.False(t, claims.HasPermission("cards"))
+}
+
+func TestRequire(t *testing.T) {
+	handler := auth.Require("cards:read", "cards:write")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name    string
+		claims  *auth.UserClaims
+		status  int
+		missing []string
+	}{
+		{"all granted", &auth.UserClaims{Scope: "cards:read cards:write"}, http.StatusNoContent, nil},
+		{"one missing", &auth.UserClaims{Scope: "cards:read"}, http.StatusForbidden, []string{"cards:write"}},
+		{"no claims", nil, http.StatusForbidden, []string{"cards:read", "cards:write"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/cards", nil)
+			if tt.claims != nil {
+				r = r.WithContext(context.WithValue(r.Context(), auth.ClaimsKey, tt.claims))
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			require.Equal(t, tt.status, w.Code)
+			if tt.status != http.StatusForbidden {
+				return
+			}
+
+			var body struct {
+				Status  int      `json:"status"`
+				Reason  string   `json:"reason"`
+				Missing []string `json:"missing"`
+			}
+			assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+			assert.Equal(t, http.StatusForbidden, body.Status)
+			assert.Equal(t, auth.ReasonMissingPermission, body.Reason)
+			assert.Equal(t, tt.missing, body.Missing)
+		})
+	}
+}
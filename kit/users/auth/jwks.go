@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultJWKSTTL             = 15 * time.Minute
+	DefaultJWKSRefreshInterval = 30 * time.Second
+	DefaultJWKSTimeout         = 5 * time.Second
+
+	// maxJWKSSize bounds the certs response, real ones are a few KB
+	maxJWKSSize = 1 << 20
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// JWKSCache keeps the signing keys published by an identity provider. Keys are
+// refetched once the TTL expires, or when a token is signed with an unknown kid,
+// which happens right after the provider rotates its keys. Refetches triggered
+// by unknown kids are limited to one per RefreshInterval so forged tokens can't
+// flood the provider. When a refetch fails the keys already known keep being
+// used, so a provider outage doesn't take authentication down with it.
+type JWKSCache struct {
+	URL             string
+	Client          *http.Client
+	TTL             time.Duration
+	RefreshInterval time.Duration
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	lastErr     error
+
+	// serializes the fetches so concurrent misses trigger a single request
+	fetchMu sync.Mutex
+	now     func() time.Time
+}
+
+func NewJWKSCache(url string) *JWKSCache {
+	return &JWKSCache{
+		URL:             url,
+		Client:          &http.Client{Timeout: DefaultJWKSTimeout},
+		TTL:             DefaultJWKSTTL,
+		RefreshInterval: DefaultJWKSRefreshInterval,
+		now:             time.Now,
+	}
+}
+
+// Key returns the public key identified by kid, either *rsa.PublicKey or *ecdsa.PublicKey.
+func (c *JWKSCache) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	key, known, fresh := c.lookup(kid)
+	if known && fresh {
+		return key, nil
+	}
+
+	if !known && fresh && !c.canRefresh() {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+	}
+
+	if err := c.refresh(ctx); err != nil {
+		if known {
+			log.Printf("jwks: refreshing %s, using cached keys: %s", c.URL, err)
+			return key, nil
+		}
+		return nil, err
+	}
+
+	key, known, _ = c.lookup(kid)
+	if !known {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+	}
+
+	return key, nil
+}
+
+// lookup returns the cached key and whether the cache is within its TTL.
+func (c *JWKSCache) lookup(kid string) (crypto.PublicKey, bool, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	key, ok := c.keys[kid]
+	fresh := c.keys != nil && c.now().Sub(c.fetchedAt) < c.TTL
+
+	return key, ok, fresh
+}
+
+func (c *JWKSCache) canRefresh() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.now().Sub(c.attemptedAt) >= c.RefreshInterval
+}
+
+func (c *JWKSCache) refresh(ctx context.Context) error {
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+
+	c.mu.RLock()
+	// another request fetched while this one waited for the lock, or the provider
+	// failed moments ago and shouldn't be hit again yet
+	recent := !c.attemptedAt.IsZero() && c.now().Sub(c.attemptedAt) < c.RefreshInterval
+	lastErr := c.lastErr
+	c.mu.RUnlock()
+	if recent {
+		return lastErr
+	}
+
+	keys, err := c.fetch(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.attemptedAt = c.now()
+	c.lastErr = err
+	if err != nil {
+		return err
+	}
+
+	c.keys = keys
+	c.fetchedAt = c.attemptedAt
+
+	return nil
+}
+
+func (c *JWKSCache) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetching public keys: %w", err)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching public keys: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching public keys: unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSSize)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding public keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kid == "" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			// one broken key shouldn't prevent using the others
+			log.Printf("jwks: skipping key %s of %s: %s", k.Kid, c.URL, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no usable signing keys in %s", c.URL)
+	}
+
+	return keys, nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("decoding modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("decoding exponent: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("decoding x: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("decoding y: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	if value == "" {
+		return nil, fmt.Errorf("missing value")
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
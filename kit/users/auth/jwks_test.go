@@ -0,0 +1,168 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/juaguz/yuno/kit/users/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(t *testing.T, kid string) (map[string]string, *rsa.PublicKey) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	return map[string]string{
+		"kid": kid,
+		"kty": "RSA",
+		"use": "sig",
+		"n":   encode(priv.N.Bytes()),
+		"e":   encode(big.NewInt(int64(priv.E)).Bytes()),
+	}, &priv.PublicKey
+}
+
+func ecJWK(t *testing.T, kid string) (map[string]string, *ecdsa.PublicKey) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	return map[string]string{
+		"kid": kid,
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   encode(priv.X.Bytes()),
+		"y":   encode(priv.Y.Bytes()),
+	}, &priv.PublicKey
+}
+
+// jwksServer serves the keys returned by keys and counts the requests, it fails
+// with 500 while failing is set.
+type jwksServer struct {
+	*httptest.Server
+	keys     atomic.Value
+	requests atomic.Int32
+	failing  atomic.Bool
+}
+
+func newJWKSServer(t *testing.T, keys ...map[string]string) *jwksServer {
+	s := &jwksServer{}
+	s.keys.Store(keys)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		if s.failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys.Load()})
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestJWKSCache_CachesKeys(t *testing.T) {
+	rsaKey, rsaPub := rsaJWK(t, "rsa")
+	ecKey, ecPub := ecJWK(t, "ec")
+	server := newJWKSServer(t, rsaKey, ecKey)
+
+	cache := auth.NewJWKSCache(server.URL)
+
+	for i := 0; i < 3; i++ {
+		key, err := cache.Key(context.Background(), "rsa")
+		require.NoError(t, err)
+		assert.True(t, rsaPub.Equal(key))
+	}
+
+	key, err := cache.Key(context.Background(), "ec")
+	require.NoError(t, err)
+	assert.True(t, ecPub.Equal(key))
+
+	assert.Equal(t, int32(1), server.requests.Load())
+}
+
+func TestJWKSCache_RefreshesOnUnknownKid(t *testing.T) {
+	oldKey, _ := rsaJWK(t, "old")
+	newKey, newPub := rsaJWK(t, "new")
+	server := newJWKSServer(t, oldKey)
+
+	cache := auth.NewJWKSCache(server.URL)
+	cache.RefreshInterval = 0
+
+	_, err := cache.Key(context.Background(), "old")
+	require.NoError(t, err)
+
+	// the provider rotates its keys
+	server.keys.Store([]map[string]string{oldKey, newKey})
+
+	key, err := cache.Key(context.Background(), "new")
+	require.NoError(t, err)
+	assert.True(t, newPub.Equal(key))
+	assert.Equal(t, int32(2), server.requests.Load())
+}
+
+func TestJWKSCache_RateLimitsUnknownKids(t *testing.T) {
+	rsaKey, _ := rsaJWK(t, "rsa")
+	server := newJWKSServer(t, rsaKey)
+
+	cache := auth.NewJWKSCache(server.URL)
+	cache.RefreshInterval = time.Hour
+
+	_, err := cache.Key(context.Background(), "rsa")
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		_, err := cache.Key(context.Background(), "forged")
+		assert.ErrorIs(t, err, auth.ErrUnknownKey)
+	}
+
+	assert.Equal(t, int32(1), server.requests.Load())
+}
+
+func TestJWKSCache_StaleIfError(t *testing.T) {
+	rsaKey, rsaPub := rsaJWK(t, "rsa")
+	server := newJWKSServer(t, rsaKey)
+
+	cache := auth.NewJWKSCache(server.URL)
+	// every lookup finds the cache expired
+	cache.TTL = 0
+	cache.RefreshInterval = 0
+
+	_, err := cache.Key(context.Background(), "rsa")
+	require.NoError(t, err)
+
+	server.failing.Store(true)
+
+	key, err := cache.Key(context.Background(), "rsa")
+	require.NoError(t, err)
+	assert.True(t, rsaPub.Equal(key))
+
+	_, err = cache.Key(context.Background(), "other")
+	assert.Error(t, err)
+}
+
+func TestJWKSCache_InvalidResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"keys": [{"kid": "bad", "kty": "RSA", "n": "!!!", "e": "AQAB"}]}`))
+	}))
+	defer server.Close()
+
+	cache := auth.NewJWKSCache(server.URL)
+
+	_, err := cache.Key(context.Background(), "bad")
+	assert.Error(t, err)
+}
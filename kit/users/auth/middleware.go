@@ -2,84 +2,68 @@ package auth
 
 import (
 	"context"
-	"crypto/rsa"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"math/big"
+	"log"
 	"net/http"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/juaguz/yuno/kit/errors/problem"
+	"github.com/juaguz/yuno/kit/errors/senital"
 	"github.com/juaguz/yuno/kit/users/dto"
 )
 
 type UserContextKey string
 
-const UserKey UserContextKey = "user"
+const (
+	UserKey   UserContextKey = "user"
+	ClaimsKey UserContextKey = "claims"
+)
 
 type UserRepository interface {
-	FindByExternalID(ctx context.Context, externalID string) (*dto.User, error)
+	// FindByExternalID returns the user with the external ID given by issuer,
+	// the sub of its tokens.
+	FindByExternalID(ctx context.Context, issuer string, externalID string) (*dto.User, error)
 }
 
 type UserClaims struct {
 	Username string `json:"preferred_username"`
 	Email    string `json:"email"`
 	UserID   string `json:"sub"`
+	Scope    string `json:"scope"`
+	// AuthorizedParty is the client the token was issued to
+	AuthorizedParty string `json:"azp"`
+	RealmAccess     struct {
+		Roles []string `json:"roles"`
+	} `json:"realm_access"`
+	// ResourceAccess holds the client roles, by client ID
+	ResourceAccess map[string]struct {
+		Roles []string `json:"roles"`
+	} `json:"resource_access"`
 	jwt.RegisteredClaims
+
+	// clientID is the client of ResourceAccess whose roles count, the one
+	// configured for the issuer of the token
+	clientID string
 }
 
-// Fetches the public key from Keycloak based on the given kid
-func getKeycloakPublicKey(kid, keycloakURL, realm string) (*rsa.PublicKey, error) {
-	url := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/certs", keycloakURL, realm)
+type middlewareConfig struct {
+	provisioning ProvisioningRepository
+	apiKeys      *APIKeys
+}
 
-	// Make HTTP GET request to fetch the JSON of public keys
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch public keys: %v", err)
-	}
-	defer resp.Body.Close()
-
-	var keyResponse struct {
-		Keys []struct {
-			Kid     string   `json:"kid"`
-			Kty     string   `json:"kty"`
-			Alg     string   `json:"alg"`
-			Use     string   `json:"use"`
-			N       string   `json:"n"`
-			E       string   `json:"e"`
-			X5c     []string `json:"x5c"`
-			X5t     string   `json:"x5t"`
-			X5tS256 string   `json:"x5t#S256"`
-		} `json:"keys"`
-	}
-	b, _ := io.ReadAll(resp.Body)
-	json.Unmarshal(b, &keyResponse)
-
-	// Find the correct key by kid
-	for _, key := range keyResponse.Keys {
-		if key.Kid == kid {
-			// Decode the modulus and exponent from base64
-			nb, _ := base64.RawURLEncoding.DecodeString(key.N)
-			eb, _ := base64.RawURLEncoding.DecodeString(key.E)
-
-			// Convert exponent bytes to integer
-			e := int(new(big.Int).SetBytes(eb).Uint64())
-
-			// Construct the RSA public key
-			pubKey := &rsa.PublicKey{
-				N: new(big.Int).SetBytes(nb),
-				E: e,
-			}
-			return pubKey, nil
-		}
-	}
+// MiddlewareOption configures JWTMiddleware.
+type MiddlewareOption func(*middlewareConfig)
 
-	return nil, fmt.Errorf("public key with kid %s not found", kid)
-}
+// JWTMiddleware authenticates the requests with a bearer token of one of the
+// issuers trusted by verifier, or with an API key when WithAPIKeys is set.
+func JWTMiddleware(userRepo UserRepository, verifier *Verifier, options ...MiddlewareOption) func(http.Handler) http.Handler {
+	config := &middlewareConfig{}
+	for _, option := range options {
+		option(config)
+	}
 
-func JWTMiddleware(userRepo UserRepository, keycloakURL, realm string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Skip JWT validation for Swagger routes
@@ -88,47 +72,60 @@ func JWTMiddleware(userRepo UserRepository, keycloakURL, realm string) func(http
 				return
 			}
 
+			if raw := r.Header.Get(APIKeyHeader); raw != "" && config.apiKeys != nil {
+				key, err := config.apiKeys.Authenticate(r.Context(), raw)
+				if errors.Is(err, ErrInvalidAPIKey) {
+					problem.Error(w, r, http.StatusUnauthorized, "invalid API key")
+					return
+				}
+				if err != nil {
+					internalError(w, r, err)
+					return
+				}
+
+				ctx := context.WithValue(r.Context(), UserKey, key.User)
+				ctx = context.WithValue(ctx, ClaimsKey, apiKeyClaims(key))
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
-				http.Error(w, "Authorization header missing", http.StatusUnauthorized)
+				problem.Error(w, r, http.StatusUnauthorized, "authorization header missing")
 				return
 			}
 
 			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 			if tokenString == authHeader {
-				http.Error(w, "Malformed Authorization header", http.StatusUnauthorized)
+				problem.Error(w, r, http.StatusUnauthorized, "malformed authorization header")
 				return
 			}
 
-			// Parse the token without verifying to get the kid
-			token, err := jwt.ParseWithClaims(tokenString, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
-				kid, ok := token.Header["kid"].(string)
-				if !ok {
-					return nil, fmt.Errorf("kid not found in token header")
-				}
-
-				return getKeycloakPublicKey(kid, keycloakURL, realm)
-			})
-			if err != nil || !token.Valid {
-
-				http.Error(w, err.Error(), http.StatusUnauthorized)
+			claims, err := verifier.Verify(r.Context(), tokenString)
+			if err != nil {
+				// the cause may be a provider outage, it is logged instead of returned
+				log.Printf("auth: rejecting token: %s", err)
+				problem.Error(w, r, http.StatusUnauthorized, "invalid or expired token")
 				return
 			}
 
-			// Extract and validate claims
-			if claims, ok := token.Claims.(*UserClaims); ok && token.Valid {
-				// Find the user by external ID in the database
-				u, err := userRepo.FindByExternalID(r.Context(), claims.UserID)
-				if err != nil {
-					http.Error(w, "User not found", http.StatusUnauthorized)
-					return
-				}
-				// Store user in context
-				ctx := context.WithValue(r.Context(), UserKey, u)
-				next.ServeHTTP(w, r.WithContext(ctx))
-			} else {
-				http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+			// the same sub may come from different issuers, it's only unique within one
+			u, err := userRepo.FindByExternalID(r.Context(), claims.Issuer, claims.UserID)
+			if config.provisioning != nil && (err == nil || errors.Is(err, senital.ErrNotFound)) {
+				u, err = provision(r.Context(), config.provisioning, u, claims)
+			}
+			if errors.Is(err, senital.ErrNotFound) {
+				problem.Error(w, r, http.StatusUnauthorized, "user not found")
+				return
 			}
+			if err != nil {
+				internalError(w, r, err)
+				return
+			}
+			// Store user and claims in context
+			ctx := context.WithValue(r.Context(), UserKey, u)
+			ctx = context.WithValue(ctx, ClaimsKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
@@ -136,7 +133,22 @@ func JWTMiddleware(userRepo UserRepository, keycloakURL, realm string) func(http
 func GetUserFromContext(ctx context.Context) (*dto.User, error) {
 	u, ok := ctx.Value(UserKey).(*dto.User)
 	if !ok {
-		return nil, fmt.Errorf("no user claims in context")
+		return nil, fmt.Errorf("no user in context: %w", senital.ErrUnauthenticated)
 	}
 	return u, nil
 }
+
+// HasPermission reports whether the token of the request grants permission.
+func HasPermission(ctx context.Context, permission string) bool {
+	claims, ok := ctx.Value(ClaimsKey).(*UserClaims)
+	if !ok {
+		return false
+	}
+
+	return claims.HasPermission(permission)
+}
+
+func internalError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("auth: %s %s: %s", r.Method, r.URL.Path, err)
+	problem.Error(w, r, http.StatusInternalServerError, "")
+}
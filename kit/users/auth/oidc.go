@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const maxDiscoverySize = 1 << 20
+
+var (
+	ErrUntrustedIssuer = errors.New("untrusted issuer")
+	ErrInvalidClaims   = errors.New("invalid token claims")
+)
+
+// asymmetricAlgorithms are the algorithms an issuer may be configured with. HMAC
+// ones are left out, the key is public and anyone could sign with it.
+var asymmetricAlgorithms = map[string]bool{
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+}
+
+// IssuerConfig is a trusted OIDC provider. Tokens must carry Issuer as iss, one of
+// Audiences in aud and, when AuthorizedParties is set, one of them as azp.
+// Audiences is required, without it tokens issued for any other client of the
+// provider would be accepted. ClientID is the client whose roles in
+// resource_access grant permissions, the roles of other clients are ignored, and
+// so are all of them when it is empty.
+// DiscoveryURL is where the openid-configuration is fetched from, Issuer by default.
+// It is needed when the provider is reached through another host than the one in
+// its tokens, e.g. inside docker-compose.
+type IssuerConfig struct {
+	Issuer            string   `json:"issuer"`
+	DiscoveryURL      string   `json:"discovery_url,omitempty"`
+	Audiences         []string `json:"audiences,omitempty"`
+	AuthorizedParties []string `json:"authorized_parties,omitempty"`
+	ClientID          string   `json:"client_id,omitempty"`
+	Algorithms        []string `json:"algorithms,omitempty"`
+}
+
+type issuer struct {
+	config IssuerConfig
+
+	mu           sync.Mutex
+	keys         *JWKSCache
+	discoveredAt time.Time
+}
+
+// Verifier validates the tokens of one or more trusted OIDC issuers. The signing
+// keys of each issuer are found through its .well-known/openid-configuration.
+type Verifier struct {
+	Client  *http.Client
+	issuers map[string]*issuer
+}
+
+func NewVerifier(configs []IssuerConfig) (*Verifier, error) {
+	if len(configs) == 0 {
+		return nil, errors.New("at least one issuer is required")
+	}
+
+	issuers := make(map[string]*issuer, len(configs))
+	for _, config := range configs {
+		if config.Issuer == "" {
+			return nil, errors.New("issuer is required")
+		}
+		if _, ok := issuers[config.Issuer]; ok {
+			return nil, fmt.Errorf("issuer %s configured twice", config.Issuer)
+		}
+		if len(config.Audiences) == 0 {
+			return nil, fmt.Errorf("issuer %s: at least one audience is required", config.Issuer)
+		}
+
+		if len(config.Algorithms) == 0 {
+			config.Algorithms = []string{"RS256"}
+		}
+		for _, alg := range config.Algorithms {
+			if !asymmetricAlgorithms[alg] {
+				return nil, fmt.Errorf("issuer %s: unsupported algorithm %s", config.Issuer, alg)
+			}
+		}
+
+		if config.DiscoveryURL == "" {
+			config.DiscoveryURL = config.Issuer
+		}
+
+		issuers[config.Issuer] = &issuer{config: config}
+	}
+
+	return &Verifier{
+		Client:  &http.Client{Timeout: DefaultJWKSTimeout},
+		issuers: issuers,
+	}, nil
+}
+
+// Verify checks the signature and claims of the token and returns its claims.
+func (v *Verifier) Verify(ctx context.Context, tokenString string) (*UserClaims, error) {
+	// the issuer tells which keys and rules apply, it is checked again once verified
+	unverified := &UserClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, unverified); err != nil {
+		return nil, err
+	}
+
+	iss, ok := v.issuers[unverified.Issuer]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUntrustedIssuer, unverified.Issuer)
+	}
+
+	claims := &UserClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(iss.config.Algorithms))
+	_, err := parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("kid not found in token header")
+		}
+
+		keys, err := v.keys(ctx, iss)
+		if err != nil {
+			return nil, err
+		}
+
+		return keys.Key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := iss.validate(claims); err != nil {
+		return nil, err
+	}
+	claims.clientID = iss.config.ClientID
+
+	return claims, nil
+}
+
+func (i *issuer) validate(claims *UserClaims) error {
+	if claims.Issuer != i.config.Issuer {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidClaims, claims.Issuer)
+	}
+
+	if !containsAny(claims.Audience, i.config.Audiences) {
+		return fmt.Errorf("%w: unexpected audience %v", ErrInvalidClaims, claims.Audience)
+	}
+
+	if len(i.config.AuthorizedParties) > 0 && !containsAny([]string{claims.AuthorizedParty}, i.config.AuthorizedParties) {
+		return fmt.Errorf("%w: unexpected authorized party %q", ErrInvalidClaims, claims.AuthorizedParty)
+	}
+
+	return nil
+}
+
+// keys returns the JWKS of the issuer, running the discovery the first time. A
+// failed discovery is retried at most once per DefaultJWKSRefreshInterval.
+func (v *Verifier) keys(ctx context.Context, iss *issuer) (*JWKSCache, error) {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+
+	if iss.keys != nil {
+		return iss.keys, nil
+	}
+	if time.Since(iss.discoveredAt) < DefaultJWKSRefreshInterval {
+		return nil, fmt.Errorf("discovery of %s failed recently", iss.config.Issuer)
+	}
+	iss.discoveredAt = time.Now()
+
+	jwksURI, err := v.discover(ctx, iss.config)
+	if err != nil {
+		return nil, err
+	}
+
+	iss.keys = NewJWKSCache(jwksURI)
+	iss.keys.Client = v.Client
+
+	return iss.keys, nil
+}
+
+func (v *Verifier) discover(ctx context.Context, config IssuerConfig) (string, error) {
+	url := strings.TrimSuffix(config.DiscoveryURL, "/") + "/.well-known/openid-configuration"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("discovering %s: %w", config.Issuer, err)
+	}
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("discovering %s: %w", config.Issuer, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("discovering %s: unexpected status %d", config.Issuer, resp.StatusCode)
+	}
+
+	var document struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDiscoverySize)).Decode(&document); err != nil {
+		return "", fmt.Errorf("discovering %s: %w", config.Issuer, err)
+	}
+
+	// a provider reached through another host announces that host as issuer
+	if config.DiscoveryURL == config.Issuer && document.Issuer != config.Issuer {
+		return "", fmt.Errorf("discovering %s: document is for issuer %q", config.Issuer, document.Issuer)
+	}
+	if document.JWKSURI == "" {
+		return "", fmt.Errorf("discovering %s: no jwks_uri", config.Issuer)
+	}
+
+	return document.JWKSURI, nil
+}
+
+func containsAny(values []string, allowed []string) bool {
+	for _, value := range values {
+		for _, a := range allowed {
+			if value == a {
+				return true
+			}
+		}
+	}
+
+	return false
+}
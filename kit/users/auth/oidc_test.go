@@ -0,0 +1,197 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/juaguz/yuno/kit/users/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// provider is an OIDC provider serving its discovery document and signing keys.
+type provider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+}
+
+func newProvider(t *testing.T) *provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &provider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   p.URL,
+			"jwks_uri": p.URL + "/certs",
+		})
+	})
+	mux.HandleFunc("/certs", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "key",
+			"kty": "RSA",
+			"n":   encode(key.N.Bytes()),
+			"e":   encode(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+func (p *provider) sign(t *testing.T, method jwt.SigningMethod, claims *auth.UserClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = "key"
+
+	var key interface{} = p.key
+	if method == jwt.SigningMethodHS256 {
+		// the public modulus used as HMAC secret, the classic key confusion
+		key = p.key.N.Bytes()
+	}
+
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+
+	return signed
+}
+
+func (p *provider) claims(audience string, azp string) *auth.UserClaims {
+	return &auth.UserClaims{
+		UserID:          "user-1",
+		AuthorizedParty: azp,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    p.URL,
+			Audience:  jwt.ClaimStrings{audience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+}
+
+func TestVerifier_MultipleIssuers(t *testing.T) {
+	humans := newProvider(t)
+	services := newProvider(t)
+
+	verifier, err := auth.NewVerifier([]auth.IssuerConfig{
+		{Issuer: humans.URL, Audiences: []string{"cards"}},
+		{Issuer: services.URL, Audiences: []string{"cards"}, AuthorizedParties: []string{"batch"}},
+	})
+	require.NoError(t, err)
+
+	claims, err := verifier.Verify(context.Background(), humans.sign(t, jwt.SigningMethodRS256, humans.claims("cards", "web")))
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.UserID)
+
+	claims, err = verifier.Verify(context.Background(), services.sign(t, jwt.SigningMethodRS256, services.claims("cards", "batch")))
+	require.NoError(t, err)
+	assert.Equal(t, "batch", claims.AuthorizedParty)
+}
+
+func TestVerifier_ClientRoles(t *testing.T) {
+	p := newProvider(t)
+
+	verifier, err := auth.NewVerifier([]auth.IssuerConfig{{Issuer: p.URL, Audiences: []string{"cards"}, ClientID: "yuno"}})
+	require.NoError(t, err)
+
+	claims := p.claims("cards", "web")
+	require.NoError(t, json.Unmarshal([]byte(`{"resource_access": {"yuno": {"roles": ["cards:read"]}, "other": {"roles": ["cards:write"]}}}`), claims))
+
+	verified, err := verifier.Verify(context.Background(), p.sign(t, jwt.SigningMethodRS256, claims))
+	require.NoError(t, err)
+
+	assert.True(t, verified.HasPermission("cards:read"))
+	// a role of another client of the realm grants nothing
+	assert.False(t, verified.HasPermission("cards:write"))
+}
+
+func TestVerifier_RejectsTokens(t *testing.T) {
+	trusted := newProvider(t)
+	untrusted := newProvider(t)
+
+	verifier, err := auth.NewVerifier([]auth.IssuerConfig{
+		{Issuer: trusted.URL, Audiences: []string{"cards"}, AuthorizedParties: []string{"web"}},
+	})
+	require.NoError(t, err)
+
+	// signed by the untrusted provider but claiming to come from the trusted one
+	forged := trusted.claims("cards", "web")
+	expired := trusted.claims("cards", "web")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"untrusted issuer", untrusted.sign(t, jwt.SigningMethodRS256, untrusted.claims("cards", "web")), auth.ErrUntrustedIssuer},
+		{"wrong audience", trusted.sign(t, jwt.SigningMethodRS256, trusted.claims("other", "web")), auth.ErrInvalidClaims},
+		{"wrong authorized party", trusted.sign(t, jwt.SigningMethodRS256, trusted.claims("cards", "other")), auth.ErrInvalidClaims},
+		{"algorithm not allowed", trusted.sign(t, jwt.SigningMethodRS512, trusted.claims("cards", "web")), nil},
+		{"hmac with the public key", trusted.sign(t, jwt.SigningMethodHS256, trusted.claims("cards", "web")), nil},
+		{"forged signature", untrusted.sign(t, jwt.SigningMethodRS256, forged), nil},
+		{"expired", trusted.sign(t, jwt.SigningMethodRS256, expired), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.Verify(context.Background(), tt.token)
+			require.Error(t, err)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifier_DiscoveryIssuerMismatch(t *testing.T) {
+	p := newProvider(t)
+
+	// the document announces p.URL, not the configured issuer
+	verifier, err := auth.NewVerifier([]auth.IssuerConfig{{Issuer: p.URL + "/", Audiences: []string{"cards"}}})
+	require.NoError(t, err)
+
+	claims := p.claims("cards", "web")
+	claims.Issuer = p.URL + "/"
+
+	_, err = verifier.Verify(context.Background(), p.sign(t, jwt.SigningMethodRS256, claims))
+	assert.ErrorContains(t, err, "document is for issuer")
+
+	// unless it's reached through another host
+	verifier, err = auth.NewVerifier([]auth.IssuerConfig{{Issuer: p.URL + "/", DiscoveryURL: p.URL, Audiences: []string{"cards"}}})
+	require.NoError(t, err)
+
+	_, err = verifier.Verify(context.Background(), p.sign(t, jwt.SigningMethodRS256, claims))
+	assert.NoError(t, err)
+}
+
+func TestNewVerifier_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs []auth.IssuerConfig
+	}{
+		{"no issuers", nil},
+		{"empty issuer", []auth.IssuerConfig{{Audiences: []string{"cards"}}}},
+		{"duplicated issuer", []auth.IssuerConfig{{Issuer: "https://idp", Audiences: []string{"cards"}}, {Issuer: "https://idp", Audiences: []string{"cards"}}}},
+		{"no audience", []auth.IssuerConfig{{Issuer: "https://idp"}}},
+		{"hmac algorithm", []auth.IssuerConfig{{Issuer: "https://idp", Audiences: []string{"cards"}, Algorithms: []string{"HS256"}}}},
+		{"none algorithm", []auth.IssuerConfig{{Issuer: "https://idp", Audiences: []string{"cards"}, Algorithms: []string{"none"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := auth.NewVerifier(tt.configs)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/juaguz/yuno/kit/users/dto"
+)
+
+// ProvisioningRepository creates the users seen for the first time.
+type ProvisioningRepository interface {
+	UserRepository
+	// Upsert creates the user, or updates the username and email of the one with
+	// the same issuer and external ID.
+	Upsert(ctx context.Context, user *dto.User) (*dto.User, error)
+}
+
+// WithProvisioning creates the local user of a token on its first request, from
+// its iss, sub, preferred_username and email claims, and keeps the username and email
+// in sync on later requests.
+func WithProvisioning(repo ProvisioningRepository) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.provisioning = repo
+	}
+}
+
+// provision returns the user of the claims, creating or updating it when the
+// stored one, nil when unknown, is missing or out of date.
+func provision(ctx context.Context, repo ProvisioningRepository, user *dto.User, claims *UserClaims) (*dto.User, error) {
+	username := claims.Username
+	if username == "" {
+		// service accounts may have no username
+		username = claims.UserID
+	}
+
+	if user != nil && user.Username == username && user.Email == claims.Email {
+		return user, nil
+	}
+
+	provisioned, err := repo.Upsert(ctx, &dto.User{
+		Issuer:     claims.Issuer,
+		ExternalID: claims.UserID,
+		Username:   username,
+		Email:      claims.Email,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("provisioning user %s: %w", claims.UserID, err)
+	}
+
+	return provisioned, nil
+}
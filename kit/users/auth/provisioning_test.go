@@ -0,0 +1,158 @@
+package auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"github.com/juaguz/yuno/kit/users/auth"
+	"github.com/juaguz/yuno/kit/users/dto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// userRepository keeps the users in memory, by issuer and external ID.
+type userRepository struct {
+	users   map[string]*dto.User
+	upserts int
+}
+
+func userKey(issuer string, externalID string) string {
+	return issuer + " " + externalID
+}
+
+func (r *userRepository) FindByExternalID(ctx context.Context, issuer string, externalID string) (*dto.User, error) {
+	u, ok := r.users[userKey(issuer, externalID)]
+	if !ok {
+		return nil, senital.ErrNotFound
+	}
+
+	stored := *u
+	return &stored, nil
+}
+
+func (r *userRepository) Upsert(ctx context.Context, user *dto.User) (*dto.User, error) {
+	r.upserts++
+	key := userKey(user.Issuer, user.ExternalID)
+	if existing, ok := r.users[key]; ok {
+		user.ID = existing.ID
+	} else {
+		user.ID = uuid.New()
+	}
+	r.users[key] = user
+
+	return user, nil
+}
+
+func authenticate(t *testing.T, middleware func(http.Handler) http.Handler, token string) (*dto.User, int) {
+	var user *dto.User
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, err := auth.GetUserFromContext(r.Context())
+		require.NoError(t, err)
+		user = u
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/cards", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, r)
+
+	return user, w.Code
+}
+
+func TestJWTMiddleware_Provisioning(t *testing.T) {
+	p := newProvider(t)
+	verifier, err := auth.NewVerifier([]auth.IssuerConfig{{Issuer: p.URL, Audiences: []string{"cards"}}})
+	require.NoError(t, err)
+
+	repo := &userRepository{users: map[string]*dto.User{}}
+	middleware := auth.JWTMiddleware(repo, verifier, auth.WithProvisioning(repo))
+
+	claims := p.claims("cards", "web")
+	claims.Username = "jdoe"
+	claims.Email = "jdoe@example.com"
+
+	created, status := authenticate(t, middleware, p.sign(t, jwt.SigningMethodRS256, claims))
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "jdoe", created.Username)
+	assert.Equal(t, "jdoe@example.com", created.Email)
+	assert.Equal(t, "user-1", repo.users[userKey(p.URL, "user-1")].ExternalID)
+	assert.Equal(t, p.URL, created.Issuer)
+
+	// nothing changed, nothing is written
+	_, status = authenticate(t, middleware, p.sign(t, jwt.SigningMethodRS256, claims))
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 1, repo.upserts)
+
+	claims.Email = "john@example.com"
+	updated, status := authenticate(t, middleware, p.sign(t, jwt.SigningMethodRS256, claims))
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, created.ID, updated.ID)
+	assert.Equal(t, "john@example.com", updated.Email)
+	assert.Equal(t, 2, repo.upserts)
+}
+
+func TestJWTMiddleware_ProvisioningSameSubjectOfAnotherIssuer(t *testing.T) {
+	humans := newProvider(t)
+	services := newProvider(t)
+	verifier, err := auth.NewVerifier([]auth.IssuerConfig{
+		{Issuer: humans.URL, Audiences: []string{"cards"}},
+		{Issuer: services.URL, Audiences: []string{"cards"}},
+	})
+	require.NoError(t, err)
+
+	repo := &userRepository{users: map[string]*dto.User{}}
+	middleware := auth.JWTMiddleware(repo, verifier, auth.WithProvisioning(repo))
+
+	human := humans.claims("cards", "web")
+	human.Username = "jdoe"
+	human.Email = "jdoe@example.com"
+	jdoe, status := authenticate(t, middleware, humans.sign(t, jwt.SigningMethodRS256, human))
+	require.Equal(t, http.StatusOK, status)
+
+	// both issuers use user-1 as sub, for different accounts
+	service := services.claims("cards", "batch")
+	service.Username = "batch"
+	batch, status := authenticate(t, middleware, services.sign(t, jwt.SigningMethodRS256, service))
+	require.Equal(t, http.StatusOK, status)
+
+	assert.NotEqual(t, jdoe.ID, batch.ID)
+	assert.Equal(t, "batch", batch.Username)
+
+	stored := repo.users[userKey(humans.URL, "user-1")]
+	assert.Equal(t, jdoe.ID, stored.ID)
+	assert.Equal(t, "jdoe", stored.Username)
+	assert.Equal(t, "jdoe@example.com", stored.Email)
+}
+
+func TestJWTMiddleware_ServiceAccountWithoutUsername(t *testing.T) {
+	p := newProvider(t)
+	verifier, err := auth.NewVerifier([]auth.IssuerConfig{{Issuer: p.URL, Audiences: []string{"cards"}}})
+	require.NoError(t, err)
+
+	repo := &userRepository{users: map[string]*dto.User{}}
+	middleware := auth.JWTMiddleware(repo, verifier, auth.WithProvisioning(repo))
+
+	user, status := authenticate(t, middleware, p.sign(t, jwt.SigningMethodRS256, p.claims("cards", "batch")))
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "user-1", user.Username)
+	assert.Empty(t, user.Email)
+}
+
+func TestJWTMiddleware_UnknownUserWithoutProvisioning(t *testing.T) {
+	p := newProvider(t)
+	verifier, err := auth.NewVerifier([]auth.IssuerConfig{{Issuer: p.URL, Audiences: []string{"cards"}}})
+	require.NoError(t, err)
+
+	repo := &userRepository{users: map[string]*dto.User{}}
+	middleware := auth.JWTMiddleware(repo, verifier)
+
+	_, status := authenticate(t, middleware, p.sign(t, jwt.SigningMethodRS256, p.claims("cards", "web")))
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Empty(t, repo.users)
+}
@@ -3,6 +3,9 @@ package dto
 import "github.com/google/uuid"
 
 type User struct {
-	ID       uuid.UUID `json:"id"`
-	Username string    `json:"username"`
+	ID         uuid.UUID `json:"id" gorm:"type:uuid;default:gen_random_uuid()"`
+	Issuer     string    `json:"-"`
+	ExternalID string    `json:"-" gorm:"column:user_id"`
+	Username   string    `json:"username"`
+	Email      string    `json:"email,omitempty"`
 }
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"github.com/juaguz/yuno/kit/users/dto"
+	"gorm.io/gorm"
+)
+
+const foreignKeyViolation = "23503"
+
+type APIKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+// Create stores the key, ErrNotFound when its user doesn't exist.
+func (r *APIKeyRepository) Create(ctx context.Context, key *dto.APIKey) error {
+	err := r.db.WithContext(ctx).Omit("User").Create(key).Error
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		return fmt.Errorf("user %s: %w", key.UserID, senital.ErrNotFound)
+	}
+
+	return err
+}
+
+// FindByPrefix returns the key along with its user.
+func (r *APIKeyRepository) FindByPrefix(ctx context.Context, prefix string) (*dto.APIKey, error) {
+	var key dto.APIKey
+	err := r.db.WithContext(ctx).Preload("User").Where("prefix = ?", prefix).First(&key).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, senital.ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (r *APIKeyRepository) List(ctx context.Context) ([]*dto.APIKey, error) {
+	var keys []*dto.APIKey
+	err := r.db.WithContext(ctx).Order("created_at").Find(&keys).Error
+
+	return keys, err
+}
+
+// Revoke marks the key as revoked at, ErrNotFound when unknown or already revoked.
+func (r *APIKeyRepository) Revoke(ctx context.Context, id uuid.UUID, at time.Time) error {
+	result := r.db.WithContext(ctx).Model(&dto.APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", at)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return senital.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *APIKeyRepository) Touch(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&dto.APIKey{}).Where("id = ?", id).Update("last_used_at", at).Error
+}
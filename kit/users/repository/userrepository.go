@@ -2,9 +2,12 @@ package repository
 
 import (
 	"context"
+	"errors"
 
+	"github.com/juaguz/yuno/kit/errors/senital"
 	"github.com/juaguz/yuno/kit/users/dto"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserRepository struct {
@@ -17,12 +20,31 @@ func NewUserRepository(db *gorm.DB) *UserRepository {
 	}
 }
 
-func (u *UserRepository) FindByExternalID(ctx context.Context, externalID string) (*dto.User, error) {
+// FindByExternalID returns the user with the external ID given by issuer.
+func (u *UserRepository) FindByExternalID(ctx context.Context, issuer string, externalID string) (*dto.User, error) {
 	var user dto.User
-	err := u.db.WithContext(ctx).Where("user_id = ?", externalID).First(&user).Error
+	err := u.db.WithContext(ctx).Where("issuer = ? AND user_id = ?", issuer, externalID).First(&user).Error
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, senital.ErrNotFound
+		}
 		return nil, err
 	}
 
 	return &user, nil
 }
+
+// Upsert creates the user, or updates the username and email of the one with the
+// same issuer and external ID. A user of another issuer with the same external ID
+// is a different one and is never changed.
+func (u *UserRepository) Upsert(ctx context.Context, user *dto.User) (*dto.User, error) {
+	err := u.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "issuer"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"username", "email"}),
+	}).Create(user).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -2,20 +2,30 @@ package vault
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 
 	vault "github.com/hashicorp/vault/api"
+	"github.com/juaguz/yuno/kit/errors/senital"
 )
 
 type VaultService struct {
-	client   *vault.Client
-	basePath string
+	client       *vault.Client
+	basePath     string
+	metadataPath string
+	destroyPath  string
 }
 
 func NewVaultService(client *vault.Client) *VaultService {
 	return &VaultService{
-		client:   client,
-		basePath: "secret/data",
+		client:       client,
+		basePath:     "secret/data",
+		metadataPath: "secret/metadata",
+		destroyPath:  "secret/destroy",
 	}
 }
 
@@ -28,18 +38,124 @@ func (v *VaultService) Create(ctx context.Context, data map[string]interface{},
 
 	_, err := v.client.Logical().Write(fullPath, secretData)
 	if err != nil {
-		return fmt.Errorf("error al crear el secreto en Vault: %w", err)
+		return vaultError("creating secret in Vault", err)
 	}
 
 	return nil
 }
 
+// Get returns the data stored under key, or senital.ErrNotFound when there is no secret.
+func (v *VaultService) Get(ctx context.Context, key string) (map[string]interface{}, error) {
+	fullPath := fmt.Sprintf("%s/%s", v.basePath, key)
+	secret, err := v.client.Logical().Read(fullPath)
+	if err != nil {
+		return nil, vaultError("reading secret from Vault", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, senital.ErrNotFound
+	}
+
+	data, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		// deleted versions keep their metadata but have no data
+		return nil, senital.ErrNotFound
+	}
+
+	return data, nil
+}
+
 func (v *VaultService) Delete(ctx context.Context, key string) error {
 	fullPath := fmt.Sprintf("%s/%s", v.basePath, key)
 	_, err := v.client.Logical().Delete(fullPath)
 	if err != nil {
-		return fmt.Errorf("error al eliminar el secreto en Vault: %w", err)
+		return vaultError("deleting secret from Vault", err)
 	}
 
 	return nil
 }
+
+// DestroyPreviousVersions permanently removes every version of the secret under
+// key but the current one. KV v2 keeps the previous versions readable otherwise.
+func (v *VaultService) DestroyPreviousVersions(ctx context.Context, key string) error {
+	metadataPath := fmt.Sprintf("%s/%s", v.metadataPath, strings.Trim(key, "/"))
+	secret, err := v.client.Logical().Read(metadataPath)
+	if err != nil {
+		return vaultError("reading secret metadata from Vault", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil
+	}
+
+	current, ok := secret.Data["current_version"].(json.Number)
+	if !ok {
+		return fmt.Errorf("can't get current version of %s from Vault", key)
+	}
+
+	all, _ := secret.Data["versions"].(map[string]interface{})
+	versions := make([]int, 0, len(all))
+	for name, meta := range all {
+		if name == current.String() {
+			continue
+		}
+		if meta, ok := meta.(map[string]interface{}); ok && meta["destroyed"] == true {
+			continue
+		}
+
+		version, err := strconv.Atoi(name)
+		if err != nil {
+			return fmt.Errorf("can't parse version %q of %s from Vault", name, key)
+		}
+		versions = append(versions, version)
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+
+	destroyPath := fmt.Sprintf("%s/%s", v.destroyPath, strings.Trim(key, "/"))
+	_, err = v.client.Logical().Write(destroyPath, map[string]interface{}{"versions": versions})
+	if err != nil {
+		return vaultError("destroying secret versions in Vault", err)
+	}
+
+	return nil
+}
+
+// List returns the names stored right under key, folders end with "/". Secrets
+// whose versions were all deleted are still listed until their metadata is removed.
+func (v *VaultService) List(ctx context.Context, key string) ([]string, error) {
+	fullPath := fmt.Sprintf("%s/%s", v.metadataPath, strings.Trim(key, "/"))
+	secret, err := v.client.Logical().List(fullPath)
+	if err != nil {
+		return nil, vaultError("listing secrets in Vault", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+
+	keys, ok := secret.Data["keys"].([]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if name, ok := k.(string); ok {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+// vaultError classifies err, returned by Vault while doing action. Only the
+// failures to reach Vault and its 5xx and 429 answers are senital.ErrUnavailable,
+// retrying them may succeed. A 4xx, like a denied token, is a fault of the
+// service itself.
+func vaultError(action string, err error) error {
+	var respErr *vault.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode < http.StatusInternalServerError && respErr.StatusCode != http.StatusTooManyRequests {
+		return fmt.Errorf("%s: %w", action, err)
+	}
+
+	return fmt.Errorf("%w: %s: %w", senital.ErrUnavailable, action, err)
+}
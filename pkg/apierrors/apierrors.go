@@ -0,0 +1,74 @@
+// Package apierrors maps the errors of the services to the problem details the
+// handlers answer with.
+package apierrors
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/juaguz/yuno/internal/cards"
+	"github.com/juaguz/yuno/internal/cards/validation"
+	"github.com/juaguz/yuno/internal/relay"
+	"github.com/juaguz/yuno/kit/errors/problem"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"github.com/juaguz/yuno/kit/kms"
+	"github.com/juaguz/yuno/kit/users/auth"
+	"gorm.io/gorm"
+)
+
+// batchRetryAfter is how long clients are asked to wait before submitting a
+// batch again once the queue is saturated.
+const batchRetryAfter = 30 * time.Second
+
+// mapper is checked in order, so specific errors go before the generic sentinels
+// they may wrap. Messages are only exposed for errors meant for clients.
+var mapper = problem.NewMapper(
+	problem.Mapping{Err: validation.ErrInvalidPan, Status: http.StatusUnprocessableEntity, Expose: true, Extensions: panExtensions},
+	problem.Mapping{Err: cards.ErrInvalidKeyVersion, Status: http.StatusBadRequest, Expose: true},
+	problem.Mapping{Err: cards.ErrKeyVersionTooOld, Status: http.StatusBadRequest, Expose: true},
+	problem.Mapping{Err: cards.ErrInvalidExpiry, Status: http.StatusBadRequest, Expose: true},
+	problem.Mapping{Err: cards.ErrInvalidFilter, Status: http.StatusBadRequest, Expose: true},
+	problem.Mapping{Err: cards.ErrInvalidCursor, Status: http.StatusBadRequest, Expose: true},
+	problem.Mapping{Err: cards.ErrEmptyBatch, Status: http.StatusBadRequest, Expose: true},
+	problem.Mapping{Err: cards.ErrInvalidBatchItem, Status: http.StatusBadRequest, Expose: true},
+	problem.Mapping{Err: cards.ErrBatchTooLarge, Status: http.StatusRequestEntityTooLarge, Expose: true},
+	problem.Mapping{Err: cards.ErrTooManyBatchJobs, Status: http.StatusTooManyRequests, Expose: true, RetryAfter: batchRetryAfter},
+	problem.Mapping{Err: cards.ErrBatchQueueFull, Status: http.StatusServiceUnavailable, Detail: "too many batches are queued, try again later", RetryAfter: batchRetryAfter},
+	problem.Mapping{Err: cards.ErrDuplicateCard, Status: http.StatusConflict, Expose: true},
+	problem.Mapping{Err: kms.ErrInvalidCiphertext, Status: http.StatusBadRequest, Detail: "the PAN can't be decrypted with the key"},
+	problem.Mapping{Err: kms.ErrKeyNotFound, Status: http.StatusNotFound, Detail: "key not found, create one first"},
+	problem.Mapping{Err: relay.ErrInvalidRequest, Status: http.StatusBadRequest, Expose: true},
+	problem.Mapping{Err: relay.ErrUnknownPlaceholder, Status: http.StatusBadRequest, Expose: true},
+	problem.Mapping{Err: relay.ErrHostNotAllowed, Status: http.StatusForbidden, Expose: true},
+	problem.Mapping{Err: relay.ErrUpstream, Status: http.StatusBadGateway, Detail: "upstream request failed"},
+	problem.Mapping{Err: auth.ErrInvalidIssueRequest, Status: http.StatusBadRequest, Expose: true},
+	problem.Mapping{Err: senital.ErrNotFound, Status: http.StatusNotFound, Detail: "resource not found"},
+	problem.Mapping{Err: gorm.ErrRecordNotFound, Status: http.StatusNotFound, Detail: "resource not found"},
+	problem.Mapping{Err: senital.ErrForbidden, Status: http.StatusForbidden, Detail: "missing permission", Extensions: forbiddenExtensions},
+	problem.Mapping{Err: senital.ErrUnauthenticated, Status: http.StatusUnauthorized, Detail: "authentication required"},
+	problem.Mapping{Err: senital.ErrUnavailable, Status: http.StatusServiceUnavailable, Detail: "a service the request depends on is unavailable, try again later"},
+)
+
+// Write answers the request with the problem err maps to.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	mapper.Write(w, r, err)
+}
+
+func panExtensions(err error) map[string]interface{} {
+	var validationErr *validation.Error
+	if !errors.As(err, &validationErr) {
+		return nil
+	}
+
+	extensions := map[string]interface{}{"reason": string(validationErr.Reason)}
+	if validationErr.Network != "" {
+		extensions["network"] = string(validationErr.Network)
+	}
+
+	return extensions
+}
+
+func forbiddenExtensions(err error) map[string]interface{} {
+	return map[string]interface{}{"reason": auth.ReasonMissingPermission}
+}
@@ -0,0 +1,101 @@
+package apierrors_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/juaguz/yuno/internal/cards"
+	"github.com/juaguz/yuno/internal/cards/validation"
+	"github.com/juaguz/yuno/kit/errors/senital"
+	"github.com/juaguz/yuno/kit/kms"
+	"github.com/juaguz/yuno/pkg/apierrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		detail     string
+		reason     string
+		retryAfter string
+	}{
+		{
+			name:   "invalid pan",
+			err:    fmt.Errorf("creating card: %w", &validation.Error{Reason: validation.ReasonLuhnCheck, Network: validation.Visa}),
+			status: http.StatusUnprocessableEntity,
+			detail: "creating card: invalid pan: luhn_check_failed for visa",
+			reason: string(validation.ReasonLuhnCheck),
+		},
+		{
+			name:   "duplicate card",
+			err:    cards.ErrDuplicateCard,
+			status: http.StatusConflict,
+			detail: "card already stored",
+		},
+		{
+			name:   "not found hides the resource",
+			err:    fmt.Errorf("card 42: %w", senital.ErrNotFound),
+			status: http.StatusNotFound,
+			detail: "resource not found",
+		},
+		{
+			name:   "missing key",
+			err:    fmt.Errorf("getting public key: %w", kms.ErrKeyNotFound),
+			status: http.StatusNotFound,
+			detail: "key not found, create one first",
+		},
+		{
+			name:   "vault errors don't leak",
+			err:    fmt.Errorf("%w: reading secret from Vault: permission denied on secret/data/42", senital.ErrUnavailable),
+			status: http.StatusServiceUnavailable,
+			detail: "a service the request depends on is unavailable, try again later",
+		},
+		{
+			name:       "saturated batch queue",
+			err:        fmt.Errorf("%w: 100000 items queued", cards.ErrBatchQueueFull),
+			status:     http.StatusServiceUnavailable,
+			detail:     "too many batches are queued, try again later",
+			retryAfter: "30",
+		},
+		{
+			name:   "unknown errors don't leak",
+			err:    errors.New(`pq: relation "cards" does not exist`),
+			status: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/cards", nil)
+			w := httptest.NewRecorder()
+
+			apierrors.Write(w, r, tt.err)
+
+			require.Equal(t, tt.status, w.Code)
+			assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+
+			var body map[string]interface{}
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+			assert.Equal(t, "about:blank", body["type"])
+			assert.Equal(t, http.StatusText(tt.status), body["title"])
+			assert.EqualValues(t, tt.status, body["status"])
+			assert.Equal(t, "/cards", body["instance"])
+			if tt.detail == "" {
+				assert.NotContains(t, body, "detail")
+			} else {
+				assert.Equal(t, tt.detail, body["detail"])
+			}
+			if tt.reason != "" {
+				assert.Equal(t, tt.reason, body["reason"])
+			}
+			assert.Equal(t, tt.retryAfter, w.Header().Get("Retry-After"))
+		})
+	}
+}
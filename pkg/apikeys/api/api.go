@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/kit/errors/problem"
+	"github.com/juaguz/yuno/kit/users/auth"
+	"github.com/juaguz/yuno/pkg/apierrors"
+)
+
+// PermissionAPIKeysAdmin is required to issue, list and revoke API keys.
+const PermissionAPIKeysAdmin = auth.PermissionAPIKeysAdmin
+
+type APIKeysHandler struct {
+	Service *auth.APIKeys
+}
+
+func NewAPIKeysHandler(service *auth.APIKeys) *APIKeysHandler {
+	return &APIKeysHandler{Service: service}
+}
+
+// Routes configures the routes for APIKeysHandler
+func (h *APIKeysHandler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(auth.Require(PermissionAPIKeysAdmin))
+
+	r.Post("/", h.IssueKey)
+	r.Get("/", h.ListKeys)
+	r.Delete("/{keyID}", h.RevokeKey)
+
+	return r
+}
+
+// IssueKey godoc
+// @Summary Issue an API key
+// @Description Creates an API key a service can authenticate with in the X-API-Key header. The key is only returned by this call. It can only grant scopes the caller holds, and never apikeys:admin.
+// @Tags api-keys
+// @Accept json
+// @Produce json
+// @Param request body IssueRequest true "Issue Request"
+// @Success 201 {object} IssueResponse
+// @Failure 400 {object} problem.Problem "Invalid request body or apikeys:admin among the scopes"
+// @Failure 403 {object} problem.Problem "Missing the apikeys:admin permission or a scope the key would grant"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /api-keys [post]
+// @Security Bearer
+// @Security ApiKey
+func (h *APIKeysHandler) IssueKey(w http.ResponseWriter, r *http.Request) {
+	var body IssueRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	userID := user.ID
+	if body.UserID != nil {
+		userID = *body.UserID
+	}
+
+	raw, key, err := h.Service.Issue(r.Context(), userID, body.Name, body.Scopes, body.ExpiresAt)
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&IssueResponse{Key: raw, APIKey: key})
+}
+
+// ListKeys godoc
+// @Summary List API keys
+// @Description Returns every API key, revoked and expired ones included, without their secret
+// @Tags api-keys
+// @Produce json
+// @Success 200 {array} dto.APIKey
+// @Failure 403 {object} problem.Problem "Missing the apikeys:admin permission"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /api-keys [get]
+// @Security Bearer
+// @Security ApiKey
+func (h *APIKeysHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.Service.List(r.Context())
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeKey godoc
+// @Summary Revoke an API key
+// @Description Rejects the API key from now on
+// @Tags api-keys
+// @Param keyID path string true "API Key ID"
+// @Success 204 "No content"
+// @Failure 400 {object} problem.Problem "Invalid key ID"
+// @Failure 403 {object} problem.Problem "Missing the apikeys:admin permission"
+// @Failure 404 {object} problem.Problem "Key not found or already revoked"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /api-keys/{keyID} [delete]
+// @Security Bearer
+// @Security ApiKey
+func (h *APIKeysHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := uuid.Parse(chi.URLParam(r, "keyID"))
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "invalid key ID")
+		return
+	}
+
+	if err := h.Service.Revoke(r.Context(), keyID); err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
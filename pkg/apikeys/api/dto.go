@@ -0,0 +1,22 @@
+package api
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/kit/users/dto"
+)
+
+type IssueRequest struct {
+	// UserID is the user the key authenticates as, the caller when empty
+	UserID    *uuid.UUID `json:"user_id,omitempty"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type IssueResponse struct {
+	// Key is only returned once, it can't be recovered afterwards
+	Key    string      `json:"key"`
+	APIKey *dto.APIKey `json:"api_key"`
+}
@@ -3,20 +3,29 @@ package api
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/juaguz/yuno/internal/cards/dtos"
-	"github.com/juaguz/yuno/kit/errors/senital"
+	"github.com/juaguz/yuno/kit/errors/problem"
 	"github.com/juaguz/yuno/kit/users/auth"
+	"github.com/juaguz/yuno/pkg/apierrors"
+)
+
+// Permissions the card routes require from the token, as scopes or roles.
+const (
+	PermissionCardsRead  = "cards:read"
+	PermissionCardsWrite = "cards:write"
 )
 
 type CardHandler struct {
-	Service            Service[dtos.Card]
-	BatchUpdateService BatchUpdate
+	Service   Service[dtos.Card]
+	BatchJobs BatchJobs
+	Revealer  Revealer
+	Lister    Lister
 }
 
 type Service[T any] interface {
@@ -26,23 +35,43 @@ type Service[T any] interface {
 	Get(ctx context.Context, entity *T) (*T, error)
 }
 
-type BatchUpdate interface {
-	Update(ctx context.Context, userID uuid.UUID, cards []*dtos.BatchUpdate) ([]*dtos.BatchUpdateStatus, error)
+type BatchJobs interface {
+	Submit(ctx context.Context, userID uuid.UUID, cards []*dtos.BatchUpdate, atomic bool) (*dtos.BatchJob, error)
+	SubmitCreate(ctx context.Context, userID uuid.UUID, cards []*dtos.BatchCreate) (*dtos.BatchJob, error)
+	SubmitDelete(ctx context.Context, userID uuid.UUID, cards []*dtos.BatchDelete) (*dtos.BatchJob, error)
+	Get(ctx context.Context, userID uuid.UUID, jobID uuid.UUID) (*dtos.BatchJob, error)
+}
+
+type Revealer interface {
+	Reveal(ctx context.Context, card *dtos.Card) (*dtos.RevealedCard, error)
 }
 
-func NewCardHandler(service Service[dtos.Card], batchUpdate BatchUpdate) *CardHandler {
-	return &CardHandler{Service: service, BatchUpdateService: batchUpdate}
+type Lister interface {
+	List(ctx context.Context, filter *dtos.CardFilter, cursor string) (*dtos.CardPage, error)
+}
+
+func NewCardHandler(service Service[dtos.Card], batchJobs BatchJobs, revealer Revealer, lister Lister) *CardHandler {
+	return &CardHandler{Service: service, BatchJobs: batchJobs, Revealer: revealer, Lister: lister}
 }
 
 // Routes configures the routes for CardHandler
 func (h *CardHandler) Routes() chi.Router {
 	r := chi.NewRouter()
 
-	r.Post("/", h.CreateCard)
-	r.Get("/{cardID}", h.GetCard)
-	r.Put("/{cardID}", h.UpdateCard)
-	r.Delete("/{cardID}", h.DeleteCard)
-	r.Put("/batch", h.BatchUpdate)
+	read := auth.Require(PermissionCardsRead)
+	write := auth.Require(PermissionCardsWrite)
+
+	r.With(write).Post("/", h.CreateCard)
+	r.With(read).Get("/", h.ListCards)
+	r.With(read).Get("/{cardID}", h.GetCard)
+	r.With(write).Put("/{cardID}", h.UpdateCard)
+	r.With(write).Delete("/{cardID}", h.DeleteCard)
+	// the Revealer checks its own, configurable, permission
+	r.Post("/{cardID}/reveal", h.RevealCard)
+	r.With(write).Post("/batch", h.BatchCreate)
+	r.With(write).Put("/batch", h.BatchUpdate)
+	r.With(write).Delete("/batch", h.BatchDelete)
+	r.With(read).Get("/batch/{jobID}", h.GetBatchJob)
 
 	return r
 }
@@ -55,40 +84,104 @@ func (h *CardHandler) Routes() chi.Router {
 // @Produce json
 // @Param card body CardCreation true "Card Creation Request"
 // @Success 201 {object} dtos.Card
-// @Failure 400 {string} string "Invalid request body"
-// @Failure 500 {string} string "Internal server error"
+// @Success 200 {object} dtos.Card "The PAN was already stored, the existing card is returned"
+// @Failure 400 {object} problem.Problem "Invalid request body"
+// @Failure 409 {object} problem.Problem "The PAN was already stored"
+// @Failure 422 {object} problem.Problem "Invalid PAN"
+// @Failure 403 {object} problem.Problem "Missing the cards:write permission"
+// @Failure 500 {object} problem.Problem "Internal server error"
 // @Router /cards [post]
 // @Security Bearer
+// @Security ApiKey
 func (h *CardHandler) CreateCard(w http.ResponseWriter, r *http.Request) {
 	card := &CardCreation{}
 	if err := json.NewDecoder(r.Body).Decode(card); err != nil {
 		log.Printf("error %s", err.Error())
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
 	user, err := auth.GetUserFromContext(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierrors.Write(w, r, err)
 		return
 	}
 
 	c := dtos.Card{
-		CardHolder: card.CardHolder,
-		Pan:        card.Pan,
-		UserId:     user.ID,
+		CardHolder:  card.CardHolder,
+		Pan:         card.Pan,
+		UserId:      user.ID,
+		KeyVersion:  card.KeyVersion,
+		ExpiryMonth: card.ExpiryMonth,
+		ExpiryYear:  card.ExpiryYear,
 	}
 	res, err := h.Service.Create(r.Context(), &c)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierrors.Write(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	// with the return_existing policy the card stored before comes back instead of a new one
+	if res.ID != c.ID {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
 
 	json.NewEncoder(w).Encode(res)
 }
 
+// ListCards godoc
+// @Summary List cards
+// @Description List the cards of the authenticated user sorted by creation date
+// @Tags cards
+// @Produce json
+// @Param limit query int false "Page size, 20 by default and at most 100"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param order query string false "Sort order by created_at" Enums(asc, desc)
+// @Param last_digits query string false "Filter by last digits"
+// @Param card_holder query string false "Filter by card holder, case insensitive partial match"
+// @Param brand query string false "Filter by brand"
+// @Success 200 {object} dtos.CardPage
+// @Failure 400 {object} problem.Problem "Invalid filter or cursor"
+// @Failure 403 {object} problem.Problem "Missing the cards:read permission"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /cards [get]
+// @Security Bearer
+// @Security ApiKey
+func (h *CardHandler) ListCards(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := &dtos.CardFilter{
+		UserID:     user.ID,
+		LastDigits: query.Get("last_digits"),
+		CardHolder: query.Get("card_holder"),
+		Brand:      query.Get("brand"),
+		Order:      dtos.SortOrder(query.Get("order")),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			problem.Error(w, r, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	page, err := h.Lister.List(r.Context(), filter, query.Get("cursor"))
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(page)
+}
+
 // GetCard godoc
 // @Summary Get a card
 // @Description Retrieve a card by its ID
@@ -96,21 +189,23 @@ func (h *CardHandler) CreateCard(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param cardID path string true "Card ID"
 // @Success 200 {object} dtos.Card
-// @Failure 400 {string} string "Invalid card ID"
-// @Failure 404 {string} string "Card not found"
-// @Failure 500 {string} string "Internal server error"
+// @Failure 400 {object} problem.Problem "Invalid card ID"
+// @Failure 404 {object} problem.Problem "Card not found"
+// @Failure 403 {object} problem.Problem "Missing the cards:read permission"
+// @Failure 500 {object} problem.Problem "Internal server error"
 // @Router /cards/{cardID} [get]
 // @Security Bearer
+// @Security ApiKey
 func (h *CardHandler) GetCard(w http.ResponseWriter, r *http.Request) {
 	cardID, err := uuid.Parse(chi.URLParam(r, "cardID"))
 	if err != nil {
-		http.Error(w, "invalid card ID", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, "invalid card ID")
 		return
 	}
 
 	user, err := auth.GetUserFromContext(r.Context())
 	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		apierrors.Write(w, r, err)
 		return
 	}
 
@@ -121,11 +216,7 @@ func (h *CardHandler) GetCard(w http.ResponseWriter, r *http.Request) {
 
 	card, err := h.Service.Get(r.Context(), c)
 	if err != nil {
-		if errors.Is(err, senital.ErrNotFound) {
-			http.Error(w, "card not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+		apierrors.Write(w, r, err)
 		return
 	}
 
@@ -140,26 +231,29 @@ func (h *CardHandler) GetCard(w http.ResponseWriter, r *http.Request) {
 // @Param cardID path string true "Card ID"
 // @Param card body CardUpdate true "Card Update Request"
 // @Success 204 "No content"
-// @Failure 400 {string} string "Invalid request body or card ID"
-// @Failure 500 {string} string "Internal server error"
+// @Failure 400 {object} problem.Problem "Invalid request body or card ID"
+// @Failure 404 {object} problem.Problem "Card not found"
+// @Failure 403 {object} problem.Problem "Missing the cards:write permission"
+// @Failure 500 {object} problem.Problem "Internal server error"
 // @Router /cards/{cardID} [put]
 // @Security Bearer
+// @Security ApiKey
 func (h *CardHandler) UpdateCard(w http.ResponseWriter, r *http.Request) {
 	var body *CardUpdate
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
 	cardID, err := uuid.Parse(chi.URLParam(r, "cardID"))
 	if err != nil {
-		http.Error(w, "invalid card ID", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, "invalid card ID")
 		return
 	}
 
 	user, err := auth.GetUserFromContext(r.Context())
 	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		apierrors.Write(w, r, err)
 		return
 	}
 
@@ -170,7 +264,7 @@ func (h *CardHandler) UpdateCard(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.Service.Update(r.Context(), &card); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierrors.Write(w, r, err)
 		return
 	}
 
@@ -183,20 +277,23 @@ func (h *CardHandler) UpdateCard(w http.ResponseWriter, r *http.Request) {
 // @Tags cards
 // @Param cardID path string true "Card ID"
 // @Success 204 "No content"
-// @Failure 400 {string} string "Invalid card ID"
-// @Failure 500 {string} string "Internal server error"
+// @Failure 400 {object} problem.Problem "Invalid card ID"
+// @Failure 404 {object} problem.Problem "Card not found"
+// @Failure 403 {object} problem.Problem "Missing the cards:write permission"
+// @Failure 500 {object} problem.Problem "Internal server error"
 // @Router /cards/{cardID} [delete]
 // @Security Bearer
+// @Security ApiKey
 func (h *CardHandler) DeleteCard(w http.ResponseWriter, r *http.Request) {
 	cardID, err := uuid.Parse(chi.URLParam(r, "cardID"))
 	if err != nil {
-		http.Error(w, "invalid card ID", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, "invalid card ID")
 		return
 	}
 
 	user, err := auth.GetUserFromContext(r.Context())
 	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		apierrors.Write(w, r, err)
 		return
 	}
 
@@ -206,43 +303,216 @@ func (h *CardHandler) DeleteCard(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.Service.Delete(r.Context(), c); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierrors.Write(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// RevealCard godoc
+// @Summary Reveal a card PAN
+// @Description Returns the clear PAN of a card. Requires the reveal permission in the token and every call is audited.
+// @Tags cards
+// @Produce json
+// @Param cardID path string true "Card ID"
+// @Success 200 {object} dtos.RevealedCard
+// @Failure 400 {object} problem.Problem "Invalid card ID"
+// @Failure 403 {object} problem.Problem "Missing the reveal permission"
+// @Failure 404 {object} problem.Problem "Card not found"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /cards/{cardID}/reveal [post]
+// @Security Bearer
+// @Security ApiKey
+func (h *CardHandler) RevealCard(w http.ResponseWriter, r *http.Request) {
+	cardID, err := uuid.Parse(chi.URLParam(r, "cardID"))
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "invalid card ID")
+		return
+	}
+
+	user, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	c := &dtos.Card{
+		ID:     cardID,
+		UserId: user.ID,
+	}
+
+	card, err := h.Revealer.Reveal(r.Context(), c)
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(card)
+}
+
 // BatchUpdate godoc
 // @Summary Batch update cards
-// @Description Update multiple cards in a single request
+// @Description Queues the update of multiple cards, the updates are applied in the background and their outcome is available at /cards/batch/{jobID}
+// @Description With atomic=true the updates are applied in a single transaction, when one fails none is applied
 // @Tags cards
 // @Accept json
 // @Produce json
 // @Param batch body []dtos.BatchUpdate true "Batch Update Request"
-// @Success 200 {array} dtos.BatchUpdateStatus
-// @Failure 400 {string} string "Invalid request body"
-// @Failure 500 {string} string "Internal server error"
+// @Param atomic query bool false "Apply all the updates or none of them"
+// @Success 202 {object} dtos.BatchJob
+// @Header 202 {string} Location "URL of the job"
+// @Failure 400 {object} problem.Problem "Invalid request body, or an item of an atomic batch has no card ID"
+// @Failure 403 {object} problem.Problem "Missing the cards:write permission"
+// @Failure 500 {object} problem.Problem "Internal server error"
 // @Router /cards/batch [put]
 // @Security Bearer
+// @Security ApiKey
 func (h *CardHandler) BatchUpdate(w http.ResponseWriter, r *http.Request) {
 	var batch []*dtos.BatchUpdate
 	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	atomic := false
+	if v := r.URL.Query().Get("atomic"); v != "" {
+		atomic, err = strconv.ParseBool(v)
+		if err != nil {
+			problem.Error(w, r, http.StatusBadRequest, "invalid atomic")
+			return
+		}
+	}
+
+	job, err := h.BatchJobs.Submit(r.Context(), user.ID, batch, atomic)
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	writeBatchJob(w, job)
+}
+
+// BatchCreate godoc
+// @Summary Batch create cards
+// @Description Queues the creation of multiple cards, each one is created as with [POST] /cards and their outcome is available at /cards/batch/{jobID}
+// @Tags cards
+// @Accept json
+// @Produce json
+// @Param batch body []dtos.BatchCreate true "Batch Create Request"
+// @Success 202 {object} dtos.BatchJob
+// @Header 202 {string} Location "URL of the job"
+// @Failure 400 {object} problem.Problem "Invalid request body"
+// @Failure 403 {object} problem.Problem "Missing the cards:write permission"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /cards/batch [post]
+// @Security Bearer
+// @Security ApiKey
+func (h *CardHandler) BatchCreate(w http.ResponseWriter, r *http.Request) {
+	var batch []*dtos.BatchCreate
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	job, err := h.BatchJobs.SubmitCreate(r.Context(), user.ID, batch)
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	writeBatchJob(w, job)
+}
+
+// BatchDelete godoc
+// @Summary Batch delete cards
+// @Description Queues the deletion of multiple cards and their PANs, each one is deleted as with [DELETE] /cards/{cardID} and their outcome is available at /cards/batch/{jobID}
+// @Tags cards
+// @Accept json
+// @Produce json
+// @Param batch body []dtos.BatchDelete true "Batch Delete Request"
+// @Success 202 {object} dtos.BatchJob
+// @Header 202 {string} Location "URL of the job"
+// @Failure 400 {object} problem.Problem "Invalid request body"
+// @Failure 403 {object} problem.Problem "Missing the cards:write permission"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /cards/batch [delete]
+// @Security Bearer
+// @Security ApiKey
+func (h *CardHandler) BatchDelete(w http.ResponseWriter, r *http.Request) {
+	var batch []*dtos.BatchDelete
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	job, err := h.BatchJobs.SubmitDelete(r.Context(), user.ID, batch)
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	writeBatchJob(w, job)
+}
+
+// writeBatchJob answers with the job just queued, and where to poll it.
+func writeBatchJob(w http.ResponseWriter, job *dtos.BatchJob) {
+	w.Header().Set("Location", "/cards/batch/"+job.ID.String())
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetBatchJob godoc
+// @Summary Get a batch job
+// @Description Returns the progress of a batch job and the status of each of its cards, in request order
+// @Tags cards
+// @Produce json
+// @Param jobID path string true "Job ID"
+// @Success 200 {object} dtos.BatchJob
+// @Failure 400 {object} problem.Problem "Invalid job ID"
+// @Failure 404 {object} problem.Problem "Job not found"
+// @Failure 403 {object} problem.Problem "Missing the cards:read permission"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /cards/batch/{jobID} [get]
+// @Security Bearer
+// @Security ApiKey
+func (h *CardHandler) GetBatchJob(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(chi.URLParam(r, "jobID"))
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "invalid job ID")
 		return
 	}
 
 	user, err := auth.GetUserFromContext(r.Context())
 	if err != nil {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		apierrors.Write(w, r, err)
 		return
 	}
 
-	statuses, err := h.BatchUpdateService.Update(r.Context(), user.ID, batch)
+	job, err := h.BatchJobs.Get(r.Context(), user.ID, jobID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierrors.Write(w, r, err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(statuses)
+	json.NewEncoder(w).Encode(job)
 }
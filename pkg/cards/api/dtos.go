@@ -3,6 +3,10 @@ package api
 type CardCreation struct {
 	CardHolder string `json:"card_holder"`
 	Pan        string `json:"pan"`
+	// KeyVersion is the version of the key the PAN was encrypted with, 1 when omitted
+	KeyVersion  int `json:"key_version"`
+	ExpiryMonth int `json:"expiry_month"`
+	ExpiryYear  int `json:"expiry_year"`
 }
 
 type CardUpdate struct {
@@ -1,27 +1,59 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/internal/cards/dtos"
 	"github.com/juaguz/yuno/internal/keys"
+	"github.com/juaguz/yuno/kit/errors/problem"
 	"github.com/juaguz/yuno/kit/users/auth"
+	"github.com/juaguz/yuno/pkg/apierrors"
+)
+
+// Permissions the key routes require from the token, as scopes or roles.
+const (
+	PermissionKeysRead   = "keys:read"
+	PermissionKeysCreate = "keys:create"
+	PermissionKeysRotate = "keys:rotate"
+	// PermissionKeysAdmin lets operators rewrap the cards of any user.
+	PermissionKeysAdmin = "keys:admin"
 )
 
 type KeysHandler struct {
 	Service *keys.KeysProvider
+	Rewrap  RewrapScheduler
+}
+
+type RewrapScheduler interface {
+	Schedule(ctx context.Context, userID uuid.UUID) (*dtos.RewrapJob, error)
+	Get(ctx context.Context, userID uuid.UUID, jobID uuid.UUID) (*dtos.RewrapJob, error)
+	GetJob(ctx context.Context, jobID uuid.UUID) (*dtos.RewrapJob, error)
 }
 
-func NewKeysHandler(service *keys.KeysProvider) *KeysHandler {
-	return &KeysHandler{Service: service}
+func NewKeysHandler(service *keys.KeysProvider, rewrap RewrapScheduler) *KeysHandler {
+	return &KeysHandler{Service: service, Rewrap: rewrap}
 }
 
 // Routes configures the routes for KeysHandler
 func (h *KeysHandler) Routes() chi.Router {
 	r := chi.NewRouter()
 
-	r.Post("/", h.CreateKey)
+	read := auth.Require(PermissionKeysRead)
+	rotate := auth.Require(PermissionKeysRotate)
+
+	r.With(auth.Require(PermissionKeysCreate)).Post("/", h.CreateKey)
+	r.With(read).Get("/", h.GetKey)
+	r.With(rotate).Post("/rotate", h.RotateKey)
+	r.With(rotate).Post("/rewrap", h.ScheduleRewrap)
+	r.With(read).Get("/rewrap/{jobID}", h.GetRewrap)
+
+	admin := auth.Require(PermissionKeysAdmin)
+	r.With(admin).Post("/admin/users/{userID}/rewrap", h.ScheduleUserRewrap)
+	r.With(admin).Get("/admin/rewrap/{jobID}", h.GetAnyRewrap)
 
 	return r
 }
@@ -32,26 +64,217 @@ func (h *KeysHandler) Routes() chi.Router {
 // @Tags keys
 // @Produce json
 // @Success 201 {object} KeysResponse
-// @Failure 500 {string} string "Internal server error"
+// @Failure 403 {object} problem.Problem "Missing the keys:create permission"
+// @Failure 500 {object} problem.Problem "Internal server error"
 // @Router /keys [post]
 // @Security Bearer
+// @Security ApiKey
 func (h *KeysHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
 	user, err := auth.GetUserFromContext(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierrors.Write(w, r, err)
 		return
 	}
 
 	key, err := h.Service.CreateKey(r.Context(), user.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apierrors.Write(w, r, err)
 		return
 	}
 
-	k := &KeysResponse{
-		PublicKey: key,
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newKeysResponse(key))
+}
+
+// GetKey godoc
+// @Summary Get the current key
+// @Description Returns the public key and version clients must encrypt with
+// @Tags keys
+// @Produce json
+// @Success 200 {object} KeysResponse
+// @Failure 403 {object} problem.Problem "Missing the keys:read permission"
+// @Failure 404 {object} problem.Problem "The user has no key"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /keys [get]
+// @Security Bearer
+// @Security ApiKey
+func (h *KeysHandler) GetKey(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(k)
+	key, err := h.Service.GetPublicKey(r.Context(), user.ID)
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(newKeysResponse(key))
+}
+
+// RotateKey godoc
+// @Summary Rotate the key
+// @Description Creates a new version of the authenticated user's key and schedules the rewrap of the stored cards. Previous versions can still be used for decryption.
+// @Tags keys
+// @Produce json
+// @Success 200 {object} KeysResponse
+// @Failure 403 {object} problem.Problem "Missing the keys:rotate permission"
+// @Failure 404 {object} problem.Problem "The user has no key"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /keys/rotate [post]
+// @Security Bearer
+// @Security ApiKey
+func (h *KeysHandler) RotateKey(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	key, err := h.Service.RotateKey(r.Context(), user.ID)
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	if _, err := h.Rewrap.Schedule(r.Context(), user.ID); err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(newKeysResponse(key))
+}
+
+// ScheduleRewrap godoc
+// @Summary Rewrap stored cards
+// @Description Schedules the rewrap of every stored card of the authenticated user to the latest key version
+// @Tags keys
+// @Produce json
+// @Success 202 {object} dtos.RewrapJob
+// @Failure 403 {object} problem.Problem "Missing the keys:rotate permission"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /keys/rewrap [post]
+// @Security Bearer
+// @Security ApiKey
+func (h *KeysHandler) ScheduleRewrap(w http.ResponseWriter, r *http.Request) {
+	user, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	job, err := h.Rewrap.Schedule(r.Context(), user.ID)
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetRewrap godoc
+// @Summary Get a rewrap job
+// @Description Returns the progress of a rewrap job
+// @Tags keys
+// @Produce json
+// @Param jobID path string true "Job ID"
+// @Success 200 {object} dtos.RewrapJob
+// @Failure 400 {object} problem.Problem "Invalid job ID"
+// @Failure 404 {object} problem.Problem "Job not found"
+// @Failure 403 {object} problem.Problem "Missing the keys:read permission"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /keys/rewrap/{jobID} [get]
+// @Security Bearer
+// @Security ApiKey
+func (h *KeysHandler) GetRewrap(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(chi.URLParam(r, "jobID"))
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "invalid job ID")
+		return
+	}
+
+	user, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	job, err := h.Rewrap.Get(r.Context(), user.ID, jobID)
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(job)
+}
+
+// ScheduleUserRewrap godoc
+// @Summary Rewrap the stored cards of a user
+// @Description Schedules the rewrap of every stored card of a user to the latest version of their key, e.g. after the key was rotated in the KMS by an operator
+// @Tags keys
+// @Produce json
+// @Param userID path string true "User ID"
+// @Success 202 {object} dtos.RewrapJob
+// @Failure 400 {object} problem.Problem "Invalid user ID"
+// @Failure 403 {object} problem.Problem "Missing the keys:admin permission"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /keys/admin/users/{userID}/rewrap [post]
+// @Security Bearer
+// @Security ApiKey
+func (h *KeysHandler) ScheduleUserRewrap(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	job, err := h.Rewrap.Schedule(r.Context(), userID)
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetAnyRewrap godoc
+// @Summary Get any rewrap job
+// @Description Returns the progress of a rewrap job of any user
+// @Tags keys
+// @Produce json
+// @Param jobID path string true "Job ID"
+// @Success 200 {object} dtos.RewrapJob
+// @Failure 400 {object} problem.Problem "Invalid job ID"
+// @Failure 403 {object} problem.Problem "Missing the keys:admin permission"
+// @Failure 404 {object} problem.Problem "Job not found"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /keys/admin/rewrap/{jobID} [get]
+// @Security Bearer
+// @Security ApiKey
+func (h *KeysHandler) GetAnyRewrap(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(chi.URLParam(r, "jobID"))
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "invalid job ID")
+		return
+	}
+
+	job, err := h.Rewrap.GetJob(r.Context(), jobID)
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(job)
+}
+
+func newKeysResponse(key *keys.Key) *KeysResponse {
+	return &KeysResponse{
+		PublicKey: key.PublicKey,
+		Version:   key.Version,
+	}
 }
@@ -0,0 +1,78 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/juaguz/yuno/internal/cards/dtos"
+	"github.com/juaguz/yuno/internal/keys"
+	"github.com/juaguz/yuno/kit/kms"
+	"github.com/juaguz/yuno/kit/users/auth"
+	"github.com/juaguz/yuno/kit/users/dto"
+	"github.com/juaguz/yuno/pkg/keys/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// missingKeys is a KMS where no user has a key yet.
+type missingKeys struct{}
+
+func (missingKeys) GetPublicKey(ctx context.Context, keyID string) (string, int, error) {
+	return "", 0, fmt.Errorf("getting public key: %w", kms.ErrKeyNotFound)
+}
+
+func (missingKeys) CreateKey(ctx context.Context, keyID string) error {
+	return nil
+}
+
+func (missingKeys) RotateKey(ctx context.Context, keyID string) error {
+	return fmt.Errorf("rotating key: %w", kms.ErrKeyNotFound)
+}
+
+type noRewrap struct{}
+
+func (noRewrap) Schedule(ctx context.Context, userID uuid.UUID) (*dtos.RewrapJob, error) {
+	return nil, fmt.Errorf("no rewrap expected")
+}
+
+func (noRewrap) Get(ctx context.Context, userID uuid.UUID, jobID uuid.UUID) (*dtos.RewrapJob, error) {
+	return nil, fmt.Errorf("no rewrap expected")
+}
+
+func (noRewrap) GetJob(ctx context.Context, jobID uuid.UUID) (*dtos.RewrapJob, error) {
+	return nil, fmt.Errorf("no rewrap expected")
+}
+
+func TestKeysHandler_MissingKey(t *testing.T) {
+	handler := api.NewKeysHandler(keys.NewKeysProvider(missingKeys{}), noRewrap{}).Routes()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{"get", http.MethodGet, "/"},
+		{"rotate", http.MethodPost, "/rotate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), auth.UserKey, &dto.User{ID: uuid.New()})
+			ctx = context.WithValue(ctx, auth.ClaimsKey, &auth.UserClaims{Scope: "keys:read keys:rotate"})
+			r := httptest.NewRequest(tt.method, tt.path, nil).WithContext(ctx)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			require.Equal(t, http.StatusNotFound, w.Code)
+			var body map[string]interface{}
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+			assert.Equal(t, "key not found, create one first", body["detail"])
+		})
+	}
+}
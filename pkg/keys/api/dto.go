@@ -2,4 +2,5 @@ package api
 
 type KeysResponse struct {
 	PublicKey string `json:"public_key"`
+	Version   int    `json:"version"`
 }
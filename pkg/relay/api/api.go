@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/juaguz/yuno/internal/relay"
+	"github.com/juaguz/yuno/kit/errors/problem"
+	"github.com/juaguz/yuno/kit/users/auth"
+	"github.com/juaguz/yuno/pkg/apierrors"
+)
+
+// PermissionCardsRelay is required to send the values of a card to an upstream.
+const PermissionCardsRelay = "cards:relay"
+
+type RelayHandler struct {
+	Service Forwarder
+}
+
+type Forwarder interface {
+	Forward(ctx context.Context, req *relay.Request) (*relay.Response, error)
+}
+
+func NewRelayHandler(service Forwarder) *RelayHandler {
+	return &RelayHandler{Service: service}
+}
+
+// Routes configures the routes for RelayHandler
+func (h *RelayHandler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.With(auth.Require(PermissionCardsRelay)).Post("/", h.Forward)
+
+	return r
+}
+
+// Forward godoc
+// @Summary Relay a request to a payment provider
+// @Description Forwards a request to an allow-listed upstream replacing {{card.pan}} and {{card.holder}} in the body and headers with the stored card values
+// @Tags relay
+// @Accept json
+// @Produce json
+// @Param request body RelayRequest true "Relay Request"
+// @Success 200 {object} relay.Response
+// @Failure 400 {object} problem.Problem "Invalid request"
+// @Failure 403 {object} problem.Problem "Missing the cards:relay permission, or upstream host not allowed"
+// @Failure 404 {object} problem.Problem "Card not found"
+// @Failure 502 {object} problem.Problem "Upstream request failed"
+// @Failure 500 {object} problem.Problem "Internal server error"
+// @Router /relay [post]
+// @Security Bearer
+// @Security ApiKey
+func (h *RelayHandler) Forward(w http.ResponseWriter, r *http.Request) {
+	var body RelayRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		problem.Error(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	res, err := h.Service.Forward(r.Context(), &relay.Request{
+		UserID:  user.ID,
+		CardID:  body.CardID,
+		URL:     body.URL,
+		Method:  body.Method,
+		Headers: body.Headers,
+		Body:    body.Body,
+	})
+	if err != nil {
+		apierrors.Write(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
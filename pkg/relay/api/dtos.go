@@ -0,0 +1,12 @@
+package api
+
+import "github.com/google/uuid"
+
+type RelayRequest struct {
+	CardID  uuid.UUID         `json:"card_id"`
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	// Body may reference the card with {{card.pan}} and {{card.holder}}
+	Body string `json:"body"`
+}